@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/auth"
 	"github.com/wambozi/elastic-search-api/m/pkg/clients"
 	"github.com/wambozi/elastic-search-api/m/pkg/logging"
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
 	"github.com/wambozi/elastic-search-api/m/pkg/serving"
+	"github.com/wambozi/elastic-search-api/m/pkg/tracing"
 )
 
 var (
@@ -38,6 +43,21 @@ func run(logger *logrus.Logger) error {
 		return err
 	}
 
+	tracerProvider, err := tracing.Setup(c.Tracing, logger)
+	if err != nil {
+		return err
+	}
+	if tracerProvider != nil {
+		// spans still queued are sent once the server has stopped
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tracerProvider.Shutdown(ctx); err != nil {
+				logger.Error(err)
+			}
+		}()
+	}
+
 	elasticConfig := clients.GenerateElasticConfig([]string{c.Elasticsearch.Endpoint}, c.Elasticsearch.Username, c.Elasticsearch.Password)
 	elasticClient, err := clients.CreateElasticClient(elasticConfig)
 	if err != nil {
@@ -59,11 +79,68 @@ func run(logger *logrus.Logger) error {
 	// now that we've added the ELastic hook to the logger, we'll log errs as they occur so they show
 	// up in Elasticsearch but still return them so they are logged in the console
 
-	logger.Infof("Configuration : %+v", c)
+	logger.Infof("Configuration : %+v", c.Redacted())
+
+	if err := searching.PutQueriesTemplate(elasticClient); err != nil {
+		logger.Error(err)
+		return err
+	}
+	if err := searching.PutPinsTemplate(elasticClient); err != nil {
+		logger.Error(err)
+		return err
+	}
+	if err := searching.PutRulesTemplate(elasticClient); err != nil {
+		logger.Error(err)
+		return err
+	}
 
 	r := httprouter.New()
 
-	server := serving.NewServer(c, elasticClient, r, logger)
+	queryLog := searching.NewQueryLog(elasticClient, c.QueryLog, logger)
+	searcher := searching.NewElastic(elasticClient, queryLog, logger)
+
+	profiles, err := searching.NewProfiles(serving.SearchProfiles(c))
+	if err != nil {
+		return err
+	}
+	// search profiles are reloaded when the config file changes, and kept when the new ones are invalid
+	conf.Watch(func(c *conf.Configuration, err error) {
+		if err == nil {
+			err = profiles.Set(serving.SearchProfiles(c))
+		}
+		if err != nil {
+			logger.Errorf("Error reloading search profiles: %s", err)
+			return
+		}
+		logger.Infof("Search profiles reloaded : %s", strings.Join(profiles.Names(), ", "))
+	})
+
+	authenticator, err := auth.New(c.Auth)
+	if err != nil {
+		return err
+	}
+	if authenticator == nil {
+		logger.Warn("No API keys or JWKS configured: requests aren't authenticated")
+	}
+
+	var store serving.RateLimitStore
+	switch c.RateLimit.Store {
+	case "", "memory":
+		store = serving.NewMemoryStore()
+	case "redis":
+		store = serving.NewRedisStore(serving.NewRedisClient(c.Redis))
+	default:
+		return fmt.Errorf("rate limit store must be memory or redis, got %q", c.RateLimit.Store)
+	}
+	limiter, err := serving.NewRateLimiter(c.RateLimit, store)
+	if err != nil {
+		return err
+	}
+
+	server := serving.NewServer(c, searcher, queryLog, r, logger)
+	server.Profiles = profiles
+	server.Auth = authenticator
+	server.RateLimiter = limiter
 	logger.Infof("Server components: %+v", server)
 
 	httpServer := server.NewHTTPServer(c)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/fsnotify/fsnotify"
 	"github.com/go-redis/redis"
 	"github.com/kataras/go-events"
 	"github.com/sirupsen/logrus"
@@ -15,6 +16,22 @@ type Configuration struct {
 	Server        ServerConfiguration
 	Elasticsearch ElasticOptions
 	Redis         RedisOptions
+	Suggest       SuggestOptions
+	QueryLog      QueryLogOptions
+	Indices       IndicesOptions
+	// Sortable holds the fields that can be sorted on by index name or pattern
+	Sortable map[string][]SortableField
+	// Tiebreakers holds the keyword field unique per document that breaks ties between hits, by index name
+	// or pattern
+	Tiebreakers map[string]string
+	// Profiles holds the search profiles by name, reloaded when the config file changes
+	Profiles    map[string]ProfileOptions
+	QuerySyntax QuerySyntaxOptions
+	Rules       RulesOptions
+	Health      HealthOptions
+	Tracing     TracingOptions
+	Auth        AuthOptions
+	RateLimit   RateLimitOptions
 }
 
 // RedisOptions for the Redis Client
@@ -40,10 +57,198 @@ type ElasticOptions struct {
 	Password string
 }
 
+// SuggestOptions holds configuration values for the GET /suggest route
+type SuggestOptions struct {
+	// CompletionField is a completion field to take suggestions from, preferred over Fields when set
+	CompletionField string
+	// Fields are search_as_you_type or edge-ngram fields to take suggestions from
+	Fields     []string
+	Size       int
+	LogQueries bool
+}
+
+// QueryLogOptions holds configuration values for the pipeline indexing logged queries
+type QueryLogOptions struct {
+	// QueueSize is how many queries can wait to be indexed before new ones are dropped
+	QueueSize int
+	// BatchSize is how many queries are indexed per _bulk request
+	BatchSize           int
+	FlushIntervalMillis int
+}
+
+// IndicesOptions holds the indices clients can search. When neither is set, any index can be searched.
+type IndicesOptions struct {
+	// Aliases maps the logical names clients search by to the indices, aliases or patterns behind them
+	Aliases map[string][]string
+	// Allow holds the patterns of the indices clients can search by their own name, e.g. crawler-*
+	Allow []string
+}
+
+// SortableField is a field that can be sorted on, and its type: keyword, numeric, date or geo_point
+type SortableField struct {
+	Field string
+	Type  string
+}
+
+// ProfileOptions holds how a search profile matches the search term: its fields, with optional ^boosts,
+// multi_match options and score adjustments, or the ID of a stored search template
+type ProfileOptions struct {
+	Fields             []string
+	Type               string
+	Fuzziness          string
+	Operator           string
+	MinimumShouldMatch string
+	Decay              *DecayOptions
+	Popularity         *PopularityOptions
+	BoostMode          string
+	Template           string
+}
+
+// DecayOptions holds how a search profile scores down older documents
+type DecayOptions struct {
+	// Function is gauss, exp or linear
+	Function   string
+	Field      string
+	Origin     string
+	Scale      string
+	Offset     string
+	DecayValue float64
+}
+
+// PopularityOptions holds how a search profile scores up popular documents
+type PopularityOptions struct {
+	Field    string
+	Factor   float64
+	Modifier string
+	Missing  *float64
+}
+
+// QuerySyntaxOptions holds the fields advanced searches can match and filter on
+type QuerySyntaxOptions struct {
+	// Fields maps the prefixes users can type, e.g. title:, to the fields they match
+	Fields map[string]string
+	// Site is the keyword field site: filters on
+	Site string
+	// Date is the date field after: and before: filter on
+	Date string
+}
+
+// RulesOptions holds what query rules can do
+type RulesOptions struct {
+	// RedirectHosts holds the hosts redirect rules can send searches to, besides the paths of the site
+	RedirectHosts []string
+}
+
+// HealthOptions holds what GET /readyz checks before reporting the API ready to serve searches
+type HealthOptions struct {
+	// RequiredIndices are the indices or aliases that must exist
+	RequiredIndices []string
+	// RequireGreen fails the check of a yellow cluster, which passes otherwise
+	RequireGreen bool
+}
+
+// TracingOptions holds where the spans of requests are exported
+type TracingOptions struct {
+	// Exporter is stdout or otlp. Spans aren't recorded when it is empty.
+	Exporter string
+	// Endpoint is the URL OTLP spans are posted to, e.g. http://localhost:4318/v1/traces
+	Endpoint string
+	// Headers are sent with the OTLP requests, e.g. to authenticate them
+	Headers map[string]string
+	// ServiceName names the API in the traces, elastic-search-api by default
+	ServiceName string
+	// SampleRatio is the share of the traces started by the API that are recorded, from 0 to 1, all of
+	// them by default. Traces continued from a caller are recorded when the caller recorded them.
+	SampleRatio *float64
+}
+
+// AuthOptions holds how clients authenticate, and what each can do. Requests aren't authenticated when
+// neither API keys nor a JWKS file are configured.
+type AuthOptions struct {
+	APIKeys []APIKeyOptions
+	JWT     JWTOptions
+	// Clients holds the scopes and indices of clients by ID
+	Clients map[string]ClientOptions
+}
+
+// APIKeyOptions holds an API key, by its SHA-256 hash in hex, and the client it identifies
+type APIKeyOptions struct {
+	Client string
+	SHA256 string
+}
+
+// JWTOptions holds how bearer tokens are verified
+type JWTOptions struct {
+	// JWKSFile is the path of the JWKS holding the public keys tokens are signed with
+	JWKSFile string
+	// Issuer and Audience are the iss and aud tokens must have, unchecked when empty
+	Issuer   string
+	Audience string
+	// ClientClaim is the claim identifying the client, sub by default
+	ClientClaim string
+}
+
+// ClientOptions holds what a client can do
+type ClientOptions struct {
+	// Scopes are the scopes of the routes the client can call: search, analytics or admin
+	Scopes []string
+	// Indices are the patterns of the names the client can search by, any name when empty
+	Indices []string
+}
+
+// RateLimitOptions holds how many requests each client can make to each route
+type RateLimitOptions struct {
+	// Routes holds the limits by route path, e.g. "/search". Routes without one aren't limited.
+	Routes map[string]RateLimit
+	// IPRoutes holds the limits of each IP by route path, checked before requests are authenticated or
+	// logged. Routes without one are limited by IP as in Routes.
+	IPRoutes map[string]RateLimit
+	// TrustedProxies holds the IPs or CIDRs of the proxies whose X-Forwarded-For header is trusted
+	TrustedProxies []string
+	// Store is memory, the default, or redis to share limits across replicas through the Redis options
+	Store string
+}
+
+// RateLimit is a token bucket: it holds Burst requests, and refills at RequestsPerSecond
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
 //ServerConfiguration holds configuration values for the server
 type ServerConfiguration struct {
 	Port                    int
 	ReadHeaderTimeoutMillis int
+	// SearchTimeoutMillis bounds how long a route may wait on Elasticsearch, unless RouteTimeoutsMillis
+	// has a timeout for the route
+	SearchTimeoutMillis int
+	// RouteTimeoutsMillis holds timeouts by route path, e.g. "/suggest"
+	RouteTimeoutsMillis map[string]int
+	// ShutdownDelayMillis is how long GET /readyz fails before the server stops accepting requests when
+	// shutting down, for load balancers to stop sending it traffic
+	ShutdownDelayMillis int
+}
+
+// redacted replaces the secrets of a configuration that is logged
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of the configuration that can be logged, with its passwords and the values of
+// the tracing headers, which usually carry credentials, redacted
+func (c Configuration) Redacted() Configuration {
+	if c.Elasticsearch.Password != "" {
+		c.Elasticsearch.Password = redacted
+	}
+	if c.Redis.Password != "" {
+		c.Redis.Password = redacted
+	}
+	if c.Tracing.Headers != nil {
+		headers := make(map[string]string, len(c.Tracing.Headers))
+		for k := range c.Tracing.Headers {
+			headers[k] = redacted
+		}
+		c.Tracing.Headers = headers
+	}
+	return c
 }
 
 //GetEnvironment determine the environment in which this application is deployed
@@ -90,3 +295,17 @@ func Setup(env string) (*Configuration, error) {
 
 	return &configs, nil
 }
+
+// Watch calls onChange with the configuration read again whenever the config file read by Setup changes.
+// Changes that can't be read are passed on as errors.
+func Watch(onChange func(*Configuration, error)) {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		var configs Configuration
+		if err := viper.Unmarshal(&configs); err != nil {
+			onChange(nil, fmt.Errorf("Unable to unmarshal into struct: %w", err))
+			return
+		}
+		onChange(&configs, nil)
+	})
+	viper.WatchConfig()
+}
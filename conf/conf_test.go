@@ -71,6 +71,29 @@ func TestSetup(t *testing.T) {
 	}
 }
 
+func TestRedacted(t *testing.T) {
+	c := Configuration{
+		Elasticsearch: ElasticOptions{Endpoint: "http://localhost:9200", Username: "elastic", Password: "changeme"},
+		Redis:         RedisOptions{Host: "localhost", Port: 6379, Password: "hunter2"},
+		Tracing:       TracingOptions{Exporter: "otlp", Headers: map[string]string{"Authorization": "Bearer abc"}},
+	}
+
+	want := Configuration{
+		Elasticsearch: ElasticOptions{Endpoint: "http://localhost:9200", Username: "elastic", Password: "[REDACTED]"},
+		Redis:         RedisOptions{Host: "localhost", Port: 6379, Password: "[REDACTED]"},
+		Tracing:       TracingOptions{Exporter: "otlp", Headers: map[string]string{"Authorization": "[REDACTED]"}},
+	}
+	if diff := cmp.Diff(want, c.Redacted()); diff != "" {
+		t.Fatalf(diff)
+	}
+	if c.Tracing.Headers["Authorization"] != "Bearer abc" {
+		t.Fatalf("the configuration redacted should be left as it is, received : %+v", c.Tracing.Headers)
+	}
+	if diff := cmp.Diff(Configuration{}, Configuration{}.Redacted()); diff != "" {
+		t.Fatalf("unset secrets should be left unset: %s", diff)
+	}
+}
+
 func TestGetEnv(t *testing.T) {
 	tests := make(map[string]string)
 	tests[""] = "<nil>"
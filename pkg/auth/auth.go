@@ -0,0 +1,245 @@
+// Package auth identifies the clients calling the API, by a static API key or a JWT bearer token, and
+// holds what each client is allowed to do: the scopes of the routes it can call, and the indices it can
+// search.
+//
+// API keys are configured by their SHA-256 hash, so the config file doesn't hold them, and tokens are
+// verified against the public keys of a local JWKS file.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wambozi/elastic-search-api/m/conf"
+)
+
+// The headers credentials are sent in
+const (
+	APIKeyHeader        = "X-API-Key"
+	AuthorizationHeader = "Authorization"
+)
+
+// The scopes routes require
+const (
+	// ScopeSearch is required to search, take suggestions and record clicks
+	ScopeSearch = "search"
+	// ScopeAnalytics is required to read the search analytics
+	ScopeAnalytics = "analytics"
+	// ScopeAdmin is required to manage pinned results and rewrite rules
+	ScopeAdmin = "admin"
+)
+
+// The methods clients authenticate with
+const (
+	MethodAPIKey = "apiKey"
+	MethodJWT    = "jwt"
+)
+
+// The kinds of errors auth returns, classified with errors.Is
+var (
+	// ErrUnauthenticated is returned when a request has no credentials, or ones that aren't valid
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned when a client calls a route without its scope
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Error is an error of one of the kinds above
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the target kind
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+func unauthenticated(format string, a ...interface{}) error {
+	return &Error{Kind: ErrUnauthenticated, Err: fmt.Errorf(format, a...)}
+}
+
+// Client is who a request was authenticated as
+type Client struct {
+	ID     string
+	Method string
+	// Scopes are the scopes of the routes the client can call
+	Scopes []string
+	// Indices are the patterns of the names the client can search by, any name when empty
+	Indices []string
+}
+
+// HasScope reports whether the client can call the routes requiring the scope
+func (c *Client) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize returns an error of kind ErrForbidden when the client can't call routes requiring the scope
+func (c *Client) Authorize(scope string) error {
+	if c.HasScope(scope) {
+		return nil
+	}
+	return &Error{Kind: ErrForbidden, Err: fmt.Errorf("client %q doesn't have the %s scope", c.ID, scope)}
+}
+
+type contextKey string
+
+const clientKey contextKey = "client"
+
+// NewContext returns the context of a request authenticated as the client
+func NewContext(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey, c)
+}
+
+// FromContext returns the client the request of the context was authenticated as, or nil
+func FromContext(ctx context.Context) *Client {
+	c, _ := ctx.Value(clientKey).(*Client)
+	return c
+}
+
+// HashKey returns the SHA-256 hash of an API key, in hex, as it is configured
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKey is a configured API key, by its hash
+type apiKey struct {
+	hash   []byte
+	client string
+}
+
+// Authenticator authenticates requests with the configured API keys and JWKS
+type Authenticator struct {
+	keys    []apiKey
+	jwks    *JWKS
+	jwt     conf.JWTOptions
+	clients map[string]conf.ClientOptions
+	// now returns the time tokens are checked at
+	now func() time.Time
+}
+
+// New returns the authenticator of the options, or nil when neither API keys nor a JWKS file are
+// configured, leaving only the search routes open
+func New(opts conf.AuthOptions) (*Authenticator, error) {
+	if len(opts.APIKeys) == 0 && opts.JWT.JWKSFile == "" {
+		return nil, nil
+	}
+
+	a := &Authenticator{jwt: opts.JWT, clients: map[string]conf.ClientOptions{}, now: time.Now}
+	for id, c := range opts.Clients {
+		a.clients[clientID(id)] = c
+	}
+	for i, k := range opts.APIKeys {
+		hash, err := hex.DecodeString(k.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("API key %d must be a SHA-256 hash in hex", i)
+		}
+		if _, ok := a.clients[clientID(k.Client)]; !ok {
+			return nil, fmt.Errorf("API key %d is for client %q, which isn't configured", i, k.Client)
+		}
+		a.keys = append(a.keys, apiKey{hash: hash, client: clientID(k.Client)})
+	}
+	if opts.JWT.JWKSFile != "" {
+		// any issuer's tokens signed with the keys would do otherwise
+		if opts.JWT.Issuer == "" && opts.JWT.Audience == "" {
+			return nil, fmt.Errorf("bearer tokens need an issuer or audience to be checked against")
+		}
+		jwks, err := LoadJWKS(opts.JWT.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.jwks = jwks
+	}
+	return a, nil
+}
+
+// clientID returns the ID a client is known by. The config lowercases the IDs of the clients it holds, so
+// IDs are compared in lower case.
+func clientID(id string) string {
+	return strings.ToLower(id)
+}
+
+// Authenticate returns the client the request was sent by, from its X-API-Key header or its bearer token.
+// Errors are of kind ErrUnauthenticated.
+func (a *Authenticator) Authenticate(r *http.Request) (*Client, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return a.apiKeyClient(key)
+	}
+
+	h := r.Header.Get(AuthorizationHeader)
+	if h == "" {
+		return nil, unauthenticated("requests need an API key in %s or a bearer token in %s", APIKeyHeader, AuthorizationHeader)
+	}
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return nil, unauthenticated("%s must be a bearer token", AuthorizationHeader)
+	}
+	return a.tokenClient(strings.TrimSpace(h[7:]))
+}
+
+// apiKeyClient returns the client of the API key. Every hash is compared, in constant time, so the time
+// taken doesn't tell how close a key came.
+func (a *Authenticator) apiKeyClient(key string) (*Client, error) {
+	sum := sha256.Sum256([]byte(key))
+	id := ""
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 {
+			id = k.client
+		}
+	}
+	if id == "" {
+		return nil, unauthenticated("invalid API key")
+	}
+	c := a.clients[id]
+	return &Client{ID: id, Method: MethodAPIKey, Scopes: c.Scopes, Indices: c.Indices}, nil
+}
+
+// tokenClient returns the client of the bearer token, which must be configured
+func (a *Authenticator) tokenClient(token string) (*Client, error) {
+	if a.jwks == nil {
+		return nil, unauthenticated("bearer tokens aren't accepted")
+	}
+	claims, err := a.jwks.Verify(token)
+	if err != nil {
+		return nil, unauthenticated("invalid bearer token: %s", err)
+	}
+	if err := claims.check(a.jwt, a.now()); err != nil {
+		return nil, unauthenticated("invalid bearer token: %s", err)
+	}
+
+	claim := a.jwt.ClientClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	sub, _ := claims[claim].(string)
+	if sub == "" {
+		return nil, unauthenticated("invalid bearer token: no %s claim", claim)
+	}
+
+	id := clientID(sub)
+	c, ok := a.clients[id]
+	if !ok {
+		return nil, unauthenticated("client %q of the bearer token isn't configured", sub)
+	}
+	return &Client{ID: id, Method: MethodJWT, Scopes: c.Scopes, Indices: c.Indices}, nil
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/viper"
+	"github.com/wambozi/elastic-search-api/m/conf"
+)
+
+// writeJWKS writes the JWKS of the signer to a file in a temporary directory, and returns its path
+func writeJWKS(t *testing.T, s *signer) string {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatalf("Unexpected error creating directory: %s", err)
+	}
+
+	path := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(path, []byte(s.jwks()), 0600); err != nil {
+		t.Fatalf("Unexpected error writing JWKS: %s", err)
+	}
+	return path
+}
+
+func TestNew(t *testing.T) {
+	clients := map[string]conf.ClientOptions{"web": {Scopes: []string{ScopeSearch}}}
+
+	tests := map[string]struct {
+		opts conf.AuthOptions
+		err  string
+	}{
+		"open":           {opts: conf.AuthOptions{Clients: clients}},
+		"api key":        {opts: conf.AuthOptions{APIKeys: []conf.APIKeyOptions{{Client: "web", SHA256: HashKey("secret")}}, Clients: clients}},
+		"not a hash":     {opts: conf.AuthOptions{APIKeys: []conf.APIKeyOptions{{Client: "web", SHA256: "secret"}}, Clients: clients}, err: "API key 0 must be a SHA-256 hash in hex"},
+		"unknown client": {opts: conf.AuthOptions{APIKeys: []conf.APIKeyOptions{{Client: "app", SHA256: HashKey("secret")}}, Clients: clients}, err: `API key 0 is for client "app", which isn't configured`},
+		"no jwks":        {opts: conf.AuthOptions{JWT: conf.JWTOptions{JWKSFile: "does-not-exist.json", Issuer: "https://auth.example.com/"}}, err: "Error reading JWKS: open does-not-exist.json: no such file or directory"},
+		"any issuer":     {opts: conf.AuthOptions{JWT: conf.JWTOptions{JWKSFile: "jwks.json"}}, err: "bearer tokens need an issuer or audience to be checked against"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, err := New(tc.opts)
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+			if name == "open" && a != nil {
+				t.Fatalf("nothing to authenticate with should leave the API open, received : %+v", a)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newSigner(t)
+	jwks := writeJWKS(t, s)
+	defer os.RemoveAll(filepath.Dir(jwks))
+
+	a, err := New(conf.AuthOptions{
+		APIKeys: []conf.APIKeyOptions{
+			{Client: "web", SHA256: HashKey("web-key")},
+			{Client: "admin", SHA256: HashKey("admin-key")},
+		},
+		JWT: conf.JWTOptions{JWKSFile: jwks, Issuer: "https://auth.example.com/", Audience: "search-api"},
+		Clients: map[string]conf.ClientOptions{
+			"web":   {Scopes: []string{ScopeSearch}, Indices: []string{"docs"}},
+			"admin": {Scopes: []string{ScopeSearch, ScopeAnalytics, ScopeAdmin}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating authenticator: %s", err)
+	}
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	token := func(changes map[string]interface{}) string {
+		claims := map[string]interface{}{"iss": "https://auth.example.com/", "aud": "search-api", "sub": "web", "exp": now.Add(time.Hour).Unix()}
+		for k, v := range changes {
+			claims[k] = v
+		}
+		return s.sign(t, "RS256", "rsa-1", claims)
+	}
+
+	tests := map[string]struct {
+		headers map[string]string
+		client  *Client
+		err     string
+	}{
+		"api key":           {headers: map[string]string{"X-API-Key": "admin-key"}, client: &Client{ID: "admin", Method: MethodAPIKey, Scopes: []string{"search", "analytics", "admin"}}},
+		"invalid api key":   {headers: map[string]string{"X-API-Key": "guess"}, err: "invalid API key"},
+		"configured token":  {headers: map[string]string{"Authorization": "Bearer " + token(nil)}, client: &Client{ID: "web", Method: MethodJWT, Scopes: []string{"search"}, Indices: []string{"docs"}}},
+		"case of subject":   {headers: map[string]string{"Authorization": "bearer " + token(map[string]interface{}{"sub": "Web"})}, client: &Client{ID: "web", Method: MethodJWT, Scopes: []string{"search"}, Indices: []string{"docs"}}},
+		"unknown client":    {headers: map[string]string{"Authorization": "Bearer " + token(map[string]interface{}{"sub": "app", "scope": "search analytics admin"})}, err: `client "app" of the bearer token isn't configured`},
+		"expired token":     {headers: map[string]string{"Authorization": "Bearer " + token(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})}, err: "invalid bearer token: token expired"},
+		"no subject":        {headers: map[string]string{"Authorization": "Bearer " + token(map[string]interface{}{"sub": ""})}, err: "invalid bearer token: no sub claim"},
+		"basic":             {headers: map[string]string{"Authorization": "Basic d2ViOmtleQ=="}, err: "Authorization must be a bearer token"},
+		"no credentials":    {err: "requests need an API key in X-API-Key or a bearer token in Authorization"},
+		"key before bearer": {headers: map[string]string{"X-API-Key": "web-key", "Authorization": "Bearer " + token(map[string]interface{}{"sub": "admin"})}, client: &Client{ID: "web", Method: MethodAPIKey, Scopes: []string{"search"}, Indices: []string{"docs"}}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/search", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			client, err := a.Authenticate(r)
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+				if !errors.Is(err, ErrUnauthenticated) {
+					t.Fatalf("error - expected : %s, received : %v", ErrUnauthenticated, err)
+				}
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+			if diff := cmp.Diff(tc.client, client); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestMixedCaseClients(t *testing.T) {
+	s := newSigner(t)
+	jwks := writeJWKS(t, s)
+	defer os.RemoveAll(filepath.Dir(jwks))
+
+	// read as conf.Setup reads the config file, which lowercases the client IDs
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(strings.NewReader(`
+auth:
+  apiKeys:
+    - client: Widget
+      sha256: ` + HashKey("widget-key") + `
+  jwt:
+    jwksFile: ` + jwks + `
+    issuer: https://auth.example.com/
+  clients:
+    Widget:
+      scopes:
+        - search
+      indices:
+        - docs
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error reading config: %s", err)
+	}
+	var c conf.Configuration
+	if err := v.Unmarshal(&c); err != nil {
+		t.Fatalf("Unexpected error unmarshaling config: %s", err)
+	}
+
+	a, err := New(c.Auth)
+	if err != nil {
+		t.Fatalf("Unexpected error creating authenticator: %s", err)
+	}
+	expected := &Client{ID: "widget", Scopes: []string{"search"}, Indices: []string{"docs"}}
+
+	r := httptest.NewRequest("GET", "/search", nil)
+	r.Header.Set("X-API-Key", "widget-key")
+	client, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Unexpected error authenticating the API key: %s", err)
+	}
+	expected.Method = MethodAPIKey
+	if diff := cmp.Diff(expected, client); diff != "" {
+		t.Fatalf(diff)
+	}
+
+	r = httptest.NewRequest("GET", "/search", nil)
+	r.Header.Set("Authorization", "Bearer "+s.sign(t, "ES256", "ec-1", map[string]interface{}{"iss": "https://auth.example.com/", "sub": "Widget", "exp": time.Now().Add(time.Hour).Unix()}))
+	client, err = a.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Unexpected error authenticating the bearer token: %s", err)
+	}
+	expected.Method = MethodJWT
+	if diff := cmp.Diff(expected, client); diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	c := &Client{ID: "web", Scopes: []string{ScopeSearch}}
+	if err := c.Authorize(ScopeSearch); err != nil {
+		t.Fatalf("Unexpected error authorizing the search scope: %s", err)
+	}
+	err := c.Authorize(ScopeAdmin)
+	if !errors.Is(err, ErrForbidden) || err.Error() != `client "web" doesn't have the admin scope` {
+		t.Fatalf("error - expected : %s, received : %v", ErrForbidden, err)
+	}
+
+	if FromContext(context.Background()) != nil || FromContext(NewContext(context.Background(), c)) != c {
+		t.Fatalf("the client should be on the context it was put on")
+	}
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/wambozi/elastic-search-api/m/conf"
+)
+
+// clockSkew is how far the clocks of the API and the token issuer can drift apart
+const clockSkew = time.Minute
+
+// minRSABits is the smallest RSA modulus keys can have
+const minRSABits = 2048
+
+// signatureAlgorithms are the algorithms tokens can be signed with. HMAC and none are left out, since they
+// don't sign with a public key.
+var signatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+}
+
+// JWKS is a set of public keys tokens are signed with, as published by their issuer
+type JWKS struct {
+	keys []jose.JSONWebKey
+}
+
+// LoadJWKS reads the keys of a JWKS file. Keys that aren't for signatures are skipped.
+func LoadJWKS(path string) (*JWKS, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading JWKS: %w", err)
+	}
+	return ParseJWKS(b)
+}
+
+// ParseJWKS reads the keys of a JWKS. Keys that aren't for signatures are skipped. Keys must be public RSA
+// keys of at least 2048 bits, or EC keys.
+func ParseJWKS(b []byte) (*JWKS, error) {
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("Error parsing JWKS: %w", err)
+	}
+
+	jwks := &JWKS{}
+	for i, raw := range set.Keys {
+		var k jose.JSONWebKey
+		if err := k.UnmarshalJSON(raw); err != nil {
+			return nil, fmt.Errorf("Error parsing JWKS key %d: %w", i, err)
+		}
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if err := checkKey(k); err != nil {
+			return nil, fmt.Errorf("Error parsing JWKS key %d: %w", i, err)
+		}
+		jwks.keys = append(jwks.keys, k)
+	}
+	if len(jwks.keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no signing keys")
+	}
+	return jwks, nil
+}
+
+// checkKey checks the key is a public RSA or EC key, and RSA keys are long enough
+func checkKey(k jose.JSONWebKey) error {
+	switch key := k.Key.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSABits {
+			return fmt.Errorf("RSA keys must have at least %d bits, got %d", minRSABits, key.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+	default:
+		return fmt.Errorf("key must be a public RSA or EC key")
+	}
+	if !k.Valid() {
+		return fmt.Errorf("invalid key")
+	}
+	return nil
+}
+
+// Claims are the claims of a token
+type Claims map[string]interface{}
+
+// Verify checks the signature of a compact JWT against the keys, and returns its claims. The key is the
+// one of the token's kid, or the only key when the token has none. Claims aren't checked.
+func (s *JWKS) Verify(token string) (Claims, error) {
+	jws, err := jose.ParseSignedCompact(token, signatureAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+	header := jws.Signatures[0].Header
+	key, err := s.key(header.KeyID, header.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	return claims, nil
+}
+
+// key returns the key of the kid, which must be of the type of the algorithm
+func (s *JWKS) key(kid, alg string) (interface{}, error) {
+	var found *jose.JSONWebKey
+	for i, k := range s.keys {
+		if k.KeyID == kid || (kid == "" && len(s.keys) == 1) {
+			found = &s.keys[i]
+			break
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no key with kid %q", kid)
+	}
+
+	_, isEC := found.Key.(*ecdsa.PublicKey)
+	if isEC != strings.HasPrefix(alg, "ES") || (found.Algorithm != "" && found.Algorithm != alg) {
+		return nil, fmt.Errorf("key %q can't verify %s signatures", found.KeyID, alg)
+	}
+	return found.Key, nil
+}
+
+// check checks the token is current, and was issued by and for who the options expect
+func (c Claims) check(opts conf.JWTOptions, now time.Time) error {
+	exp, ok := c["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := c["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	if opts.Issuer != "" && c["iss"] != opts.Issuer {
+		return fmt.Errorf("token issued by %v, not %s", c["iss"], opts.Issuer)
+	}
+	if opts.Audience != "" && !c.hasAudience(opts.Audience) {
+		return fmt.Errorf("token not meant for %s", opts.Audience)
+	}
+	return nil
+}
+
+// hasAudience reports whether the aud claim, a string or an array of them, holds the audience
+func (c Claims) hasAudience(aud string) bool {
+	switch a := c["aud"].(type) {
+	case string:
+		return a == aud
+	case []interface{}:
+		for _, v := range a {
+			if v == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/google/go-cmp/cmp"
+	"github.com/wambozi/elastic-search-api/m/conf"
+)
+
+// signer signs test tokens with the private keys of a JWKS
+type signer struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newSigner(t *testing.T) *signer {
+	r, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %s", err)
+	}
+	e, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error generating EC key: %s", err)
+	}
+	return &signer{rsa: r, ec: e}
+}
+
+// jwks returns the JWKS of the public keys: rsa-1 and ec-1, and an encryption key to be skipped
+func (s *signer) jwks() string {
+	return jwksOf(
+		jose.JSONWebKey{Key: &s.rsa.PublicKey, KeyID: "rsa-1", Use: "sig"},
+		jose.JSONWebKey{Key: &s.ec.PublicKey, KeyID: "ec-1", Algorithm: "ES256"},
+		jose.JSONWebKey{Key: &s.rsa.PublicKey, KeyID: "enc-1", Use: "enc"},
+	)
+}
+
+// jwksOf returns the JWKS of the keys
+func jwksOf(keys ...jose.JSONWebKey) string {
+	b, _ := json.Marshal(jose.JSONWebKeySet{Keys: keys})
+	return string(b)
+}
+
+// sign returns the token of the claims, signed with alg by the key of kid
+func (s *signer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	var key interface{} = s.rsa
+	if strings.HasPrefix(alg, "ES") {
+		key = s.ec
+	}
+	return signWith(t, alg, jose.JSONWebKey{Key: key, KeyID: kid}, claims)
+}
+
+// signWith returns the token of the claims, signed with alg by the key
+func signWith(t *testing.T, alg string, key jose.JSONWebKey, claims map[string]interface{}) string {
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.SignatureAlgorithm(alg), Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatalf("Unexpected error creating signer: %s", err)
+	}
+	payload, _ := json.Marshal(claims)
+	jws, err := sig.Sign(payload)
+	if err != nil {
+		t.Fatalf("Unexpected error signing token: %s", err)
+	}
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("Unexpected error serializing token: %s", err)
+	}
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	s := newSigner(t)
+	jwks, err := ParseJWKS([]byte(s.jwks()))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing JWKS: %s", err)
+	}
+	claims := map[string]interface{}{"sub": "web"}
+	rs256 := s.sign(t, "RS256", "rsa-1", claims)
+	parts := strings.Split(rs256, ".")
+
+	tests := map[string]struct {
+		token string
+		err   string
+	}{
+		"RS256":          {token: rs256},
+		"PS384":          {token: s.sign(t, "PS384", "rsa-1", claims)},
+		"ES256":          {token: s.sign(t, "ES256", "ec-1", claims)},
+		"unknown kid":    {token: s.sign(t, "RS256", "rsa-2", claims), err: `no key with kid "rsa-2"`},
+		"encryption key": {token: s.sign(t, "RS256", "enc-1", claims), err: `no key with kid "enc-1"`},
+		"wrong key type": {token: s.sign(t, "RS256", "ec-1", claims), err: `key "ec-1" can't verify RS256 signatures`},
+		"none":           {token: "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ3ZWIifQ.", err: `go-jose/go-jose: unexpected signature algorithm "none"; expected ["RS256" "RS384" "RS512" "PS256" "PS384" "PS512" "ES256" "ES384" "ES512"]`},
+		"HMAC":           {token: signWith(t, "HS256", jose.JSONWebKey{Key: []byte(strings.Repeat("k", 32)), KeyID: "rsa-1"}, claims), err: `go-jose/go-jose: unexpected signature algorithm "HS256"; expected ["RS256" "RS384" "RS512" "PS256" "PS384" "PS512" "ES256" "ES384" "ES512"]`},
+		"tampered":       {token: parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2], err: "invalid signature"},
+		"not a jwt":      {token: "secret", err: "go-jose/go-jose: compact JWS format must have three parts"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := jwks.Verify(tc.token)
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			} else if c["sub"] != "web" {
+				t.Fatalf("claims - expected : sub web, received : %v", c)
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	short, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Unexpected error generating RSA key: %s", err)
+	}
+
+	tests := map[string]struct {
+		jwks string
+		err  string
+	}{
+		"no keys":    {jwks: `{"keys":[]}`, err: "JWKS has no signing keys"},
+		"symmetric":  {jwks: `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`, err: "Error parsing JWKS key 0: key must be a public RSA or EC key"},
+		"private":    {jwks: jwksOf(jose.JSONWebKey{Key: short, KeyID: "rsa-1"}), err: "Error parsing JWKS key 0: key must be a public RSA or EC key"},
+		"short RSA":  {jwks: jwksOf(jose.JSONWebKey{Key: &short.PublicKey, KeyID: "rsa-1"}), err: "Error parsing JWKS key 0: RSA keys must have at least 2048 bits, got 1024"},
+		"curve":      {jwks: `{"keys":[{"kty":"EC","crv":"P-224","x":"AA","y":"AA"}]}`, err: "Error parsing JWKS key 0: go-jose/go-jose: unsupported elliptic curve 'P-224'"},
+		"off curve":  {jwks: `{"keys":[{"kty":"EC","crv":"P-256","x":"` + strings.Repeat("A", 42) + "Q" + `","y":"` + strings.Repeat("A", 42) + "Q" + `"}]}`, err: "Error parsing JWKS key 0: go-jose/go-jose: invalid EC key, X/Y are not on declared curve"},
+		"no modulus": {jwks: `{"keys":[{"kty":"RSA","e":"AQAB"}]}`, err: "Error parsing JWKS key 0: go-jose/go-jose: invalid RSA key, missing n/e values"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseJWKS([]byte(tc.jwks))
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestClaimsCheck(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	opts := conf.JWTOptions{Issuer: "https://auth.example.com/", Audience: "search-api"}
+	valid := func(changes map[string]interface{}) Claims {
+		c := Claims{"iss": "https://auth.example.com/", "aud": "search-api", "exp": float64(now.Add(time.Hour).Unix())}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := map[string]struct {
+		claims Claims
+		err    string
+	}{
+		"valid":          {claims: valid(nil)},
+		"audiences":      {claims: valid(map[string]interface{}{"aud": []interface{}{"web", "search-api"}})},
+		"within skew":    {claims: valid(map[string]interface{}{"exp": float64(now.Add(-30 * time.Second).Unix())})},
+		"expired":        {claims: valid(map[string]interface{}{"exp": float64(now.Add(-time.Hour).Unix())}), err: "token expired"},
+		"no expiry":      {claims: valid(map[string]interface{}{"exp": nil}), err: "no exp claim"},
+		"not yet":        {claims: valid(map[string]interface{}{"nbf": float64(now.Add(time.Hour).Unix())}), err: "token not valid yet"},
+		"other issuer":   {claims: valid(map[string]interface{}{"iss": "https://evil.example.com/"}), err: "token issued by https://evil.example.com/, not https://auth.example.com/"},
+		"other audience": {claims: valid(map[string]interface{}{"aud": []interface{}{"web"}}), err: "token not meant for search-api"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.claims.check(opts, now)
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
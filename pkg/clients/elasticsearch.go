@@ -20,13 +20,14 @@ type Document struct {
 	Body              io.Reader
 }
 
-// GenerateElasticConfig returns the elasticsearch config given the endpoint(s), username and password
+// GenerateElasticConfig returns the elasticsearch config given the endpoint(s), username and password. The
+// latency and errors of the requests made with it are recorded in the metrics.
 func GenerateElasticConfig(endpoint []string, username string, password string) elasticsearch.Config {
 	return elasticsearch.Config{
 		Addresses: endpoint,
 		Username:  username,
 		Password:  password,
-		Transport: &http.Transport{
+		Transport: newTransport(&http.Transport{
 			Dial: (&net.Dialer{
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
@@ -34,7 +35,7 @@ func GenerateElasticConfig(endpoint []string, username string, password string)
 			TLSHandshakeTimeout:   10 * time.Second,
 			ResponseHeaderTimeout: 10 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
-		},
+		}),
 	}
 }
 
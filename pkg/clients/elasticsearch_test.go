@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/gookit/color"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
 )
 
 var (
@@ -56,7 +57,10 @@ type TestBody struct {
 }
 
 func TestIndexDocument(t *testing.T) {
-	cfg := GenerateElasticConfig([]string{endpoint}, username, password)
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	cfg := GenerateElasticConfig([]string{srv.URL}, username, password)
 	client, err := CreateElasticClient(cfg)
 	if err != nil {
 		t.Errorf("Unexpected error creating Elasticsearch client: %s", err)
@@ -79,9 +83,39 @@ func TestIndexDocument(t *testing.T) {
 		Body:       r,
 	}
 
-	_, errSlice := IndexDocument(client, doc)
+	resSlice, errSlice := IndexDocument(client, doc)
 
 	if len(errSlice) > 0 {
 		t.Errorf("Unexpected error indexing documents: %v", errSlice)
 	}
+
+	expected := fmt.Sprintf("[201 Created] created; version=1; id=%s", idHash)
+	if len(resSlice) != 1 || resSlice[0] != expected {
+		t.Errorf("\n%s:\n\n%s\n\n%s:\n\n%v", green("[expected]"), expected, red("[actual]"), resSlice)
+	}
+
+	if source, ok := srv.Document("test", idHash); !ok || string(source) != string(bodyJSON) {
+		t.Errorf("\n%s:\n\n%s\n\n%s:\n\n%s", green("[expected]"), bodyJSON, red("[actual]"), source)
+	}
+
+	reqs := srv.RequestsTo("PUT", "/test/_doc/"+idHash)
+	if len(reqs) != 1 || reqs[0].Query.Get("refresh") != "true" {
+		t.Errorf("document should be indexed once with refresh=true, requests: %+v", reqs)
+	}
+}
+
+func TestIndexDocumentError(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Fail("PUT", "/test/_doc/*", 400, "mapper_parsing_exception", "failed to parse")
+
+	client, err := CreateElasticClient(srv.Config())
+	if err != nil {
+		t.Errorf("Unexpected error creating Elasticsearch client: %s", err)
+	}
+
+	_, errSlice := IndexDocument(client, Document{Index: "test", DocumentID: "1", Body: bytes.NewReader([]byte(`{`))})
+	if len(errSlice) != 1 {
+		t.Errorf("\n%s:\n\n%d\n\n%s:\n\n%v", green("[expected]"), 1, red("[actual]"), errSlice)
+	}
 }
@@ -0,0 +1,90 @@
+package clients
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/wambozi/elastic-search-api/m/pkg/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The operations Elasticsearch requests are counted by
+const (
+	operationSearch = "search"
+	operationIndex  = "index"
+	operationBulk   = "bulk"
+	operationOther  = "other"
+)
+
+var (
+	esDuration = promauto.With(metrics.Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name: "elasticsearch_request_duration_seconds",
+		Help: "Latency of the requests made to Elasticsearch, by operation.",
+	}, []string{"operation"})
+	esErrors = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "elasticsearch_request_errors_total",
+		Help: "Requests made to Elasticsearch that failed, by operation and status code, or error when no response came back.",
+	}, []string{"operation", "code"})
+)
+
+// newTransport returns the transport of the requests made to Elasticsearch: it records their latency and
+// errors, and traces the requests made within a trace, passing it on in their traceparent header
+func newTransport(next http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(&instrumentedTransport{next: next},
+		otelhttp.WithFilter(func(req *http.Request) bool {
+			return trace.SpanContextFromContext(req.Context()).IsValid()
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+			return "elasticsearch " + operation(req)
+		}),
+		otelhttp.WithSpanOptions(trace.WithAttributes(attribute.String("db.system", "elasticsearch"))),
+	)
+}
+
+// instrumentedTransport records the latency and errors of the requests made to Elasticsearch
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip sends the request with the next transport, and records how it went
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := operation(req)
+	trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("db.operation", op))
+
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	esDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+
+	switch {
+	case err != nil:
+		esErrors.WithLabelValues(op, "error").Inc()
+	case res.StatusCode >= 400 && !(req.Method == http.MethodHead && res.StatusCode == http.StatusNotFound):
+		// a HEAD request answered 404 tells what it asked, e.g. that an index doesn't exist
+		esErrors.WithLabelValues(op, strconv.Itoa(res.StatusCode)).Inc()
+	}
+	return res, err
+}
+
+// operation returns the operation of an Elasticsearch request from its path: search, index, bulk or other
+func operation(req *http.Request) string {
+	for _, part := range strings.Split(strings.Trim(req.URL.Path, "/"), "/") {
+		switch part {
+		case "_search", "_msearch", "_count":
+			return operationSearch
+		case "_bulk":
+			return operationBulk
+		case "_doc", "_create", "_update":
+			if req.Method == http.MethodPut || req.Method == http.MethodPost {
+				return operationIndex
+			}
+			return operationOther
+		}
+	}
+	return operationOther
+}
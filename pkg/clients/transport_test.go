@@ -0,0 +1,83 @@
+package clients
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+)
+
+func TestOperation(t *testing.T) {
+	tests := map[string]struct {
+		method    string
+		path      string
+		operation string
+	}{
+		"search":          {method: "POST", path: "/docs/_search", operation: operationSearch},
+		"search template": {method: "POST", path: "/docs/_search/template", operation: operationSearch},
+		"count":           {method: "GET", path: "/docs/_count", operation: operationSearch},
+		"index":           {method: "PUT", path: "/docs/_doc/1", operation: operationIndex},
+		"index new":       {method: "POST", path: "/docs/_doc", operation: operationIndex},
+		"bulk":            {method: "POST", path: "/_bulk", operation: operationBulk},
+		"get":             {method: "GET", path: "/docs/_doc/1", operation: operationOther},
+		"delete":          {method: "DELETE", path: "/docs/_doc/1", operation: operationOther},
+		"health":          {method: "GET", path: "/_cluster/health", operation: operationOther},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if op := operation(httptest.NewRequest(tc.method, tc.path, nil)); op != tc.operation {
+				t.Fatalf("operation - expected : %s, received : %s", tc.operation, op)
+			}
+		})
+	}
+}
+
+func TestInstrumentedTransport(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Index("docs", "1", `{"title":"test"}`)
+	srv.Fail("", "/broken/_search", 500, "search_phase_execution_exception", "all shards failed")
+
+	client, err := CreateElasticClient(GenerateElasticConfig([]string{srv.URL}, username, password))
+	if err != nil {
+		t.Fatalf("Unexpected error creating Elasticsearch client: %s", err)
+	}
+
+	searches := observations(esDuration.WithLabelValues(operationSearch))
+	failed := testutil.ToFloat64(esErrors.WithLabelValues(operationSearch, "500"))
+	notFound := testutil.ToFloat64(esErrors.WithLabelValues(operationOther, "404"))
+
+	for _, index := range []string{"docs", "broken"} {
+		res, err := client.Search(client.Search.WithIndex(index))
+		if err != nil {
+			t.Fatalf("Unexpected error searching %s: %s", index, err)
+		}
+		res.Body.Close()
+	}
+	res, err := client.Indices.Exists([]string{"missing"})
+	if err != nil || res.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing index should not exist, received : %v, %v", res, err)
+	}
+
+	if n := observations(esDuration.WithLabelValues(operationSearch)) - searches; n != 2 {
+		t.Fatalf("searches timed - expected : 2, received : %d", n)
+	}
+	if n := testutil.ToFloat64(esErrors.WithLabelValues(operationSearch, "500")) - failed; n != 1 {
+		t.Fatalf("failed searches - expected : 1, received : %v", n)
+	}
+	if n := testutil.ToFloat64(esErrors.WithLabelValues(operationOther, "404")) - notFound; n != 0 {
+		t.Fatalf("a missing index should not count as an error, received : %v", n)
+	}
+}
+
+// observations returns how many values the histogram observed
+func observations(h prometheus.Observer) uint64 {
+	var m dto.Metric
+	h.(prometheus.Metric).Write(&m)
+	return m.GetHistogram().GetSampleCount()
+}
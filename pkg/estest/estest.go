@@ -0,0 +1,742 @@
+// Package estest provides an in-process stand-in for Elasticsearch, so code using an elasticsearch.Client
+// can be tested without a cluster, Docker or network access.
+//
+// The Server speaks enough of the REST API for this repository: indices exists/create/delete, _doc, _update,
+// _bulk, _search, _count, _template and _cluster/health, keeping documents in memory. Any request can be given a
+// scripted or canned response instead, or fail with an Elasticsearch-shaped error, and every request is
+// captured for assertions.
+package estest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Version is the version of Elasticsearch the Server claims to be
+const Version = "7.5.1"
+
+// Request is a request the Server received
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// route is a scripted response to the requests matching its method and path pattern
+type route struct {
+	method  string
+	pattern string
+	handler http.HandlerFunc
+	// times is how many more requests the route responds to, or -1 for all of them
+	times int
+}
+
+// ScriptFunc stands in for a Painless script of an update, changing the source of a document with the
+// script's params
+type ScriptFunc func(source map[string]interface{}, params map[string]interface{})
+
+type document struct {
+	id      string
+	version int
+	source  json.RawMessage
+}
+
+// Server is a stand-in for an Elasticsearch cluster, listening on a local port
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	indices  map[string]map[string]*document
+	routes   []*route
+	scripts  map[string]ScriptFunc
+	requests []Request
+	health   string
+	lastID   int
+}
+
+// NewServer starts a Server with no indices and a green cluster health. It should be closed when done.
+func NewServer() *Server {
+	s := &Server{indices: map[string]map[string]*document{}, health: "green"}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns an Elasticsearch client of the Server
+func (s *Server) Client() *elasticsearch.Client {
+	es, err := elasticsearch.NewClient(s.Config())
+	if err != nil {
+		// only a malformed address fails, and the Server's address isn't
+		panic(fmt.Sprintf("estest: creating client: %s", err))
+	}
+	return es
+}
+
+// Config returns the configuration of an Elasticsearch client of the Server
+func (s *Server) Config() elasticsearch.Config {
+	return elasticsearch.Config{Addresses: []string{s.URL}}
+}
+
+// Handle scripts the response to requests of the method, or any method when it is "", to the paths
+// matching the pattern (see path.Match, e.g. "/*/_search"). Scripted routes are matched in the order they
+// were added, before the built-in API.
+func (s *Server) Handle(method, pattern string, h http.HandlerFunc) {
+	s.handle(method, pattern, h, -1)
+}
+
+// HandleOnce is Handle for the next matching request only
+func (s *Server) HandleOnce(method, pattern string, h http.HandlerFunc) {
+	s.handle(method, pattern, h, 1)
+}
+
+func (s *Server) handle(method, pattern string, h http.HandlerFunc, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = append(s.routes, &route{method: method, pattern: pattern, handler: h, times: times})
+}
+
+// Respond gives the requests matching the method and pattern a canned JSON response
+func (s *Server) Respond(method, pattern string, status int, body string) {
+	s.Handle(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, status, json.RawMessage(body))
+	})
+}
+
+// Fail makes the requests matching the method and pattern fail with an Elasticsearch error of the type,
+// e.g. "search_phase_execution_exception"
+func (s *Server) Fail(method, pattern string, status int, errType, reason string) {
+	s.Handle(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, status, errType, reason)
+	})
+}
+
+// Script makes the Server run f for the update scripts with the source, since it can't run Painless
+func (s *Server) Script(source string, f ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scripts == nil {
+		s.scripts = map[string]ScriptFunc{}
+	}
+	s.scripts[source] = f
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestsTo returns the requests received so far of the method, or any method when it is "", to the
+// paths matching the pattern
+func (s *Server) RequestsTo(method, pattern string) []Request {
+	var matched []Request
+	for _, r := range s.Requests() {
+		if matches(method, pattern, r.Method, r.Path) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// Reset forgets the indices, scripted routes, scripts and captured requests
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indices = map[string]map[string]*document{}
+	s.routes = nil
+	s.scripts = nil
+	s.requests = nil
+	s.health = "green"
+}
+
+// SetHealth sets the status _cluster/health reports: green, yellow or red
+func (s *Server) SetHealth(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health = status
+}
+
+// CreateIndex creates an empty index, unless it exists
+func (s *Server) CreateIndex(index string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.indices[index] == nil {
+		s.indices[index] = map[string]*document{}
+	}
+}
+
+// Index adds a JSON document to the index, creating the index if needed
+func (s *Server) Index(index, id, source string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(index, id, json.RawMessage(source))
+}
+
+// Document returns the source of a document, and whether it exists
+func (s *Server) Document(index, id string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.indices[index][id]
+	if !ok {
+		return nil, false
+	}
+	return d.source, true
+}
+
+// Count returns the number of documents in the index
+func (s *Server) Count(index string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.indices[index])
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	req := Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header.Clone(), Body: body}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	h := s.scripted(r.Method, r.URL.Path)
+	s.mu.Unlock()
+
+	if h != nil {
+		r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+		h(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.api(w, req)
+}
+
+// scripted returns the handler of the first scripted route matching the request, if any
+func (s *Server) scripted(method, p string) http.HandlerFunc {
+	for i, rt := range s.routes {
+		if rt.times == 0 || !matches(rt.method, rt.pattern, method, p) {
+			continue
+		}
+		if rt.times > 0 {
+			rt.times--
+			if rt.times == 0 {
+				s.routes = append(s.routes[:i:i], s.routes[i+1:]...)
+			}
+		}
+		return rt.handler
+	}
+	return nil
+}
+
+func matches(method, pattern, reqMethod, reqPath string) bool {
+	if method != "" && method != reqMethod {
+		return false
+	}
+	ok, _ := path.Match(pattern, reqPath)
+	return ok
+}
+
+// api serves the built-in REST API
+func (s *Server) api(w http.ResponseWriter, r Request) {
+	parts := strings.Split(strings.Trim(r.Path, "/"), "/")
+	if parts[0] == "" {
+		parts = nil
+	}
+
+	switch {
+	case len(parts) == 0:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"cluster_name": "estest",
+			"version":      map[string]interface{}{"number": Version},
+			"tagline":      "You Know, for Search",
+		})
+	case parts[0] == "_cluster" && len(parts) == 2 && parts[1] == "health":
+		s.clusterHealth(w)
+	case parts[0] == "_template" && len(parts) == 2:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	case parts[0] == "_bulk":
+		s.bulk(w, r, "")
+	case parts[0] == "_search":
+		s.search(w, r, "*")
+	case len(parts) == 1:
+		s.indexAPI(w, r, parts[0])
+	case parts[1] == "_bulk":
+		s.bulk(w, r, parts[0])
+	case parts[1] == "_search":
+		s.search(w, r, parts[0])
+	case parts[1] == "_count":
+		s.count(w, parts[0])
+	case parts[1] == "_doc" && len(parts) <= 3:
+		id := ""
+		if len(parts) == 3 {
+			id = parts[2]
+		}
+		s.doc(w, r, parts[0], id)
+	case parts[1] == "_update" && len(parts) == 3 && r.Method == http.MethodPost:
+		status, res := s.update(parts[0], parts[2], r.Body)
+		if e, ok := res["error"].(map[string]interface{}); ok {
+			writeError(w, status, e["type"].(string), e["reason"].(string))
+			return
+		}
+		writeJSON(w, status, res)
+	default:
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("no handler found for uri [%s] and method [%s]", r.Path, r.Method))
+	}
+}
+
+func (s *Server) clusterHealth(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"cluster_name":      "estest",
+		"status":            s.health,
+		"timed_out":         false,
+		"number_of_nodes":   1,
+		"active_shards":     len(s.indices),
+		"unassigned_shards": 0,
+	})
+}
+
+// indexAPI serves the exists, create and delete index APIs
+func (s *Server) indexAPI(w http.ResponseWriter, r Request, index string) {
+	_, exists := s.indices[index]
+
+	switch r.Method {
+	case http.MethodHead:
+		if exists {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case http.MethodPut:
+		if exists {
+			writeError(w, http.StatusBadRequest, "resource_already_exists_exception", fmt.Sprintf("index [%s] already exists", index))
+			return
+		}
+		s.indices[index] = map[string]*document{}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true, "shards_acknowledged": true, "index": index})
+	case http.MethodDelete:
+		if !exists {
+			writeIndexNotFound(w, index)
+			return
+		}
+		delete(s.indices, index)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "illegal_argument_exception", fmt.Sprintf("method [%s] not allowed on [%s]", r.Method, r.Path))
+	}
+}
+
+// doc serves the index, get and delete document APIs
+func (s *Server) doc(w http.ResponseWriter, r Request, index, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		d, ok := s.indices[index][id]
+		if _, exists := s.indices[index]; !exists {
+			writeIndexNotFound(w, index)
+			return
+		}
+		res := map[string]interface{}{"_index": index, "_type": "_doc", "_id": id, "found": ok}
+		if !ok {
+			writeJSON(w, http.StatusNotFound, res)
+			return
+		}
+		res["_version"] = d.version
+		res["_source"] = d.source
+		writeJSON(w, http.StatusOK, res)
+	case http.MethodPut, http.MethodPost:
+		if !json.Valid(r.Body) {
+			writeError(w, http.StatusBadRequest, "mapper_parsing_exception", "failed to parse")
+			return
+		}
+		status, res := s.put(index, id, json.RawMessage(r.Body))
+		writeJSON(w, status, res)
+	case http.MethodDelete:
+		d, ok := s.indices[index][id]
+		res := map[string]interface{}{"_index": index, "_type": "_doc", "_id": id, "result": "not_found"}
+		if !ok {
+			writeJSON(w, http.StatusNotFound, res)
+			return
+		}
+		delete(s.indices[index], id)
+		res["result"], res["_version"] = "deleted", d.version+1
+		writeJSON(w, http.StatusOK, res)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "illegal_argument_exception", fmt.Sprintf("method [%s] not allowed on [%s]", r.Method, r.Path))
+	}
+}
+
+// put stores a document, generating its ID when it has none, and returns the response to indexing it
+func (s *Server) put(index, id string, source json.RawMessage) (int, map[string]interface{}) {
+	if id == "" {
+		s.lastID++
+		id = fmt.Sprintf("estest-%d", s.lastID)
+	}
+	if s.indices[index] == nil {
+		s.indices[index] = map[string]*document{}
+	}
+
+	status, result, version := http.StatusCreated, "created", 1
+	if d, ok := s.indices[index][id]; ok {
+		status, result, version = http.StatusOK, "updated", d.version+1
+	}
+	s.indices[index][id] = &document{id: id, version: version, source: source}
+
+	return status, map[string]interface{}{
+		"_index":   index,
+		"_type":    "_doc",
+		"_id":      id,
+		"_version": version,
+		"result":   result,
+		"_shards":  map[string]int{"total": 1, "successful": 1, "failed": 0},
+	}
+}
+
+// updateBody represents the parts of an update the Server understands
+type updateBody struct {
+	Doc            map[string]interface{} `json:"doc"`
+	DocAsUpsert    bool                   `json:"doc_as_upsert"`
+	Upsert         map[string]interface{} `json:"upsert"`
+	ScriptedUpsert bool                   `json:"scripted_upsert"`
+	Script         *struct {
+		Source string                 `json:"source"`
+		Params map[string]interface{} `json:"params"`
+	} `json:"script"`
+}
+
+// update merges the doc of an update into a document, or runs its script on it, and upserts the document
+// when it's missing. It returns the response to the update, an error body when it fails.
+func (s *Server) update(index, id string, body []byte) (int, map[string]interface{}) {
+	var u updateBody
+	if err := json.Unmarshal(body, &u); err != nil {
+		return http.StatusBadRequest, errorBody("x_content_parse_exception", err.Error())
+	}
+	var script ScriptFunc
+	if u.Script != nil {
+		if script = s.scripts[u.Script.Source]; script == nil {
+			return http.StatusBadRequest, errorBody("illegal_argument_exception", "estest only runs the scripts given to Script")
+		}
+	} else if u.Doc == nil {
+		return http.StatusBadRequest, errorBody("action_request_validation_exception", "Validation Failed: 1: script or doc is missing;")
+	}
+
+	var source map[string]interface{}
+	d, exists := s.indices[index][id]
+	switch {
+	case exists:
+		if err := json.Unmarshal(d.source, &source); err != nil || source == nil {
+			source = map[string]interface{}{}
+		}
+		if script != nil {
+			script(source, u.Script.Params)
+		} else {
+			for k, v := range u.Doc {
+				source[k] = v
+			}
+		}
+	case u.Upsert != nil:
+		source = u.Upsert
+		if script != nil && u.ScriptedUpsert {
+			script(source, u.Script.Params)
+		}
+	case u.DocAsUpsert:
+		source = u.Doc
+	default:
+		return http.StatusNotFound, errorBody("document_missing_exception", fmt.Sprintf("[_doc][%s]: document missing", id))
+	}
+
+	b, err := json.Marshal(source)
+	if err != nil {
+		return http.StatusBadRequest, errorBody("illegal_argument_exception", err.Error())
+	}
+	return s.put(index, id, b)
+}
+
+// bulk serves the _bulk API's index, create, update and delete actions
+func (s *Server) bulk(w http.ResponseWriter, r Request, defaultIndex string) {
+	var items []interface{}
+	failed := false
+
+	lines := strings.Split(strings.TrimSpace(string(r.Body)), "\n")
+	for i := 0; i < len(lines); i++ {
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &action); err != nil || len(action) != 1 {
+			writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("Malformed action/metadata line [%d]", i+1))
+			return
+		}
+
+		for op, meta := range action {
+			index := meta.Index
+			if index == "" {
+				index = defaultIndex
+			}
+
+			var item map[string]interface{}
+			status := http.StatusOK
+			switch op {
+			case "index", "create":
+				i++
+				if i >= len(lines) || !json.Valid([]byte(lines[i])) {
+					status, item = http.StatusBadRequest, errorBody("mapper_parsing_exception", "failed to parse")
+					break
+				}
+				if _, exists := s.indices[index][meta.ID]; op == "create" && exists && meta.ID != "" {
+					status, item = http.StatusConflict, errorBody("version_conflict_engine_exception", fmt.Sprintf("[%s]: version conflict, document already exists", meta.ID))
+					break
+				}
+				status, item = s.put(index, meta.ID, json.RawMessage(lines[i]))
+			case "update":
+				i++
+				if i >= len(lines) {
+					status, item = http.StatusBadRequest, errorBody("x_content_parse_exception", "update without a body")
+					break
+				}
+				status, item = s.update(index, meta.ID, []byte(lines[i]))
+			case "delete":
+				if _, exists := s.indices[index][meta.ID]; !exists {
+					status, item = http.StatusNotFound, map[string]interface{}{"result": "not_found"}
+					break
+				}
+				delete(s.indices[index], meta.ID)
+				item = map[string]interface{}{"result": "deleted"}
+			default:
+				writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("Unsupported action: [%s]", op))
+				return
+			}
+
+			if status > 299 {
+				failed = true
+			}
+			item["_index"], item["status"] = index, status
+			if item["_id"] == nil {
+				item["_id"] = meta.ID
+			}
+			items = append(items, map[string]interface{}{op: item})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"took": 1, "errors": failed, "items": items})
+}
+
+// searchBody represents the parts of a search body the Server understands
+type searchBody struct {
+	From  int              `json:"from"`
+	Size  *int             `json:"size"`
+	Query *json.RawMessage `json:"query"`
+}
+
+// search serves the _search API. Documents are matched on every word of the first "query" string in the
+// query, e.g. of a multi_match, against all of their string fields, or all match when there's none. Hits
+// score 1 and are ordered by index and ID. Aggregations and suggesters aren't supported.
+func (s *Server) search(w http.ResponseWriter, r Request, target string) {
+	var b searchBody
+	if len(r.Body) > 0 {
+		if err := json.Unmarshal(r.Body, &b); err != nil {
+			writeError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+			return
+		}
+	}
+	if from := r.Query.Get("from"); from != "" {
+		fmt.Sscan(from, &b.From)
+	}
+	size := 10
+	if b.Size != nil {
+		size = *b.Size
+	}
+	if sz := r.Query.Get("size"); sz != "" {
+		fmt.Sscan(sz, &size)
+	}
+
+	indices, ok := s.resolve(target, r.Query.Get("ignore_unavailable") == "true")
+	if !ok {
+		writeIndexNotFound(w, target)
+		return
+	}
+
+	var term string
+	if b.Query != nil {
+		var q interface{}
+		json.Unmarshal(*b.Query, &q)
+		term = queryString(q)
+	}
+
+	hits := []interface{}{}
+	total := 0
+	for _, index := range indices {
+		for _, d := range s.sorted(index) {
+			if !match(d.source, term) {
+				continue
+			}
+			total++
+			if total > b.From && len(hits) < size {
+				hits = append(hits, map[string]interface{}{
+					"_index":  index,
+					"_type":   "_doc",
+					"_id":     d.id,
+					"_score":  1,
+					"_source": d.source,
+					"sort":    []interface{}{1, d.id},
+				})
+			}
+		}
+	}
+
+	var maxScore interface{}
+	if total > 0 {
+		maxScore = 1
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"took":      1,
+		"timed_out": false,
+		"_shards":   map[string]int{"total": len(indices), "successful": len(indices), "skipped": 0, "failed": 0},
+		"hits": map[string]interface{}{
+			"total":     map[string]interface{}{"value": total, "relation": "eq"},
+			"max_score": maxScore,
+			"hits":      hits,
+		},
+	})
+}
+
+func (s *Server) count(w http.ResponseWriter, target string) {
+	indices, ok := s.resolve(target, false)
+	if !ok {
+		writeIndexNotFound(w, target)
+		return
+	}
+	n := 0
+	for _, index := range indices {
+		n += len(s.indices[index])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": n})
+}
+
+// resolve returns the indices named by a comma-separated list of names and wildcard patterns. Like
+// Elasticsearch, a name that doesn't exist is an error, unless unavailable indices are ignored, while a
+// pattern matching nothing isn't.
+func (s *Server) resolve(target string, ignoreUnavailable bool) ([]string, bool) {
+	var indices []string
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(target, ",") {
+		if name == "_all" {
+			name = "*"
+		}
+		if !strings.Contains(name, "*") {
+			if _, ok := s.indices[name]; !ok && !ignoreUnavailable {
+				return nil, false
+			}
+		}
+		for index := range s.indices {
+			if ok, _ := path.Match(name, index); ok && !seen[index] {
+				seen[index] = true
+				indices = append(indices, index)
+			}
+		}
+	}
+
+	sort.Strings(indices)
+	return indices, true
+}
+
+func (s *Server) sorted(index string) []*document {
+	var docs []*document
+	for _, d := range s.indices[index] {
+		docs = append(docs, d)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].id < docs[j].id })
+	return docs
+}
+
+// queryString returns the first "query" string in a query, depth first
+func queryString(q interface{}) string {
+	switch q := q.(type) {
+	case map[string]interface{}:
+		if s, ok := q["query"].(string); ok {
+			return s
+		}
+		keys := make([]string, 0, len(q))
+		for k := range q {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if s := queryString(q[k]); s != "" {
+				return s
+			}
+		}
+	case []interface{}:
+		for _, e := range q {
+			if s := queryString(e); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// match reports whether the strings of the document contain every word of the term
+func match(source json.RawMessage, term string) bool {
+	var doc interface{}
+	if err := json.Unmarshal(source, &doc); err != nil {
+		return false
+	}
+	text := strings.ToLower(strings.Join(texts(doc, nil), " "))
+	for _, w := range strings.Fields(strings.ToLower(term)) {
+		if !strings.Contains(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// texts appends the strings in a value of a document to text
+func texts(v interface{}, text []string) []string {
+	switch v := v.(type) {
+	case string:
+		text = append(text, v)
+	case []interface{}:
+		for _, e := range v {
+			text = texts(e, text)
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			text = texts(e, text)
+		}
+	}
+	return text
+}
+
+func errorBody(errType, reason string) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]interface{}{"type": errType, "reason": reason}}
+}
+
+func writeError(w http.ResponseWriter, status int, errType, reason string) {
+	e := map[string]interface{}{
+		"type":       errType,
+		"reason":     reason,
+		"root_cause": []interface{}{map[string]interface{}{"type": errType, "reason": reason}},
+	}
+	writeJSON(w, status, map[string]interface{}{"error": e, "status": status})
+}
+
+func writeIndexNotFound(w http.ResponseWriter, index string) {
+	writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", index))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
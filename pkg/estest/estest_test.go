@@ -0,0 +1,211 @@
+package estest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIndices(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	es := srv.Client()
+
+	res, err := es.Indices.Exists([]string{"droids"})
+	if err != nil || res.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing index - expected : 404, received : %v %v", res, err)
+	}
+
+	res, err = es.Indices.Create("droids")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("create - expected : 200, received : %v %v", res, err)
+	}
+	res, err = es.Indices.Create("droids")
+	if err != nil || res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("create existing - expected : 400, received : %v %v", res, err)
+	}
+
+	res, err = es.Indices.Exists([]string{"droids"})
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("existing index - expected : 200, received : %v %v", res, err)
+	}
+}
+
+func TestDocAndSearch(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	es := srv.Client()
+
+	req := esapi.IndexRequest{Index: "droids", DocumentID: "1", Body: strings.NewReader(`{"title":"R2-D2","species":"Robot"}`)}
+	res, err := req.Do(context.Background(), es)
+	if err != nil || res.StatusCode != http.StatusCreated {
+		t.Fatalf("index - expected : 201, received : %v %v", res, err)
+	}
+	srv.Index("droids", "2", `{"title":"Chewbacca","species":"Wookiee"}`)
+
+	var r struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	res, err = es.Search(es.Search.WithIndex("dro*"), es.Search.WithBody(strings.NewReader(`{"query":{"multi_match":{"query":"robot"}}}`)))
+	if err != nil || res.IsError() {
+		t.Fatalf("Unexpected error searching: %v %v", res, err)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatalf("Unexpected error decoding results: %s", err)
+	}
+	if r.Hits.Total.Value != 1 || r.Hits.Hits[0].ID != "1" {
+		t.Fatalf("unexpected hits : %+v", r.Hits)
+	}
+
+	res, err = es.Delete("droids", "2")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("delete - expected : 200, received : %v %v", res, err)
+	}
+	if _, ok := srv.Document("droids", "2"); ok {
+		t.Fatalf("deleted document should be gone")
+	}
+	res, err = es.Delete("droids", "2")
+	if err != nil || res.StatusCode != http.StatusNotFound {
+		t.Fatalf("delete missing - expected : 404, received : %v %v", res, err)
+	}
+
+	res, err = es.Search(es.Search.WithIndex("jedi"))
+	if err != nil || res.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing index - expected : 404, received : %v %v", res, err)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	body := `{"index":{"_index":"droids","_id":"1"}}
+{"title":"R2-D2"}
+{"create":{"_index":"droids","_id":"1"}}
+{"title":"R2-D2"}
+{"index":{"_index":"droids"}}
+{"title":"BB-8"}
+`
+	res, err := srv.Client().Bulk(strings.NewReader(body))
+	if err != nil || res.IsError() {
+		t.Fatalf("Unexpected error indexing: %v %v", res, err)
+	}
+
+	var r struct {
+		Errors bool                              `json:"errors"`
+		Items  []map[string]struct{ Status int } `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatalf("Unexpected error decoding response: %s", err)
+	}
+	statuses := []int{r.Items[0]["index"].Status, r.Items[1]["create"].Status, r.Items[2]["index"].Status}
+	if diff := cmp.Diff([]int{201, 409, 201}, statuses); diff != "" || !r.Errors {
+		t.Fatalf("unexpected bulk response : %+v", r)
+	}
+	if srv.Count("droids") != 2 {
+		t.Fatalf("count - expected : 2, received : %d", srv.Count("droids"))
+	}
+}
+
+func TestScripting(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	es := srv.Client()
+
+	srv.CreateIndex("droids")
+	srv.HandleOnce("", "/*/_search", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	srv.Fail("", "/droids/_count", http.StatusServiceUnavailable, "cluster_block_exception", "blocked")
+	srv.Respond("GET", "/_cluster/health", http.StatusOK, `{"status":"yellow"}`)
+
+	res, _ := es.Search(es.Search.WithIndex("droids"), es.Search.WithBody(strings.NewReader(`{}`)))
+	if res.StatusCode != http.StatusTeapot {
+		t.Fatalf("scripted - expected : 418, received : %d", res.StatusCode)
+	}
+	res, _ = es.Search(es.Search.WithIndex("droids"), es.Search.WithBody(strings.NewReader(`{}`)))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("once - expected : 200, received : %d", res.StatusCode)
+	}
+	res, _ = es.Count(es.Count.WithIndex("droids"))
+	if res.StatusCode != http.StatusServiceUnavailable || !strings.Contains(res.String(), "cluster_block_exception") {
+		t.Fatalf("failure - expected : 503 cluster_block_exception, received : %s", res)
+	}
+	res, _ = es.Cluster.Health()
+	if !strings.Contains(res.String(), "yellow") {
+		t.Fatalf("canned - expected : yellow, received : %s", res)
+	}
+
+	if n := len(srv.RequestsTo("", "/droids/_search")); n != 2 {
+		t.Fatalf("captured searches - expected : 2, received : %d", n)
+	}
+	if body := string(srv.RequestsTo("GET", "/droids/_search")[0].Body); body != "{}" {
+		t.Fatalf("captured body - expected : {}, received : %s", body)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	es := srv.Client()
+
+	srv.Script("ctx._source.clicks += params.n", func(source, params map[string]interface{}) {
+		clicks, _ := source["clicks"].(float64)
+		source["clicks"] = clicks + params["n"].(float64)
+	})
+	script := `{"script":{"source":"ctx._source.clicks += params.n","params":{"n":2}},"scripted_upsert":true,"upsert":{"title":"R2-D2"}}`
+
+	tests := []struct {
+		body   string
+		status int
+		source string
+	}{
+		{body: `{"doc":{"title":"R2-D2"}}`, status: http.StatusNotFound},
+		{body: script, status: http.StatusCreated, source: `{"clicks":2,"title":"R2-D2"}`},
+		{body: script, status: http.StatusOK, source: `{"clicks":4,"title":"R2-D2"}`},
+		{body: `{"doc":{"title":"Artoo"},"doc_as_upsert":true}`, status: http.StatusOK, source: `{"clicks":4,"title":"Artoo"}`},
+		{body: `{"script":{"source":"ctx.op = 'delete'"}}`, status: http.StatusBadRequest, source: `{"clicks":4,"title":"Artoo"}`},
+	}
+	for i, tc := range tests {
+		res, err := es.Update("droids", "1", strings.NewReader(tc.body))
+		if err != nil || res.StatusCode != tc.status {
+			t.Fatalf("step %d: status - expected : %d, received : %v %v", i, tc.status, res, err)
+		}
+		source, _ := srv.Document("droids", "1")
+		if diff := cmp.Diff(tc.source, string(source)); diff != "" {
+			t.Fatalf("step %d: %s", i, diff)
+		}
+	}
+
+	body := `{"update":{"_index":"droids","_id":"2","retry_on_conflict":3}}
+{"doc":{"title":"BB-8"},"doc_as_upsert":true}
+{"update":{"_index":"droids","_id":"3"}}
+{"doc":{"title":"C-3PO"}}
+`
+	res, err := es.Bulk(strings.NewReader(body))
+	if err != nil || res.IsError() {
+		t.Fatalf("Unexpected error updating: %v %v", res, err)
+	}
+	var r struct {
+		Items []map[string]struct{ Status int } `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		t.Fatalf("Unexpected error decoding response: %s", err)
+	}
+	statuses := []int{r.Items[0]["update"].Status, r.Items[1]["update"].Status}
+	if diff := cmp.Diff([]int{201, 404}, statuses); diff != "" {
+		t.Fatalf(diff)
+	}
+}
@@ -11,7 +11,10 @@ import (
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/pkg/metrics"
 )
 
 // IndexNameFunc returns the index name
@@ -44,6 +47,12 @@ var (
 	ErrCannotCreateIndex = fmt.Errorf("cannot create index")
 )
 
+// hookFailures counts the entries that couldn't be indexed, which asynchronous hooks can't return
+var hookFailures = promauto.With(metrics.Registry).NewCounter(prometheus.CounterOpts{
+	Name: "logging_hook_failures_total",
+	Help: "Log entries the Elasticsearch hook failed to index.",
+})
+
 
 // NewAsyncElasticHook creates new hook with asynchronous log.
 // client - ElasticSearch client with specific es version (v5/v6/v7/...)
@@ -120,7 +129,11 @@ func (hook *ElasticHook) Fire(entry *logrus.Entry) error {
 }
 
 func asyncFireFunc(entry *logrus.Entry, hook *ElasticHook) error {
-	go syncFireFunc(entry, hook)
+	go func() {
+		if err := syncFireFunc(entry, hook); err != nil {
+			hookFailures.Inc()
+		}
+	}()
 	return nil
 }
 
@@ -6,14 +6,15 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"os"
 	"testing"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/gookit/color"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 	"github.com/wambozi/elastic-search-api/m/pkg/clients"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
 )
 
 var (
@@ -44,7 +45,9 @@ func TestAsyncHook(t *testing.T) {
 }
 
 func hookTest(hookfunc NewHookFunc, indexName string, t *testing.T) {
-	endpoint := "http://" + os.Getenv("ELASTICSEARCH_IP") + ":9200"
+	srv := estest.NewServer()
+	defer srv.Close()
+	endpoint := srv.URL
 	elasticConfig := elasticsearch.Config{
 		Addresses: []string{endpoint},
 		Transport: &http.Transport{
@@ -58,38 +61,35 @@ func hookTest(hookfunc NewHookFunc, indexName string, t *testing.T) {
 		},
 	}
 
-	// Test that elastic is reachable before coninuting with the test
-	if _, err := http.Get(endpoint); err != nil {
-		t.Fatal("Elastic not reachable")
-	}
-
 	// Create the elasticsearch client using the config defined above
 	elasticClient, err := clients.CreateElasticClient(elasticConfig)
 	if err != nil {
 		t.Errorf("Error: %+v", err)
 	}
 
-	// Delete the index and ignore the response
-	_, err = elasticClient.Indices.Delete([]string{indexName})
-	if err != nil {
-		t.Errorf("Error: %+v", err)
-	}
-
-	// Create logrus hook using the elastic client
+	// Create logrus hook using the elastic client, which creates the index
 	hook, err := hookfunc(elasticClient, "localhost", logrus.DebugLevel, indexName)
 	if err != nil {
 		log.Panic(err)
 	}
-	logrus.AddHook(hook)
+	if len(srv.RequestsTo("PUT", "/"+indexName)) != 1 {
+		t.Fatalf("hook should create the %s index", indexName)
+	}
+
+	logger := logrus.New()
+	logger.AddHook(hook)
 
 	// Create 100 sample log messages and log them
 	samples := 100
 	for index := 0; index < samples; index++ {
-		logrus.Infof("Testing msg %d", time.Now().Unix())
+		logger.Infof("Testing msg %d", time.Now().Unix())
 	}
 
 	// Allow time for data to be processed.
-	time.Sleep(10 * time.Second)
+	deadline := time.Now().Add(10 * time.Second)
+	for srv.Count(indexName) < samples && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
 
 	// Declare an object to Unmarshal the response object into
 	res := new(DocumentCount)
@@ -110,3 +110,71 @@ func hookTest(hookfunc NewHookFunc, indexName string, t *testing.T) {
 
 	hook.Cancel()
 }
+
+func TestHookCannotCreateIndex(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Fail("PUT", "/async-log", 403, "security_exception", "action [indices:admin/create] is unauthorized")
+
+	elasticClient, err := clients.CreateElasticClient(srv.Config())
+	if err != nil {
+		t.Errorf("Error: %+v", err)
+	}
+
+	if _, err := NewAsyncElasticHook(elasticClient, "localhost", logrus.DebugLevel, "async-log"); err != ErrCannotCreateIndex {
+		t.Errorf("\n%s:\n\n%v\n\n%s:\n\n%v", green("[expected]"), ErrCannotCreateIndex, red("[actual]"), err)
+	}
+}
+
+func TestHookExistingIndex(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.CreateIndex("async-log")
+
+	elasticClient, err := clients.CreateElasticClient(srv.Config())
+	if err != nil {
+		t.Errorf("Error: %+v", err)
+	}
+
+	hook, err := NewAsyncElasticHook(elasticClient, "localhost", logrus.DebugLevel, "async-log")
+	if err != nil {
+		t.Fatalf("Unexpected error creating hook: %s", err)
+	}
+	defer hook.Cancel()
+
+	if len(srv.RequestsTo("PUT", "/async-log")) != 0 {
+		t.Errorf("hook should not create an index that exists")
+	}
+}
+
+func TestHookFailures(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.CreateIndex("async-log")
+	srv.Fail("", "/async-log/_doc", 400, "mapper_parsing_exception", "failed to parse")
+
+	elasticClient, err := clients.CreateElasticClient(srv.Config())
+	if err != nil {
+		t.Errorf("Error: %+v", err)
+	}
+	hook, err := NewAsyncElasticHook(elasticClient, "localhost", logrus.DebugLevel, "async-log")
+	if err != nil {
+		t.Fatalf("Unexpected error creating hook: %s", err)
+	}
+	defer hook.Cancel()
+
+	logger := logrus.New()
+	logger.AddHook(hook)
+	failures := testutil.ToFloat64(hookFailures)
+	for i := 0; i < 3; i++ {
+		logger.Infof("Testing msg %d", i)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for testutil.ToFloat64(hookFailures)-failures < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := testutil.ToFloat64(hookFailures) - failures; n != 3 {
+		t.Errorf("\n%s:\n\n%d\n\n%s:\n\n%v", green("[expected]"), 3, red("[actual]"), n)
+	}
+}
@@ -0,0 +1,35 @@
+// Package metrics holds the Prometheus registry the API's metrics are registered on, along with the Go
+// runtime and process collectors, for GET /metrics to expose. Metrics are registered once, usually as
+// package variables:
+//
+//	var searches = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+//		Name: "searches_total",
+//		Help: "Searches run, by index.",
+//	}, []string{"index"})
+//
+//	searches.WithLabelValues("docs").Inc()
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry is the registry the API's metrics are registered on
+var Registry = newRegistry()
+
+// newRegistry returns a registry with the collectors of the Go runtime and of the process
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return r
+}
+
+// Handler returns the handler serving the metrics of the Registry, logging the errors gathering them with
+// logger
+func Handler(logger promhttp.Logger) http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{ErrorLog: logger})
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandler(t *testing.T) {
+	requests := promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "test_requests_total",
+		Help: "Requests served.",
+	}, []string{"route", "status"})
+	defer Registry.Unregister(requests)
+	requests.WithLabelValues("/search", "200").Add(3)
+
+	w := httptest.NewRecorder()
+	Handler(logrus.New()).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("metrics should be served as text, received : %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{route="/search",status="200"} 3` + "\n",
+		"# TYPE go_goroutines gauge\n",
+		"# TYPE process_cpu_seconds_total counter\n",
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Fatalf("metrics should contain %q, received : %s", line, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package searching
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+const (
+	// queriesSuffix is appended to an index name to get the index its search terms are logged to
+	queriesSuffix = "-queries"
+	// queryDateFormat is the ISO 8601 format of the dates queries are logged with
+	queryDateFormat = "2006-01-02T15:04:05.000Z07:00"
+	// queriesTemplate is the name of the index template mapping the queries indices
+	queriesTemplate = "queries"
+	// DefaultAnalyticsSize is the number of queries returned when a request does not specify a size
+	DefaultAnalyticsSize = 10
+	// MaxAnalyticsSize is the largest number of queries a single request may ask for
+	MaxAnalyticsSize = 100
+	// trendingCandidates is how many more terms than requested are compared between windows for trending queries
+	trendingCandidates = 10
+)
+
+// queriesMapping maps the logged queries so they can be aggregated. searchTerm keeps the text and keyword
+// pair dynamic mapping gives it, so indices created before the template was installed aggregate the same.
+var queriesMapping = map[string]interface{}{
+	"index_patterns": []string{"*" + queriesSuffix},
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"searchTerm": map[string]interface{}{
+				"type":   "text",
+				"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256}},
+			},
+			"searchId":      map[string]interface{}{"type": "keyword"},
+			"index":         map[string]interface{}{"type": "keyword"},
+			"user-agent":    map[string]interface{}{"type": "keyword", "ignore_above": 1024},
+			"date":          map[string]interface{}{"type": "date", "format": "yyyy-MM-dd HH:mm:ss||strict_date_optional_time||epoch_millis"},
+			"hits":          map[string]interface{}{"type": "long"},
+			"took":          map[string]interface{}{"type": "long"},
+			"latencyMillis": map[string]interface{}{"type": "long"},
+			"page":          map[string]interface{}{"type": "integer"},
+			"filters":       map[string]interface{}{"type": "keyword", "ignore_above": 1024},
+			"clicks":        map[string]interface{}{"type": "integer"},
+			"clickedIds":    map[string]interface{}{"type": "keyword"},
+		},
+	},
+}
+
+// AnalyticsRequest represents a request for the logged queries of an index over a time window
+type AnalyticsRequest struct {
+	Index string
+	// Window is the length of the time window, which ends at To
+	Window time.Duration
+	To     time.Time
+	Size   int
+}
+
+// QueryCount represents how many times a search term was searched for
+type QueryCount struct {
+	Query string `json:"query"`
+	Count int    `json:"count"`
+}
+
+// TopQueries represents the response of the GET /analytics/top-queries route. ZeroClicks are the most
+// searched terms that found hits none of which were clicked, and ClickThroughRate is the share of
+// searches with at least one click.
+type TopQueries struct {
+	From             time.Time    `json:"from"`
+	To               time.Time    `json:"to"`
+	Total            int          `json:"total"`
+	ClickThroughRate float64      `json:"clickThroughRate"`
+	Queries          []QueryCount `json:"queries"`
+	ZeroResults      []QueryCount `json:"zeroResults"`
+	ZeroClicks       []QueryCount `json:"zeroClicks"`
+}
+
+// TrendingQuery represents how a search term's count changed from the previous window to the current one
+type TrendingQuery struct {
+	Query         string  `json:"query"`
+	Count         int     `json:"count"`
+	PreviousCount int     `json:"previousCount"`
+	Growth        float64 `json:"growth"`
+}
+
+// TrendingQueries represents the response of the GET /analytics/trending route
+type TrendingQueries struct {
+	From         time.Time       `json:"from"`
+	To           time.Time       `json:"to"`
+	PreviousFrom time.Time       `json:"previousFrom"`
+	Queries      []TrendingQuery `json:"queries"`
+}
+
+// termBuckets represents a terms aggregation on the search terms
+type termBuckets struct {
+	Buckets []struct {
+		Key      string `json:"key"`
+		DocCount int    `json:"doc_count"`
+		Current  struct {
+			DocCount int `json:"doc_count"`
+		} `json:"current"`
+		Previous struct {
+			DocCount int `json:"doc_count"`
+		} `json:"previous"`
+	} `json:"buckets"`
+}
+
+func queriesIndex(i string) string {
+	return i + queriesSuffix
+}
+
+// Validate checks that the request can be turned into a query Elasticsearch will accept. Its errors are
+// of kind ErrBadRequest.
+func (a AnalyticsRequest) Validate() error {
+	if a.Index == "" {
+		return invalid(fmt.Errorf("analytics need an index"))
+	}
+	if a.Window <= 0 {
+		return invalid(fmt.Errorf("window must be a positive duration, got %s", a.Window))
+	}
+	if a.Size < 0 || a.Size > MaxAnalyticsSize {
+		return invalid(fmt.Errorf("size must be between 1 and %d, got %d", MaxAnalyticsSize, a.Size))
+	}
+	return nil
+}
+
+func (a AnalyticsRequest) size() int {
+	if a.Size == 0 {
+		return DefaultAnalyticsSize
+	}
+	return a.Size
+}
+
+func (a AnalyticsRequest) to() time.Time {
+	if a.To.IsZero() {
+		return time.Now().UTC()
+	}
+	return a.To.UTC()
+}
+
+func dateRange(from time.Time, to time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"range": map[string]interface{}{
+			"date": map[string]interface{}{
+				"gte":    from.Format(time.RFC3339),
+				"lt":     to.Format(time.RFC3339),
+				"format": "strict_date_optional_time",
+			},
+		},
+	}
+}
+
+// PutQueriesTemplate installs the index template mapping the indices search terms are logged to. It only
+// applies to queries indices created after it is installed.
+func PutQueriesTemplate(es *elasticsearch.Client) error {
+	body, err := json.Marshal(queriesMapping)
+	if err != nil {
+		return err
+	}
+
+	res, err := es.Indices.PutTemplate(queriesTemplate, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error installing the queries index template: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("[%s] Error installing the queries index template: %s", res.Status(), res.String())
+	}
+	return nil
+}
+
+func buildTopQueriesQuery(a AnalyticsRequest) map[string]interface{} {
+	to := a.to()
+	terms := map[string]interface{}{"terms": map[string]interface{}{"field": "searchTerm.keyword", "size": a.size()}}
+
+	return map[string]interface{}{
+		"size":             0,
+		"track_total_hits": true,
+		"query":            dateRange(to.Add(-a.Window), to),
+		"aggs": map[string]interface{}{
+			"top": terms,
+			"zero": map[string]interface{}{
+				"filter": map[string]interface{}{"term": map[string]interface{}{"hits": 0}},
+				"aggs":   map[string]interface{}{"terms": terms},
+			},
+			"clicked": map[string]interface{}{
+				"filter": map[string]interface{}{"range": map[string]interface{}{"clicks": map[string]interface{}{"gt": 0}}},
+			},
+			"zeroClick": map[string]interface{}{
+				"filter": map[string]interface{}{
+					"bool": map[string]interface{}{
+						"filter": []interface{}{
+							map[string]interface{}{"range": map[string]interface{}{"hits": map[string]interface{}{"gt": 0}}},
+						},
+						// queries never clicked have no clicks
+						"must_not": []interface{}{
+							map[string]interface{}{"range": map[string]interface{}{"clicks": map[string]interface{}{"gt": 0}}},
+						},
+					},
+				},
+				"aggs": map[string]interface{}{"terms": terms},
+			},
+		},
+	}
+}
+
+func buildTrendingQuery(a AnalyticsRequest) map[string]interface{} {
+	to := a.to()
+	from := to.Add(-a.Window)
+	previousFrom := from.Add(-a.Window)
+
+	return map[string]interface{}{
+		"size":  0,
+		"query": dateRange(previousFrom, to),
+		"aggs": map[string]interface{}{
+			"terms": map[string]interface{}{
+				"terms": map[string]interface{}{
+					"field": "searchTerm.keyword",
+					"size":  a.size() * trendingCandidates,
+					"order": map[string]interface{}{"current>_count": "desc"},
+				},
+				"aggs": map[string]interface{}{
+					"current":  map[string]interface{}{"filter": dateRange(from, to)},
+					"previous": map[string]interface{}{"filter": dateRange(previousFrom, from)},
+				},
+			},
+		},
+	}
+}
+
+func aggregateQueries(ctx context.Context, es *elasticsearch.Client, index string, query map[string]interface{}, r interface{}) error {
+	var buf bytes.Buffer
+
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		return err
+	}
+
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(queriesIndex(index)),
+		es.Search.WithBody(&buf),
+		es.Search.WithTimeout(shardTimeout(ctx)),
+		// an index nothing was logged under yet has no queries index, and no queries
+		es.Search.WithIgnoreUnavailable(true),
+	)
+	if err != nil {
+		return requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res, fmt.Sprintf("Error aggregating the queries of %s", index))
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(r); err != nil {
+		return parseError(err)
+	}
+	return nil
+}
+
+func queryCounts(t termBuckets) []QueryCount {
+	counts := []QueryCount{}
+	for _, b := range t.Buckets {
+		counts = append(counts, QueryCount{Query: b.Key, Count: b.DocCount})
+	}
+	return counts
+}
+
+// GetTopQueries returns the most searched terms of an index over the window, and the most searched terms
+// that found nothing
+func GetTopQueries(ctx context.Context, es *elasticsearch.Client, a AnalyticsRequest) (*TopQueries, error) {
+	var r struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+		} `json:"hits"`
+		Aggregations struct {
+			Top  termBuckets `json:"top"`
+			Zero struct {
+				Terms termBuckets `json:"terms"`
+			} `json:"zero"`
+			Clicked struct {
+				DocCount int `json:"doc_count"`
+			} `json:"clicked"`
+			ZeroClick struct {
+				Terms termBuckets `json:"terms"`
+			} `json:"zeroClick"`
+		} `json:"aggregations"`
+	}
+
+	if err := aggregateQueries(ctx, es, a.Index, buildTopQueriesQuery(a), &r); err != nil {
+		return nil, err
+	}
+
+	to := a.to()
+	top := &TopQueries{
+		From:        to.Add(-a.Window),
+		To:          to,
+		Total:       r.Hits.Total.Value,
+		Queries:     queryCounts(r.Aggregations.Top),
+		ZeroResults: queryCounts(r.Aggregations.Zero.Terms),
+		ZeroClicks:  queryCounts(r.Aggregations.ZeroClick.Terms),
+	}
+	if top.Total > 0 {
+		top.ClickThroughRate = float64(r.Aggregations.Clicked.DocCount) / float64(top.Total)
+	}
+	return top, nil
+}
+
+// GetTrendingQueries returns the search terms of an index that were searched more in the window than in
+// the window before it, the fastest rising first
+func GetTrendingQueries(ctx context.Context, es *elasticsearch.Client, a AnalyticsRequest) (*TrendingQueries, error) {
+	var r struct {
+		Aggregations struct {
+			Terms termBuckets `json:"terms"`
+		} `json:"aggregations"`
+	}
+
+	if err := aggregateQueries(ctx, es, a.Index, buildTrendingQuery(a), &r); err != nil {
+		return nil, err
+	}
+
+	to := a.to()
+	return &TrendingQueries{
+		From:         to.Add(-a.Window),
+		To:           to,
+		PreviousFrom: to.Add(-2 * a.Window),
+		Queries:      trending(r.Aggregations.Terms, a.size()),
+	}, nil
+}
+
+// trending ranks the terms by how much their count grew, smoothed so terms that weren't searched at all
+// in the previous window don't all rank equally
+func trending(t termBuckets, size int) []TrendingQuery {
+	queries := []TrendingQuery{}
+	for _, b := range t.Buckets {
+		if b.Current.DocCount <= b.Previous.DocCount {
+			continue
+		}
+		queries = append(queries, TrendingQuery{
+			Query:         b.Key,
+			Count:         b.Current.DocCount,
+			PreviousCount: b.Previous.DocCount,
+			Growth:        float64(b.Current.DocCount+1) / float64(b.Previous.DocCount+1),
+		})
+	}
+	return rankTrending(queries, size)
+}
+
+// rankTrending sorts the rising terms, fastest first, and keeps the top size of them
+func rankTrending(queries []TrendingQuery, size int) []TrendingQuery {
+	sort.SliceStable(queries, func(i, j int) bool {
+		if queries[i].Growth != queries[j].Growth {
+			return queries[i].Growth > queries[j].Growth
+		}
+		if queries[i].Count != queries[j].Count {
+			return queries[i].Count > queries[j].Count
+		}
+		return strings.Compare(queries[i].Query, queries[j].Query) < 0
+	})
+
+	if len(queries) > size {
+		queries = queries[:size]
+	}
+	return queries
+}
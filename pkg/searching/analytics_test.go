@@ -0,0 +1,115 @@
+package searching
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+)
+
+func TestBuildTopQueriesQuery(t *testing.T) {
+	to := time.Date(2020, 1, 8, 0, 0, 0, 0, time.UTC)
+	req := AnalyticsRequest{Index: "droids", Window: 7 * 24 * time.Hour, To: to, Size: 5}
+
+	got, err := json.Marshal(buildTopQueriesQuery(req))
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling query: %s", err)
+	}
+
+	want := `{"aggs":{` +
+		`"clicked":{"filter":{"range":{"clicks":{"gt":0}}}},` +
+		`"top":{"terms":{"field":"searchTerm.keyword","size":5}},` +
+		`"zero":{"aggs":{"terms":{"terms":{"field":"searchTerm.keyword","size":5}}},"filter":{"term":{"hits":0}}},` +
+		`"zeroClick":{"aggs":{"terms":{"terms":{"field":"searchTerm.keyword","size":5}}},` +
+		`"filter":{"bool":{"filter":[{"range":{"hits":{"gt":0}}}],"must_not":[{"range":{"clicks":{"gt":0}}}]}}}},` +
+		`"query":{"range":{"date":{"format":"strict_date_optional_time","gte":"2020-01-01T00:00:00Z","lt":"2020-01-08T00:00:00Z"}}},` +
+		`"size":0,"track_total_hits":true}`
+
+	diff := cmp.Diff(want, string(got))
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestTrending(t *testing.T) {
+	var buckets termBuckets
+	body := `{"buckets":[` +
+		`{"key":"steady","doc_count":20,"current":{"doc_count":10},"previous":{"doc_count":10}},` +
+		`{"key":"new","doc_count":4,"current":{"doc_count":4},"previous":{"doc_count":0}},` +
+		`{"key":"doubled","doc_count":9,"current":{"doc_count":6},"previous":{"doc_count":3}},` +
+		`{"key":"falling","doc_count":9,"current":{"doc_count":1},"previous":{"doc_count":8}}]}`
+	if err := json.Unmarshal([]byte(body), &buckets); err != nil {
+		t.Fatalf("Unexpected error decoding buckets: %s", err)
+	}
+
+	want := []TrendingQuery{
+		{Query: "new", Count: 4, PreviousCount: 0, Growth: 5},
+		{Query: "doubled", Count: 6, PreviousCount: 3, Growth: 1.75},
+	}
+
+	diff := cmp.Diff(want, trending(buckets, 10))
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+
+	if got := trending(buckets, 1); len(got) != 1 {
+		t.Fatalf("trending size - expected : 1, received : %d", len(got))
+	}
+}
+
+func TestValidateAnalyticsRequest(t *testing.T) {
+	tests := map[string]struct {
+		req   AnalyticsRequest
+		valid bool
+	}{
+		"valid":          {req: AnalyticsRequest{Index: "droids", Window: time.Hour}, valid: true},
+		"missing index":  {req: AnalyticsRequest{Window: time.Hour}, valid: false},
+		"no window":      {req: AnalyticsRequest{Index: "droids"}, valid: false},
+		"size too large": {req: AnalyticsRequest{Index: "droids", Window: time.Hour, Size: MaxAnalyticsSize + 1}, valid: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.req.Validate()
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestAnalyticsNoQueries(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	es := srv.Client()
+
+	// nothing was logged under droids, so droids-queries doesn't exist
+	to := time.Date(2020, 1, 8, 0, 0, 0, 0, time.UTC)
+	req := AnalyticsRequest{Index: "droids", Window: 7 * 24 * time.Hour, To: to, Size: 5}
+
+	top, err := GetTopQueries(context.Background(), es, req)
+	if err != nil {
+		t.Fatalf("Unexpected error getting top queries: %s", err)
+	}
+	want := &TopQueries{From: to.Add(-req.Window), To: to, Queries: []QueryCount{}, ZeroResults: []QueryCount{}, ZeroClicks: []QueryCount{}}
+	if diff := cmp.Diff(want, top); diff != "" {
+		t.Fatalf(diff)
+	}
+
+	trending, err := GetTrendingQueries(context.Background(), es, req)
+	if err != nil {
+		t.Fatalf("Unexpected error getting trending queries: %s", err)
+	}
+	if len(trending.Queries) != 0 {
+		t.Fatalf("trending queries - expected none, received : %+v", trending.Queries)
+	}
+
+	for _, r := range srv.RequestsTo("", "/droids-queries/_search") {
+		if r.Query.Get("ignore_unavailable") != "true" {
+			t.Fatalf("analytics should ignore the missing queries index, received : %s", r.Query.Encode())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package searching
+
+import (
+	"sync"
+	"time"
+)
+
+// cacheTTL is how long the pins and rules of an index are cached. Changes made through a replica of the
+// API apply to its searches right away, and to those of the other replicas once their caches expire.
+const cacheTTL = 10 * time.Second
+
+// indexCache caches a value per index, such as its pins, for cacheTTL
+type indexCache struct {
+	mu      sync.Mutex
+	entries map[string]cached
+	// generations counts the invalidations of each index, so a value loaded before one isn't cached
+	generations map[string]uint64
+	now         func() time.Time
+}
+
+type cached struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newIndexCache() *indexCache {
+	return &indexCache{entries: map[string]cached{}, generations: map[string]uint64{}, now: time.Now}
+}
+
+// get returns the value of the index, loading it when it isn't cached or has expired. Values that fail to
+// load aren't cached. A nil cache loads the value every time.
+func (c *indexCache) get(index string, load func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return load()
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[index]
+	generation := c.generations[index]
+	c.mu.Unlock()
+	if ok && c.now().Before(e.expires) {
+		return e.value, nil
+	}
+
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generations[index] == generation {
+		c.entries[index] = cached{value: v, expires: c.now().Add(cacheTTL)}
+	}
+	return v, nil
+}
+
+// invalidate drops the value of the index, so the next get loads it again
+func (c *indexCache) invalidate(index string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, index)
+	c.generations[index]++
+}
@@ -0,0 +1,53 @@
+package searching
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIndexCache(t *testing.T) {
+	c := newIndexCache()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	loads := 0
+	load := func() (interface{}, error) {
+		loads++
+		return loads, nil
+	}
+	get := func(expected int) {
+		t.Helper()
+		v, err := c.get("droids", load)
+		if err != nil || v != expected {
+			t.Fatalf("expected : %d, received : %v, %v", expected, v, err)
+		}
+	}
+
+	get(1)
+	get(1)
+	now = now.Add(cacheTTL)
+	get(2)
+	c.invalidate("droids")
+	get(3)
+
+	// values that fail to load aren't cached
+	c.invalidate("droids")
+	if _, err := c.get("droids", func() (interface{}, error) { return nil, errors.New("unavailable") }); err == nil {
+		t.Fatalf("the load error should be returned")
+	}
+	get(4)
+
+	// a value loaded while the index is invalidated isn't cached
+	c.invalidate("droids")
+	c.get("droids", func() (interface{}, error) {
+		c.invalidate("droids")
+		return 0, nil
+	})
+	get(5)
+
+	var uncached *indexCache
+	if v, _ := uncached.get("droids", load); v != 6 {
+		t.Fatalf("a nil cache should load every time, received : %v", v)
+	}
+}
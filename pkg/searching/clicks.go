@@ -0,0 +1,93 @@
+package searching
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// clickRetries is how many times a click is retried when another update of the same search came first
+const clickRetries = 3
+
+// clickScript counts the clicks on the logged query and adds the clicked documents to its distinct clicked IDs
+const clickScript = `
+if (ctx._source.clicks == null) { ctx._source.clicks = 0 }
+ctx._source.clicks += params.clicks;
+if (ctx._source.clickedIds == null) { ctx._source.clickedIds = [] }
+for (id in params.ids) { if (!ctx._source.clickedIds.contains(id)) { ctx._source.clickedIds.add(id) } }
+`
+
+// ErrSearchNotFound is returned, wrapped, when a click refers to a search ID that wasn't logged under the
+// index
+var ErrSearchNotFound = errors.New("search not found")
+
+// Click represents a client clicking on one of the hits of a search, identified by the searchId and
+// logIndex of the search response
+type Click struct {
+	SearchID string `json:"searchId"`
+	// Index is the logIndex of the search, the index it was logged under
+	Index      string `json:"index"`
+	DocumentID string `json:"documentId"`
+}
+
+// Validate checks that the click refers to a search and a document. Its errors are of kind ErrBadRequest.
+func (c Click) Validate() error {
+	if c.SearchID == "" || c.Index == "" || c.DocumentID == "" {
+		return invalid(fmt.Errorf("clicks need a searchId, an index and a documentId"))
+	}
+	if strings.ContainsAny(c.Index, `*?,/\ "<>|#:`) {
+		return invalid(fmt.Errorf("click index must be the logIndex of the search, got %q", c.Index))
+	}
+	return nil
+}
+
+// RecordClick adds a click to the logged query of the search it was made on. The query must have been
+// indexed already, see Elastic.RecordClick for the queries still queued. Errors are of kind
+// ErrSearchNotFound when it wasn't.
+func RecordClick(ctx context.Context, es *elasticsearch.Client, c Click) error {
+	return updateClicks(ctx, es, c.Index, c.SearchID, 1, []string{c.DocumentID})
+}
+
+// updateClicks adds the clicks on the documents to the logged query of a search
+func updateClicks(ctx context.Context, es *elasticsearch.Client, index string, searchID string, clicks int, ids []string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": clickScript,
+			"lang":   "painless",
+			"params": map[string]interface{}{"clicks": clicks, "ids": ids},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	retries := clickRetries
+	req := esapi.UpdateRequest{
+		Index:           queriesIndex(index),
+		DocumentID:      searchID,
+		Body:            bytes.NewReader(body),
+		RetryOnConflict: &retries,
+	}
+
+	res, err := req.Do(ctx, es)
+	if err != nil {
+		return requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	// the query isn't indexed, or its index doesn't exist yet
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: no search ID=%s in %s", ErrSearchNotFound, searchID, queriesIndex(index))
+	}
+	if res.IsError() {
+		return responseError(res, fmt.Sprintf("Error recording click on search ID=%s", searchID))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package searching
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+)
+
+func TestValidateClick(t *testing.T) {
+	tests := map[string]struct {
+		click Click
+		valid bool
+	}{
+		"valid":            {click: Click{SearchID: "abc", Index: "droids", DocumentID: "1234"}, valid: true},
+		"missing search":   {click: Click{Index: "droids", DocumentID: "1234"}, valid: false},
+		"missing index":    {click: Click{SearchID: "abc", DocumentID: "1234"}, valid: false},
+		"missing document": {click: Click{SearchID: "abc", Index: "droids"}, valid: false},
+		"pattern":          {click: Click{SearchID: "abc", Index: "crawler-*", DocumentID: "1234"}, valid: false},
+		"several indices":  {click: Click{SearchID: "abc", Index: "droids,ships", DocumentID: "1234"}, valid: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.click.Validate()
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestRecordClick(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	es := srv.Client()
+
+	// the Painless of clickScript, which estest can't run
+	srv.Script(clickScript, func(source, params map[string]interface{}) {
+		clicks, _ := source["clicks"].(float64)
+		source["clicks"] = clicks + params["clicks"].(float64)
+		ids, _ := source["clickedIds"].([]interface{})
+	next:
+		for _, id := range params["ids"].([]interface{}) {
+			for _, clicked := range ids {
+				if clicked == id {
+					continue next
+				}
+			}
+			ids = append(ids, id)
+		}
+		source["clickedIds"] = ids
+	})
+
+	ctx := context.Background()
+	ql := NewQueryLog(es, conf.QueryLogOptions{FlushIntervalMillis: 60000}, logrus.New())
+	e := &Elastic{Client: es, QueryLog: ql, Log: logrus.New()}
+	hits := 2
+	ql.Log("droids", IndexQuery{SearchID: "a", Index: "droids", Query: "r2d2", Date: "2020-01-01 00:00:00", Hits: &hits})
+	ql.Log("droids", IndexQuery{SearchID: "c", Index: "droids", Query: "c3po", Date: "2020-01-01 00:00:00", Hits: &hits})
+
+	// c is clicked while it's being indexed
+	srv.HandleOnce("POST", "/_bulk", func(w http.ResponseWriter, r *http.Request) {
+		if err := e.RecordClick(ctx, Click{SearchID: "c", Index: "droids", DocumentID: "3"}); err != nil {
+			t.Errorf("Unexpected error recording click during the flush: %s", err)
+		}
+		res, err := http.Post(srv.URL+r.URL.Path, "application/x-ndjson", r.Body)
+		if err != nil {
+			t.Errorf("Unexpected error indexing the queries: %s", err)
+			return
+		}
+		defer res.Body.Close()
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+	})
+
+	for _, c := range []Click{
+		{SearchID: "a", Index: "droids", DocumentID: "1"},
+		{SearchID: "a", Index: "droids", DocumentID: "2"},
+		{SearchID: "a", Index: "droids", DocumentID: "1"},
+	} {
+		if err := e.RecordClick(ctx, c); err != nil {
+			t.Fatalf("Unexpected error recording click before the flush: %s", err)
+		}
+	}
+	if len(srv.RequestsTo("POST", "/droids-queries/_update/*")) != 0 {
+		t.Fatalf("clicks on queued searches should be indexed with them")
+	}
+
+	closeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := ql.Close(closeCtx); err != nil {
+		t.Fatalf("Unexpected error closing the query log: %s", err)
+	}
+
+	// once indexed, searches are clicked with _update
+	if err := e.RecordClick(ctx, Click{SearchID: "a", Index: "droids", DocumentID: "3"}); err != nil {
+		t.Fatalf("Unexpected error recording click after the flush: %s", err)
+	}
+
+	tests := map[string]string{
+		"a": `{"clickedIds":["1","2","3"],"clicks":4,"date":"2020-01-01 00:00:00","hits":2,"index":"droids","latencyMillis":0,"searchId":"a","searchTerm":"r2d2","user-agent":""}`,
+		"c": `{"clickedIds":["3"],"clicks":1,"date":"2020-01-01 00:00:00","hits":2,"index":"droids","latencyMillis":0,"searchId":"c","searchTerm":"c3po","user-agent":""}`,
+	}
+	for id, expected := range tests {
+		source, ok := srv.Document("droids-queries", id)
+		if !ok {
+			t.Fatalf("search %s should have been logged", id)
+		}
+		if diff := cmp.Diff(expected, string(source)); diff != "" {
+			t.Fatalf("search %s: %s", id, diff)
+		}
+	}
+
+	// b was never logged, as when the query log drops it, and nothing is logged under gonks
+	for _, c := range []Click{
+		{SearchID: "b", Index: "droids", DocumentID: "1"},
+		{SearchID: "a", Index: "gonks", DocumentID: "1"},
+	} {
+		if err := e.RecordClick(ctx, c); !errors.Is(err, ErrSearchNotFound) {
+			t.Fatalf("error - expected : %s, received : %v", ErrSearchNotFound, err)
+		}
+	}
+	if _, ok := srv.Document("droids-queries", "b"); ok {
+		t.Fatalf("clicks shouldn't create searches")
+	}
+}
@@ -0,0 +1,116 @@
+package searching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// The kinds of errors searching returns. Errors are classified with errors.Is, e.g.
+// errors.Is(err, ErrIndexNotFound), and keep their own message.
+var (
+	// ErrBadRequest is returned when a request is invalid, or Elasticsearch rejects the query built from it
+	ErrBadRequest = errors.New("bad request")
+	// ErrIndexNotFound is returned when the searched index or alias doesn't exist
+	ErrIndexNotFound = errors.New("index not found")
+	// ErrUnavailable is returned when Elasticsearch can't be reached or fails to handle the request
+	ErrUnavailable = errors.New("upstream unavailable")
+	// ErrTimeout is returned when Elasticsearch doesn't answer before the request's deadline
+	ErrTimeout = errors.New("timeout")
+	// ErrParse is returned when a response from Elasticsearch can't be read
+	ErrParse = errors.New("parse failure")
+)
+
+// Error is an error of one of the kinds above
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the target kind
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+// esError represents the error body of an Elasticsearch response
+type esError struct {
+	Error struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// invalid marks err as a bad request, unless it is nil
+func invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: ErrBadRequest, Err: err}
+}
+
+// parseError marks err as a failure to read the response of Elasticsearch
+func parseError(err error) error {
+	return &Error{Kind: ErrParse, Err: fmt.Errorf("Error parsing the response body: %w", err)}
+}
+
+// requestError classifies an error returned instead of a response: the request either ran out of time
+// or couldn't be sent
+func requestError(ctx context.Context, err error) error {
+	err = fmt.Errorf("Error getting response: %w", err)
+	if ctx.Err() == context.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Kind: ErrTimeout, Err: err}
+	}
+	return &Error{Kind: ErrUnavailable, Err: err}
+}
+
+// queryErrors are the types of the errors Elasticsearch rejects a query with, because of what the client
+// asked for
+var queryErrors = map[string]bool{
+	"search_phase_execution_exception":    true,
+	"query_shard_exception":               true,
+	"parsing_exception":                   true,
+	"x_content_parse_exception":           true,
+	"illegal_argument_exception":          true,
+	"action_request_validation_exception": true,
+	"too_many_clauses":                    true,
+	"too_many_nested_clauses":             true,
+}
+
+// responseError classifies the error response of Elasticsearch by its status, and the type of error in
+// its body. msg describes what was being done. Only the query errors of a 400 are the client's, and other errors, such as
+// Elasticsearch rejecting the API's credentials, are the upstream's.
+func responseError(res *esapi.Response, msg string) error {
+	var e esError
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if json.Unmarshal(body, &e) != nil || e.Error.Type == "" {
+		// not an error object, e.g. a proxy's error page
+		e.Error.Type, e.Error.Reason = "error", string(body)
+	}
+	err := fmt.Errorf("[%s] %s: %s: %s", res.Status(), msg, e.Error.Type, e.Error.Reason)
+
+	switch {
+	case e.Error.Type == "index_not_found_exception":
+		return &Error{Kind: ErrIndexNotFound, Err: err}
+	case res.StatusCode == http.StatusRequestTimeout || res.StatusCode == http.StatusGatewayTimeout:
+		return &Error{Kind: ErrTimeout, Err: err}
+	case res.StatusCode == http.StatusBadRequest && queryErrors[e.Error.Type]:
+		return &Error{Kind: ErrBadRequest, Err: err}
+	default:
+		return &Error{Kind: ErrUnavailable, Err: err}
+	}
+}
@@ -0,0 +1,99 @@
+package searching
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+func TestResponseError(t *testing.T) {
+	tests := map[string]struct {
+		status int
+		body   string
+		kind   error
+		msg    string
+	}{
+		"index not found": {
+			status: 404,
+			body:   `{"error":{"type":"index_not_found_exception","reason":"no such index [droids]"},"status":404}`,
+			kind:   ErrIndexNotFound,
+			msg:    "[404 Not Found] Error searching droids: index_not_found_exception: no such index [droids]",
+		},
+		"bad query": {
+			status: 400,
+			body:   `{"error":{"type":"search_phase_execution_exception","reason":"all shards failed"},"status":400}`,
+			kind:   ErrBadRequest,
+			msg:    "[400 Bad Request] Error searching droids: search_phase_execution_exception: all shards failed",
+		},
+		"unavailable": {
+			status: 503,
+			body:   `{"error":{"type":"cluster_block_exception","reason":"blocked"},"status":503}`,
+			kind:   ErrUnavailable,
+			msg:    "[503 Service Unavailable] Error searching droids: cluster_block_exception: blocked",
+		},
+		"parsing": {
+			status: 400,
+			body:   `{"error":{"type":"parsing_exception","reason":"unknown query [mach]"},"status":400}`,
+			kind:   ErrBadRequest,
+			msg:    "[400 Bad Request] Error searching droids: parsing_exception: unknown query [mach]",
+		},
+		"other 400": {
+			status: 400,
+			body:   `{"error":{"type":"resource_already_exists_exception","reason":"index [droids] already exists"},"status":400}`,
+			kind:   ErrUnavailable,
+			msg:    "[400 Bad Request] Error searching droids: resource_already_exists_exception: index [droids] already exists",
+		},
+		"unauthorized": {
+			status: 401,
+			body:   `{"error":{"type":"security_exception","reason":"unable to authenticate user [elastic]"},"status":401}`,
+			kind:   ErrUnavailable,
+			msg:    "[401 Unauthorized] Error searching droids: security_exception: unable to authenticate user [elastic]",
+		},
+		"forbidden": {
+			status: 403,
+			body:   `{"error":{"type":"security_exception","reason":"action [indices:data/read/search] is unauthorized"},"status":403}`,
+			kind:   ErrUnavailable,
+			msg:    "[403 Forbidden] Error searching droids: security_exception: action [indices:data/read/search] is unauthorized",
+		},
+		"conflict": {status: 409, body: `{"error":{"type":"version_conflict_engine_exception","reason":"version conflict"},"status":409}`, kind: ErrUnavailable, msg: "[409 Conflict] Error searching droids: version_conflict_engine_exception: version conflict"},
+		"rejected": {status: 429, body: `{}`, kind: ErrUnavailable, msg: "[429 Too Many Requests] Error searching droids: error: {}"},
+		"gateway":  {status: 504, body: `Gateway Timeout`, kind: ErrTimeout, msg: "[504 Gateway Timeout] Error searching droids: error: Gateway Timeout"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := &esapi.Response{StatusCode: tc.status, Body: ioutil.NopCloser(strings.NewReader(tc.body))}
+			err := responseError(res, "Error searching droids")
+			if !errors.Is(err, tc.kind) {
+				t.Fatalf("kind - expected : %s, received : %v", tc.kind, err)
+			}
+			if err.Error() != tc.msg {
+				t.Fatalf("message - expected : %s, received : %s", tc.msg, err)
+			}
+		})
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	if err := requestError(context.Background(), errors.New("connection refused")); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("kind - expected : %s, received : %v", ErrUnavailable, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if err := requestError(ctx, ctx.Err()); !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("kind - expected : %s wrapping the context's error, received : %v", ErrTimeout, err)
+	}
+}
+
+func TestValidateIsBadRequest(t *testing.T) {
+	err := SearchRequest{Page: -1}.Validate()
+	if !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("kind - expected : %s, received : %v", ErrBadRequest, err)
+	}
+}
@@ -0,0 +1,250 @@
+package searching
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Types of facets that can be requested
+const (
+	FacetTypeTerms         = "terms"
+	FacetTypeDateHistogram = "date_histogram"
+	FacetTypeRange         = "range"
+)
+
+const defaultFacetSize = 10
+
+// Facet describes a count of hits per distinct value (terms), per date interval (date_histogram) or per
+// named range (range) of a field. Selected values narrow the hits down without changing the counts of
+// the facet itself, so the UI can keep showing the other options.
+type Facet struct {
+	Name     string        `json:"name"`
+	Field    string        `json:"field"`
+	Type     string        `json:"type,omitempty"`
+	Size     int           `json:"size,omitempty"`
+	Interval string        `json:"interval,omitempty"`
+	Format   string        `json:"format,omitempty"`
+	Ranges   []FacetRange  `json:"ranges,omitempty"`
+	Selected []interface{} `json:"selected,omitempty"`
+}
+
+// FacetRange represents a named bucket of a range facet
+type FacetRange struct {
+	Key  string      `json:"key"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// FacetResult represents the counts of a facet in the search response
+type FacetResult struct {
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Buckets []FacetBucket `json:"buckets"`
+}
+
+// FacetBucket represents the number of hits for one value of a facet
+type FacetBucket struct {
+	Key      string `json:"key"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected,omitempty"`
+}
+
+// aggregation is the part of an Elasticsearch aggregation response the facets are built from
+type aggregation struct {
+	Buckets []struct {
+		Key         interface{} `json:"key"`
+		KeyAsString string      `json:"key_as_string"`
+		DocCount    int         `json:"doc_count"`
+	} `json:"buckets"`
+}
+
+func (f Facet) facetType() string {
+	if f.Type == "" {
+		return FacetTypeTerms
+	}
+	return f.Type
+}
+
+func (f Facet) validate() error {
+	if f.Name == "" || f.Field == "" {
+		return fmt.Errorf("facets need a name and a field")
+	}
+
+	switch f.facetType() {
+	case FacetTypeTerms:
+		if f.Size < 0 || f.Size > MaxPageSize {
+			return fmt.Errorf("facet %q size must be between 1 and %d, got %d", f.Name, MaxPageSize, f.Size)
+		}
+	case FacetTypeDateHistogram:
+		if f.Interval == "" {
+			return fmt.Errorf("date_histogram facet %q needs an interval", f.Name)
+		}
+		if len(f.Selected) > 0 {
+			return fmt.Errorf("date_histogram facet %q can't have selected values, use a range filter instead", f.Name)
+		}
+	case FacetTypeRange:
+		if len(f.Ranges) == 0 {
+			return fmt.Errorf("range facet %q needs at least one range", f.Name)
+		}
+		for _, s := range f.Selected {
+			if _, ok := f.rangeByKey(fmt.Sprint(s)); !ok {
+				return fmt.Errorf("range facet %q has no range with key %v", f.Name, s)
+			}
+		}
+	default:
+		return fmt.Errorf("facet %q has unknown type %q, expected one of %s, %s or %s", f.Name, f.Type, FacetTypeTerms, FacetTypeDateHistogram, FacetTypeRange)
+	}
+
+	return nil
+}
+
+func validateFacets(facets []Facet) error {
+	names := map[string]bool{}
+	for _, f := range facets {
+		if err := f.validate(); err != nil {
+			return err
+		}
+		if names[f.Name] {
+			return fmt.Errorf("facet name %q is used more than once", f.Name)
+		}
+		names[f.Name] = true
+	}
+	return nil
+}
+
+func (f Facet) rangeByKey(key string) (FacetRange, bool) {
+	for _, r := range f.Ranges {
+		if r.Key == key {
+			return r, true
+		}
+	}
+	return FacetRange{}, false
+}
+
+// aggregation returns the Elasticsearch aggregation that counts the facet's buckets
+func (f Facet) aggregation() map[string]interface{} {
+	switch f.facetType() {
+	case FacetTypeDateHistogram:
+		agg := map[string]interface{}{"field": f.Field, "calendar_interval": f.Interval}
+		if f.Format != "" {
+			agg["format"] = f.Format
+		}
+		return map[string]interface{}{"date_histogram": agg}
+	case FacetTypeRange:
+		agg := map[string]interface{}{"field": f.Field, "ranges": f.Ranges}
+		if f.Format != "" {
+			agg["format"] = f.Format
+		}
+		return map[string]interface{}{FacetTypeRange: agg}
+	default:
+		size := f.Size
+		if size == 0 {
+			size = defaultFacetSize
+		}
+		return map[string]interface{}{"terms": map[string]interface{}{"field": f.Field, "size": size}}
+	}
+}
+
+// selection returns the filter clause matching the facet's selected values, or nil if nothing is selected
+func (f Facet) selection() map[string]interface{} {
+	if len(f.Selected) == 0 {
+		return nil
+	}
+
+	if f.facetType() == FacetTypeRange {
+		var should []interface{}
+		for _, s := range f.Selected {
+			r, _ := f.rangeByKey(fmt.Sprint(s))
+			bounds := map[string]interface{}{}
+			if r.From != nil {
+				bounds["gte"] = r.From
+			}
+			if r.To != nil {
+				bounds["lt"] = r.To
+			}
+			should = append(should, map[string]interface{}{"range": map[string]interface{}{f.Field: bounds}})
+		}
+		return map[string]interface{}{"bool": map[string]interface{}{"should": should, "minimum_should_match": 1}}
+	}
+
+	return map[string]interface{}{"terms": map[string]interface{}{f.Field: f.Selected}}
+}
+
+// facetQuery adds the facet aggregations and the post_filter for the selected values to the query. Each
+// aggregation is wrapped in a filter aggregation holding the selections of all the other facets, so a
+// facet's counts reflect every selection but its own.
+func facetQuery(q *Query, facets []Facet) {
+	if len(facets) == 0 {
+		return
+	}
+
+	selections := make([]map[string]interface{}, len(facets))
+	var selected []interface{}
+	for i, f := range facets {
+		selections[i] = f.selection()
+		if selections[i] != nil {
+			selected = append(selected, selections[i])
+		}
+	}
+
+	q.Aggregations = map[string]interface{}{}
+	for i, f := range facets {
+		var others []interface{}
+		for j, s := range selections {
+			if j != i && s != nil {
+				others = append(others, s)
+			}
+		}
+
+		filter := map[string]interface{}{"match_all": map[string]interface{}{}}
+		if len(others) > 0 {
+			filter = map[string]interface{}{"bool": map[string]interface{}{"filter": others}}
+		}
+
+		q.Aggregations[f.Name] = map[string]interface{}{
+			"filter": filter,
+			"aggs":   map[string]interface{}{f.Name: f.aggregation()},
+		}
+	}
+
+	if len(selected) > 0 {
+		q.PostFilter = map[string]interface{}{"bool": map[string]interface{}{"filter": selected}}
+	}
+}
+
+// facetResults turns the raw aggregations of the response into facets, in the order they were requested
+func facetResults(aggs map[string]json.RawMessage, facets []Facet) ([]FacetResult, error) {
+	results := make([]FacetResult, 0, len(facets))
+
+	for _, f := range facets {
+		var wrapper map[string]json.RawMessage
+		if err := json.Unmarshal(aggs[f.Name], &wrapper); err != nil {
+			return nil, fmt.Errorf("Error parsing facet %q: %s", f.Name, err)
+		}
+
+		var agg aggregation
+		d := json.NewDecoder(bytes.NewReader(wrapper[f.Name]))
+		d.UseNumber()
+		if err := d.Decode(&agg); err != nil {
+			return nil, fmt.Errorf("Error parsing facet %q: %s", f.Name, err)
+		}
+
+		selected := map[string]bool{}
+		for _, s := range f.Selected {
+			selected[fmt.Sprint(s)] = true
+		}
+
+		fr := FacetResult{Name: f.Name, Type: f.facetType(), Buckets: []FacetBucket{}}
+		for _, b := range agg.Buckets {
+			key := b.KeyAsString
+			if key == "" || f.facetType() == FacetTypeRange {
+				key = fmt.Sprint(b.Key)
+			}
+			fr.Buckets = append(fr.Buckets, FacetBucket{Key: key, Count: b.DocCount, Selected: selected[key]})
+		}
+		results = append(results, fr)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,100 @@
+package searching
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateFacets(t *testing.T) {
+	tests := map[string]struct {
+		facets []Facet
+		valid  bool
+	}{
+		"terms":                {facets: []Facet{{Name: "type", Field: "meta.type"}}, valid: true},
+		"missing field":        {facets: []Facet{{Name: "type"}}, valid: false},
+		"unknown type":         {facets: []Facet{{Name: "type", Field: "meta.type", Type: "avg"}}, valid: false},
+		"duplicate names":      {facets: []Facet{{Name: "type", Field: "a"}, {Name: "type", Field: "b"}}, valid: false},
+		"histogram interval":   {facets: []Facet{{Name: "date", Field: "date", Type: FacetTypeDateHistogram}}, valid: false},
+		"histogram selection":  {facets: []Facet{{Name: "date", Field: "date", Type: FacetTypeDateHistogram, Interval: "month", Selected: []interface{}{"2020-01"}}}, valid: false},
+		"range without ranges": {facets: []Facet{{Name: "size", Field: "size", Type: FacetTypeRange}}, valid: false},
+		"range unknown key": {
+			facets: []Facet{{Name: "size", Field: "size", Type: FacetTypeRange, Ranges: []FacetRange{{Key: "small", To: 10}}, Selected: []interface{}{"large"}}},
+			valid:  false,
+		},
+		"range selection": {
+			facets: []Facet{{Name: "size", Field: "size", Type: FacetTypeRange, Ranges: []FacetRange{{Key: "small", To: 10}}, Selected: []interface{}{"small"}}},
+			valid:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateFacets(tc.facets)
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestFacetQuery(t *testing.T) {
+	facets := []Facet{
+		{Name: "type", Field: "meta.type", Selected: []interface{}{"blog"}},
+		{Name: "size", Field: "size", Type: FacetTypeRange, Ranges: []FacetRange{{Key: "small", To: 10}}},
+		{Name: "date", Field: "date", Type: FacetTypeDateHistogram, Interval: "month"},
+	}
+
+	q := Query{}
+	facetQuery(&q, facets)
+
+	type results struct {
+		Aggregations string
+		PostFilter   string
+	}
+
+	aggs, _ := json.Marshal(q.Aggregations)
+	postFilter, _ := json.Marshal(q.PostFilter)
+	got := results{string(aggs), string(postFilter)}
+
+	want := results{
+		Aggregations: `{` +
+			`"date":{"aggs":{"date":{"date_histogram":{"calendar_interval":"month","field":"date"}}},"filter":{"bool":{"filter":[{"terms":{"meta.type":["blog"]}}]}}},` +
+			`"size":{"aggs":{"size":{"range":{"field":"size","ranges":[{"key":"small","to":10}]}}},"filter":{"bool":{"filter":[{"terms":{"meta.type":["blog"]}}]}}},` +
+			`"type":{"aggs":{"type":{"terms":{"field":"meta.type","size":10}}},"filter":{"match_all":{}}}` +
+			`}`,
+		PostFilter: `{"bool":{"filter":[{"terms":{"meta.type":["blog"]}}]}}`,
+	}
+
+	diff := cmp.Diff(want, got)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestFacetResults(t *testing.T) {
+	facets := []Facet{
+		{Name: "type", Field: "meta.type", Selected: []interface{}{"blog"}},
+		{Name: "date", Field: "date", Type: FacetTypeDateHistogram, Interval: "year"},
+	}
+	aggs := map[string]json.RawMessage{
+		"type": json.RawMessage(`{"doc_count":5,"type":{"buckets":[{"key":"blog","doc_count":3},{"key":"news","doc_count":2}]}}`),
+		"date": json.RawMessage(`{"doc_count":5,"date":{"buckets":[{"key_as_string":"2020-01-01","key":1577836800000,"doc_count":5}]}}`),
+	}
+
+	got, err := facetResults(aggs, facets)
+	if err != nil {
+		t.Fatalf("Unexpected error normalizing facets: %s", err)
+	}
+
+	want := []FacetResult{
+		{Name: "type", Type: FacetTypeTerms, Buckets: []FacetBucket{{Key: "blog", Count: 3, Selected: true}, {Key: "news", Count: 2}}},
+		{Name: "date", Type: FacetTypeDateHistogram, Buckets: []FacetBucket{{Key: "2020-01-01", Count: 5}}},
+	}
+
+	diff := cmp.Diff(want, got)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
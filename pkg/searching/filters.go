@@ -0,0 +1,123 @@
+package searching
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Filter restricts the hits of a search to documents matching a condition on a single field. Exactly one
+// of Term, Terms, Range, Exists or Prefix must be set.
+type Filter struct {
+	Field  string        `json:"field"`
+	Term   interface{}   `json:"term,omitempty"`
+	Terms  []interface{} `json:"terms,omitempty"`
+	Range  *Range        `json:"range,omitempty"`
+	Exists bool          `json:"exists,omitempty"`
+	Prefix string        `json:"prefix,omitempty"`
+}
+
+// Range represents the bounds of a range filter. Bounds can be numbers or dates.
+type Range struct {
+	GT     interface{} `json:"gt,omitempty"`
+	GTE    interface{} `json:"gte,omitempty"`
+	LT     interface{} `json:"lt,omitempty"`
+	LTE    interface{} `json:"lte,omitempty"`
+	Format string      `json:"format,omitempty"`
+}
+
+func (f Filter) validate() error {
+	if f.Field == "" {
+		return fmt.Errorf("filter is missing a field")
+	}
+
+	set := 0
+	for _, ok := range []bool{f.Term != nil, len(f.Terms) > 0, f.Range != nil, f.Exists, f.Prefix != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("filter on %q must have exactly one of term, terms, range, exists or prefix", f.Field)
+	}
+
+	if f.Range != nil && f.Range.GT == nil && f.Range.GTE == nil && f.Range.LT == nil && f.Range.LTE == nil {
+		return fmt.Errorf("range filter on %q needs at least one bound", f.Field)
+	}
+
+	return nil
+}
+
+// clause returns the Elasticsearch query clause for a filter that has been validated
+func (f Filter) clause() map[string]interface{} {
+	switch {
+	case f.Term != nil:
+		return map[string]interface{}{"term": map[string]interface{}{f.Field: f.Term}}
+	case len(f.Terms) > 0:
+		return map[string]interface{}{"terms": map[string]interface{}{f.Field: f.Terms}}
+	case f.Range != nil:
+		return map[string]interface{}{"range": map[string]interface{}{f.Field: f.Range}}
+	case f.Exists:
+		return map[string]interface{}{"exists": map[string]interface{}{"field": f.Field}}
+	default:
+		return map[string]interface{}{"prefix": map[string]interface{}{f.Field: f.Prefix}}
+	}
+}
+
+// String returns a readable form of the filter, such as `species term "Robot"`, for logging
+func (f Filter) String() string {
+	for op, v := range f.clause() {
+		b, err := json.Marshal(v.(map[string]interface{})[f.Field])
+		if op == "exists" || err != nil {
+			return fmt.Sprintf("%s %s", f.Field, op)
+		}
+		return fmt.Sprintf("%s %s %s", f.Field, op, b)
+	}
+	return f.Field
+}
+
+func filterClauses(filters []Filter) []interface{} {
+	clauses := make([]interface{}, 0, len(filters))
+	for _, f := range filters {
+		clauses = append(clauses, f.clause())
+	}
+	return clauses
+}
+
+// ParseFilter builds a filter from the values of a `filter.<field>=` query string parameter:
+//   - several values match any of them (terms)
+//   - `*` matches documents that have the field (exists)
+//   - `from..to` matches a range, either bound may be left empty
+//   - a value ending in `*` matches by prefix
+//   - anything else must match exactly (term)
+func ParseFilter(field string, values []string) (Filter, error) {
+	f := Filter{Field: field}
+
+	switch {
+	case len(values) == 0:
+		return f, fmt.Errorf("filter on %q is missing a value", field)
+	case len(values) > 1:
+		for _, v := range values {
+			f.Terms = append(f.Terms, v)
+		}
+	case values[0] == "*":
+		f.Exists = true
+	case strings.Contains(values[0], ".."):
+		bounds := strings.SplitN(values[0], "..", 2)
+		f.Range = &Range{}
+		if bounds[0] != "" {
+			f.Range.GTE = bounds[0]
+		}
+		if bounds[1] != "" {
+			f.Range.LTE = bounds[1]
+		}
+	case strings.HasSuffix(values[0], "*"):
+		f.Prefix = strings.TrimSuffix(values[0], "*")
+	case values[0] == "":
+		return f, fmt.Errorf("filter on %q is missing a value", field)
+	default:
+		f.Term = values[0]
+	}
+
+	return f, f.validate()
+}
@@ -0,0 +1,119 @@
+package searching
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := map[string]struct {
+		values []string
+		clause string
+		valid  bool
+	}{
+		"term":        {values: []string{"blog"}, clause: `{"term":{"f":"blog"}}`, valid: true},
+		"terms":       {values: []string{"blog", "news"}, clause: `{"terms":{"f":["blog","news"]}}`, valid: true},
+		"exists":      {values: []string{"*"}, clause: `{"exists":{"field":"f"}}`, valid: true},
+		"prefix":      {values: []string{"doc*"}, clause: `{"prefix":{"f":"doc"}}`, valid: true},
+		"range":       {values: []string{"2020-01-01..2021-01-01"}, clause: `{"range":{"f":{"gte":"2020-01-01","lte":"2021-01-01"}}}`, valid: true},
+		"open range":  {values: []string{"..10"}, clause: `{"range":{"f":{"lte":"10"}}}`, valid: true},
+		"empty range": {values: []string{".."}, valid: false},
+		"empty value": {values: []string{""}, valid: false},
+		"no value":    {values: []string{}, valid: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := ParseFilter("f", tc.values)
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+			if !tc.valid {
+				return
+			}
+
+			clause, err := json.Marshal(f.clause())
+			if err != nil {
+				t.Fatalf("Unexpected error marshalling clause: %s", err)
+			}
+			if diff := cmp.Diff(tc.clause, string(clause)); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	tests := map[string]struct {
+		filter Filter
+		valid  bool
+	}{
+		"term":          {filter: Filter{Field: "f", Term: "a"}, valid: true},
+		"missing field": {filter: Filter{Term: "a"}, valid: false},
+		"no operator":   {filter: Filter{Field: "f"}, valid: false},
+		"two operators": {filter: Filter{Field: "f", Term: "a", Prefix: "b"}, valid: false},
+		"unbound range": {filter: Filter{Field: "f", Range: &Range{Format: "yyyy"}}, valid: false},
+		"bounded range": {filter: Filter{Field: "f", Range: &Range{GT: 1}}, valid: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := SearchRequest{Filters: []Filter{tc.filter}}.Validate()
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestBuildQueryWithFilters(t *testing.T) {
+	tests := map[string]struct {
+		req   SearchRequest
+		query string
+	}{
+		"no filters": {
+			req:   SearchRequest{SearchTerm: "r2d2", Fields: []string{"name"}},
+			query: `{"multi_match":{"fields":["name"],"query":"r2d2"}}`,
+		},
+		"filters": {
+			req: SearchRequest{SearchTerm: "r2d2", Filters: []Filter{{Field: "species", Term: "droid"}, {Field: "era", Exists: true}}},
+			query: `{"bool":{"filter":[{"term":{"species":"droid"}},{"exists":{"field":"era"}}],` +
+				`"must":[{"multi_match":{"query":"r2d2"}}]}}`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q, err := json.Marshal(buildQuery(tc.req, nil).Query)
+			if err != nil {
+				t.Fatalf("Unexpected error marshalling query: %s", err)
+			}
+			if diff := cmp.Diff(tc.query, string(q)); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	tests := map[string]struct {
+		filter Filter
+		want   string
+	}{
+		"term":   {filter: Filter{Field: "species", Term: "Robot"}, want: `species term "Robot"`},
+		"terms":  {filter: Filter{Field: "species", Terms: []interface{}{"Robot", "Human"}}, want: `species terms ["Robot","Human"]`},
+		"range":  {filter: Filter{Field: "built", Range: &Range{GTE: 1977}}, want: `built range {"gte":1977}`},
+		"exists": {filter: Filter{Field: "built", Exists: true}, want: `built exists`},
+		"prefix": {filter: Filter{Field: "name", Prefix: "r2"}, want: `name prefix "r2"`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, tc.filter.String()); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package searching
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ClusterHealth is the health of the Elasticsearch cluster searches run on
+type ClusterHealth struct {
+	// Status is green, yellow or red
+	Status              string  `json:"status"`
+	NumberOfNodes       int     `json:"number_of_nodes"`
+	ActiveShardsPercent float64 `json:"active_shards_percent_as_number"`
+}
+
+// HealthChecker checks that searches can be served
+type HealthChecker interface {
+	ClusterHealth(ctx context.Context) (*ClusterHealth, error)
+	// MissingIndices returns those of the indices or aliases that don't exist
+	MissingIndices(ctx context.Context, names []string) ([]string, error)
+}
+
+// GetClusterHealth returns the health of the cluster
+func GetClusterHealth(ctx context.Context, es *elasticsearch.Client) (*ClusterHealth, error) {
+	res, err := es.Cluster.Health(es.Cluster.Health.WithContext(ctx))
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res, "Error getting the cluster health")
+	}
+
+	var h ClusterHealth
+	if err := json.NewDecoder(res.Body).Decode(&h); err != nil {
+		return nil, parseError(err)
+	}
+	return &h, nil
+}
+
+// GetMissingIndices returns those of the indices or aliases that don't exist, in the order they are given
+func GetMissingIndices(ctx context.Context, es *elasticsearch.Client, names []string) ([]string, error) {
+	missing := []string{}
+	for _, name := range names {
+		req := esapi.IndicesExistsRequest{Index: []string{name}}
+		res, err := req.Do(ctx, es)
+		if err != nil {
+			return nil, requestError(ctx, err)
+		}
+
+		switch {
+		case res.StatusCode == http.StatusNotFound:
+			missing = append(missing, name)
+		case res.IsError():
+			err = responseError(res, fmt.Sprintf("Error checking that %s exists", name))
+		}
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,48 @@
+package searching
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+)
+
+func TestGetClusterHealth(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.SetHealth("yellow")
+
+	h, err := GetClusterHealth(context.Background(), srv.Client())
+	if err != nil {
+		t.Fatalf("Unexpected error getting the cluster health: %s", err)
+	}
+	if h.Status != "yellow" || h.NumberOfNodes != 1 {
+		t.Fatalf("expected a yellow cluster of 1 node, received : %+v", h)
+	}
+
+	srv.Fail("GET", "/_cluster/health", 503, "master_not_discovered_exception", "no master")
+	if _, err := GetClusterHealth(context.Background(), srv.Client()); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("error should be an unavailable error, received : %v", err)
+	}
+}
+
+func TestGetMissingIndices(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.CreateIndex("droids")
+
+	missing, err := GetMissingIndices(context.Background(), srv.Client(), []string{"ships", "droids", "jedi"})
+	if err != nil {
+		t.Fatalf("Unexpected error checking indices: %s", err)
+	}
+	if diff := cmp.Diff([]string{"ships", "jedi"}, missing); diff != "" {
+		t.Fatalf(diff)
+	}
+
+	srv.Fail("HEAD", "/droids", 500, "", "")
+	if _, err := GetMissingIndices(context.Background(), srv.Client(), []string{"droids"}); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("error should be an unavailable error, received : %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package searching
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultHighlight is the highlighting used by the GET /search route, of the fields its profile searches
+var DefaultHighlight = Highlight{
+	FragmentSize:      150,
+	NumberOfFragments: 3,
+	PreTags:           []string{"<em>"},
+	PostTags:          []string{"</em>"},
+}
+
+// Highlight represents the options for returning the fragments of each hit that matched the search term.
+// When Fields is empty, the fields searched are highlighted.
+type Highlight struct {
+	Fields            []string `json:"fields,omitempty"`
+	FragmentSize      int      `json:"fragmentSize,omitempty"`
+	NumberOfFragments int      `json:"numberOfFragments,omitempty"`
+	PreTags           []string `json:"preTags,omitempty"`
+	PostTags          []string `json:"postTags,omitempty"`
+}
+
+func (h *Highlight) validate() error {
+	if h == nil {
+		return nil
+	}
+	if h.FragmentSize < 0 {
+		return fmt.Errorf("highlight fragmentSize must be a positive number, got %d", h.FragmentSize)
+	}
+	if h.NumberOfFragments < 0 {
+		return fmt.Errorf("highlight numberOfFragments must be a positive number, got %d", h.NumberOfFragments)
+	}
+	if len(h.PreTags) != len(h.PostTags) {
+		return fmt.Errorf("highlight needs as many preTags as postTags, got %d and %d", len(h.PreTags), len(h.PostTags))
+	}
+	return nil
+}
+
+// highlightQuery adds the highlight section to the query. Fragments are HTML encoded so the tags are the
+// only markup in them.
+func highlightQuery(q *Query, s SearchRequest) {
+	h := s.Highlight
+	if h == nil {
+		return
+	}
+
+	names := h.Fields
+	if len(names) == 0 {
+		for _, f := range s.Fields {
+			// strip the boost, e.g. meta.description^2
+			names = append(names, strings.SplitN(f, "^", 2)[0])
+		}
+	}
+
+	fields := map[string]interface{}{}
+	for _, f := range names {
+		fields[f] = map[string]interface{}{}
+	}
+	if len(fields) == 0 {
+		fields["*"] = map[string]interface{}{}
+	}
+
+	highlight := map[string]interface{}{
+		"fields":  fields,
+		"encoder": "html",
+	}
+	if h.FragmentSize > 0 {
+		highlight["fragment_size"] = h.FragmentSize
+	}
+	if h.NumberOfFragments > 0 {
+		highlight["number_of_fragments"] = h.NumberOfFragments
+	}
+	if len(h.PreTags) > 0 {
+		highlight["pre_tags"] = h.PreTags
+		highlight["post_tags"] = h.PostTags
+	}
+
+	q.Highlight = highlight
+}
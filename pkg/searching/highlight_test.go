@@ -0,0 +1,72 @@
+package searching
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHighlightQuery(t *testing.T) {
+	tests := map[string]struct {
+		req       SearchRequest
+		highlight string
+	}{
+		"no highlight": {
+			req:       SearchRequest{Fields: []string{"title"}},
+			highlight: `null`,
+		},
+		"searched fields": {
+			req:       SearchRequest{Fields: []string{"meta.description^2", "meta.title"}, Highlight: &Highlight{}},
+			highlight: `{"encoder":"html","fields":{"meta.description":{},"meta.title":{}}}`,
+		},
+		"all fields": {
+			req:       SearchRequest{Highlight: &Highlight{}},
+			highlight: `{"encoder":"html","fields":{"*":{}}}`,
+		},
+		"options": {
+			req: SearchRequest{
+				Fields:    []string{"meta.title"},
+				Highlight: &Highlight{Fields: []string{"source.p"}, FragmentSize: 50, NumberOfFragments: 2, PreTags: []string{"<b>"}, PostTags: []string{"</b>"}},
+			},
+			highlight: `{"encoder":"html","fields":{"source.p":{}},"fragment_size":50,"number_of_fragments":2,"post_tags":["\u003c/b\u003e"],"pre_tags":["\u003cb\u003e"]}`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := Query{}
+			highlightQuery(&q, tc.req)
+
+			got, err := json.Marshal(q.Highlight)
+			if err != nil {
+				t.Fatalf("Unexpected error marshalling highlight: %s", err)
+			}
+			if diff := cmp.Diff(tc.highlight, string(got)); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestValidateHighlight(t *testing.T) {
+	tests := map[string]struct {
+		highlight *Highlight
+		valid     bool
+	}{
+		"none":               {highlight: nil, valid: true},
+		"default":            {highlight: &DefaultHighlight, valid: true},
+		"negative size":      {highlight: &Highlight{FragmentSize: -1}, valid: false},
+		"negative fragments": {highlight: &Highlight{NumberOfFragments: -1}, valid: false},
+		"unbalanced tags":    {highlight: &Highlight{PreTags: []string{"<b>"}}, valid: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := SearchRequest{Highlight: tc.highlight}.Validate()
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+		})
+	}
+}
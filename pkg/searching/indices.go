@@ -0,0 +1,232 @@
+package searching
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrIndexNotAllowed is returned when a request names an index clients aren't allowed to search
+var ErrIndexNotAllowed = errors.New("index not allowed")
+
+// IndexMap restricts the indices clients can search, and resolves the logical names they search by to
+// the indices behind them. The zero IndexMap allows any index, searched by its own name.
+type IndexMap struct {
+	// Aliases maps logical names to the indices, aliases or patterns they search, e.g. docs: [docs-v2, docs-archive-*]
+	Aliases map[string][]string
+	// Allow holds the patterns of the indices clients can search by their own name, e.g. crawler-*
+	Allow []string
+	// Client holds the patterns of the names the client searching can search by, any name when empty
+	Client []string
+}
+
+// restricted reports whether only the configured names can be searched
+func (m IndexMap) restricted() bool {
+	return len(m.Aliases) > 0 || len(m.Allow) > 0
+}
+
+// Targets returns the indices, aliases or patterns the name searches. A pattern is allowed when it falls
+// within an allowed pattern, e.g. crawler-2020* within crawler-*. Errors are of kind ErrIndexNotAllowed.
+func (m IndexMap) Targets(name string) ([]string, error) {
+	if !m.clientAllows(name) {
+		return nil, &Error{Kind: ErrIndexNotAllowed, Err: fmt.Errorf("index %q can't be searched by this client", name)}
+	}
+	if t, ok := m.Aliases[name]; ok {
+		return t, nil
+	}
+	if !m.restricted() {
+		return []string{name}, nil
+	}
+	for _, pattern := range m.Allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			return []string{name}, nil
+		}
+	}
+	return nil, &Error{Kind: ErrIndexNotAllowed, Err: fmt.Errorf("index %q can't be searched", name)}
+}
+
+// CheckLogIndex checks that searches can be logged under the name, as their logIndex, by a name clients can
+// search by: one Targets allows, or an allowed pattern without its wildcards, e.g. crawler for crawler-*.
+// Analytics, clicks, pins and rules are by the index searches are logged under. Errors are of kind
+// ErrIndexNotAllowed.
+func (m IndexMap) CheckLogIndex(name string) error {
+	if len(m.Client) > 0 && !logMatch(m.Client, name) {
+		return &Error{Kind: ErrIndexNotAllowed, Err: fmt.Errorf("index %q can't be searched by this client", name)}
+	}
+	if _, ok := m.Aliases[name]; ok || !m.restricted() || logMatch(m.Allow, name) {
+		return nil
+	}
+	return &Error{Kind: ErrIndexNotAllowed, Err: fmt.Errorf("index %q can't be searched", name)}
+}
+
+// logMatch reports whether a name matching one of the patterns, or a pattern itself, is logged under name
+func logMatch(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok || logName(pattern) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAllows reports whether the client can search by the name
+func (m IndexMap) clientAllows(name string) bool {
+	if len(m.Client) == 0 {
+		return true
+	}
+	for _, pattern := range m.Client {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the search with the indices behind each of its names, to search instead of the names.
+// A restricted IndexMap doesn't allow searching every index, so the search must name at least one.
+func (m IndexMap) Resolve(s SearchRequest) (SearchRequest, error) {
+	names := s.indexNames()
+	if len(names) == 0 && (m.restricted() || len(m.Client) > 0) {
+		return s, &Error{Kind: ErrIndexNotAllowed, Err: fmt.Errorf("searches need an index")}
+	}
+
+	s.resolved = map[string][]string{}
+	for _, n := range names {
+		t, err := m.Targets(n)
+		if err != nil {
+			return s, err
+		}
+		s.resolved[n] = t
+	}
+	return s, nil
+}
+
+// ResolveSuggest returns the suggest request with the indices behind its index, to take suggestions from
+func (m IndexMap) ResolveSuggest(s SuggestRequest) (SuggestRequest, error) {
+	t, err := m.Targets(s.Index)
+	if err != nil {
+		return s, err
+	}
+	s.targets = t
+	return s, nil
+}
+
+// indexNames returns the names the search asks for: the comma separated Index, then Indices, without
+// duplicates
+func (s SearchRequest) indexNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, n := range append(strings.Split(s.Index, ","), s.Indices...) {
+		n = strings.TrimSpace(n)
+		if n != "" && !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// targets returns the indices to search: those the names resolved to, or the names themselves
+func (s SearchRequest) targets() []string {
+	if s.resolved == nil {
+		return s.indexNames()
+	}
+
+	var targets []string
+	for _, n := range s.indexNames() {
+		targets = append(targets, s.resolved[n]...)
+	}
+	return targets
+}
+
+// logIndex returns the name the search is logged under, so its analytics are found by the name clients
+// search by. Searches of several indices are logged under the first one, and wildcards are dropped, since
+// they can't be part of an index name.
+func (s SearchRequest) logIndex() string {
+	names := s.indexNames()
+	if len(names) == 0 {
+		return "all"
+	}
+	return logName(names[0])
+}
+
+// logName returns the name searches of the index name or pattern are logged under
+func logName(n string) string {
+	if i := strings.IndexAny(n, "*?"); i >= 0 {
+		n = strings.TrimRight(n[:i], "-_.")
+	}
+	if n == "" {
+		return "all"
+	}
+	return n
+}
+
+func (s SearchRequest) validateIndices() error {
+	names := map[string]bool{}
+	for _, n := range s.indexNames() {
+		names[n] = true
+	}
+	for _, n := range s.Indices {
+		if strings.Contains(n, ",") {
+			return fmt.Errorf("indices must be given one at a time, got %q", n)
+		}
+	}
+	for n, b := range s.IndicesBoost {
+		if !names[n] {
+			return fmt.Errorf("boosted index %q isn't searched", n)
+		}
+		if b <= 0 {
+			return fmt.Errorf("boost of index %q must be positive, got %g", n, b)
+		}
+	}
+	return nil
+}
+
+// boostQuery sets the indices_boost of the query. Boosts are given by name, and apply to every index
+// behind the name.
+func boostQuery(q *Query, s SearchRequest) {
+	for _, n := range s.indexNames() {
+		b, ok := s.IndicesBoost[n]
+		if !ok {
+			continue
+		}
+		targets := []string{n}
+		if s.resolved != nil {
+			targets = s.resolved[n]
+		}
+		for _, t := range targets {
+			q.IndicesBoost = append(q.IndicesBoost, map[string]float64{t: b})
+		}
+	}
+}
+
+// labelHits sets the name each hit was searched by, when it differs from the index of the hit
+func labelHits(r *Results, s SearchRequest) {
+	names := s.indexNames()
+	for i, h := range r.Hits.Results {
+		if n := searchedAs(h.Index, names, s); n != h.Index {
+			r.Hits.Results[i].SearchedAs = n
+		}
+	}
+}
+
+// searchedAs returns the name of the search that the index was searched by. Indices behind an
+// Elasticsearch alias can't be told apart, so they are only labeled when a single name was searched.
+func searchedAs(index string, names []string, s SearchRequest) string {
+	for _, n := range names {
+		targets := []string{n}
+		if s.resolved != nil {
+			targets = s.resolved[n]
+		}
+		for _, t := range targets {
+			if ok, _ := path.Match(t, index); ok {
+				return n
+			}
+		}
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return ""
+}
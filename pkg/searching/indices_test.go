@@ -0,0 +1,192 @@
+package searching
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wambozi/elastic-search-api/m/pkg/clients"
+)
+
+func TestIndexMapResolve(t *testing.T) {
+	m := IndexMap{
+		Aliases: map[string][]string{"docs": {"docs-v2", "docs-archive-*"}},
+		Allow:   []string{"crawler-*"},
+	}
+	client := m
+	client.Client = []string{"docs", "crawler-2020*"}
+
+	tests := map[string]struct {
+		indexMap IndexMap
+		search   SearchRequest
+		targets  []string
+		kind     error
+	}{
+		"unrestricted":     {search: SearchRequest{Index: "droids,jedi"}, targets: []string{"droids", "jedi"}},
+		"unrestricted all": {search: SearchRequest{}, targets: nil},
+		"alias":            {indexMap: m, search: SearchRequest{Index: "docs"}, targets: []string{"docs-v2", "docs-archive-*"}},
+		"allowed":          {indexMap: m, search: SearchRequest{Indices: []string{"docs", "crawler-2020"}}, targets: []string{"docs-v2", "docs-archive-*", "crawler-2020"}},
+		"allowed pattern":  {indexMap: m, search: SearchRequest{Index: "crawler-2020*"}, targets: []string{"crawler-2020*"}},
+		"duplicates":       {indexMap: m, search: SearchRequest{Index: "docs", Indices: []string{"docs"}}, targets: []string{"docs-v2", "docs-archive-*"}},
+		"not allowed":      {indexMap: m, search: SearchRequest{Index: "droids"}, kind: ErrIndexNotAllowed},
+		"wider pattern":    {indexMap: m, search: SearchRequest{Index: "*"}, kind: ErrIndexNotAllowed},
+		"all":              {indexMap: m, search: SearchRequest{}, kind: ErrIndexNotAllowed},
+		"client alias":     {indexMap: client, search: SearchRequest{Indices: []string{"docs", "crawler-2020-06"}}, targets: []string{"docs-v2", "docs-archive-*", "crawler-2020-06"}},
+		"client excluded":  {indexMap: client, search: SearchRequest{Index: "crawler-2021"}, kind: ErrIndexNotAllowed},
+		"client all":       {indexMap: IndexMap{Client: []string{"docs"}}, search: SearchRequest{}, kind: ErrIndexNotAllowed},
+		"client open":      {indexMap: IndexMap{Client: []string{"droids"}}, search: SearchRequest{Index: "droids"}, targets: []string{"droids"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := tc.indexMap.Resolve(tc.search)
+			if tc.kind != nil {
+				if !errors.Is(err, tc.kind) {
+					t.Fatalf("error - expected : %s, received : %v", tc.kind, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error resolving indices: %s", err)
+			}
+			if diff := cmp.Diff(tc.targets, s.targets()); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestCheckLogIndex(t *testing.T) {
+	m := IndexMap{
+		Aliases: map[string][]string{"docs": {"docs-v2", "docs-archive-*"}},
+		Allow:   []string{"crawler-*"},
+	}
+	client := m
+	client.Client = []string{"docs", "crawler-2020*"}
+
+	tests := map[string]struct {
+		indexMap IndexMap
+		name     string
+		allowed  bool
+	}{
+		"unrestricted":    {name: "droids", allowed: true},
+		"alias":           {indexMap: m, name: "docs", allowed: true},
+		"allowed index":   {indexMap: m, name: "crawler-2020", allowed: true},
+		"allowed pattern": {indexMap: m, name: "crawler", allowed: true},
+		"alias target":    {indexMap: m, name: "docs-v2"},
+		"not allowed":     {indexMap: m, name: "droids"},
+		"all":             {indexMap: m, name: "all"},
+		"client pattern":  {indexMap: client, name: "crawler-2020", allowed: true},
+		"client wider":    {indexMap: client, name: "crawler"},
+		"client excluded": {indexMap: client, name: "crawler-2021"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.indexMap.CheckLogIndex(tc.name)
+			if tc.allowed && err != nil {
+				t.Fatalf("Unexpected error checking the log index: %s", err)
+			}
+			if !tc.allowed && !errors.Is(err, ErrIndexNotAllowed) {
+				t.Fatalf("error - expected : %s, received : %v", ErrIndexNotAllowed, err)
+			}
+		})
+	}
+}
+
+func TestBoostQuery(t *testing.T) {
+	m := IndexMap{Aliases: map[string][]string{"docs": {"docs-v2", "docs-archive-*"}}, Allow: []string{"*"}}
+	s, err := m.Resolve(SearchRequest{
+		Indices:      []string{"blog", "docs"},
+		IndicesBoost: map[string]float64{"docs": 2, "blog": 0.5},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error resolving indices: %s", err)
+	}
+
+	actual := buildQuery(s, nil).IndicesBoost
+	expected := []map[string]float64{{"blog": 0.5}, {"docs-v2": 2}, {"docs-archive-*": 2}}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestValidateIndices(t *testing.T) {
+	tests := map[string]struct {
+		search SearchRequest
+		err    string
+	}{
+		"valid":          {search: SearchRequest{Indices: []string{"docs", "blog"}, IndicesBoost: map[string]float64{"docs": 2}}},
+		"comma":          {search: SearchRequest{Indices: []string{"docs,blog"}}, err: `indices must be given one at a time, got "docs,blog"`},
+		"not searched":   {search: SearchRequest{Index: "docs", IndicesBoost: map[string]float64{"blog": 2}}, err: `boosted index "blog" isn't searched`},
+		"negative boost": {search: SearchRequest{Index: "docs", IndicesBoost: map[string]float64{"docs": -1}}, err: `boost of index "docs" must be positive, got -1`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.search.validateIndices()
+			actual := ""
+			if err != nil {
+				actual = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, actual); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestLogIndex(t *testing.T) {
+	tests := map[string]struct {
+		search   SearchRequest
+		expected string
+	}{
+		"index":    {search: SearchRequest{Index: "docs"}, expected: "docs"},
+		"first":    {search: SearchRequest{Index: "docs,blog"}, expected: "docs"},
+		"indices":  {search: SearchRequest{Indices: []string{"blog", "docs"}}, expected: "blog"},
+		"wildcard": {search: SearchRequest{Index: "crawler-*"}, expected: "crawler"},
+		"all":      {search: SearchRequest{Index: "*"}, expected: "all"},
+		"none":     {search: SearchRequest{}, expected: "all"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if actual := tc.search.logIndex(); actual != tc.expected {
+				t.Fatalf("log index - expected : %s, received : %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMemoryMultiIndex(t *testing.T) {
+	m := newDroids(t)
+	if _, errs := m.IndexDocument(clients.Document{Index: "ships", DocumentID: "1", Body: strings.NewReader(`{"meta":{"title":"Millennium Falcon"},"species":"Robot brain"}`)}); len(errs) > 0 {
+		t.Fatalf("Unexpected error indexing document: %s", errs[0])
+	}
+	req, _ := http.NewRequest("GET", "/search", nil)
+
+	s, err := IndexMap{Aliases: map[string][]string{"vehicles": {"sh*"}}, Allow: []string{"droids"}}.Resolve(SearchRequest{
+		SearchTerm:   "robot",
+		Indices:      []string{"droids", "vehicles"},
+		IndicesBoost: map[string]float64{"vehicles": 2},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error resolving indices: %s", err)
+	}
+	res, err := m.Search(req, s)
+	if err != nil {
+		t.Fatalf("Unexpected error searching: %s", err)
+	}
+
+	type hit struct{ Index, ID, SearchedAs string }
+	var actual []hit
+	for _, h := range res.Hits.Results {
+		actual = append(actual, hit{h.Index, h.ID, h.SearchedAs})
+	}
+	expected := []hit{{"ships", "1", "vehicles"}, {"droids", "1", ""}, {"droids", "2", ""}, {"droids", "3", ""}}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Fatalf(diff)
+	}
+}
@@ -0,0 +1,75 @@
+package searching
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// listPageSize is how many pins or rules are fetched per request when listing those of an index
+var listPageSize = 1000
+
+// listAll passes the source of each document of the store, e.g. PinsIndex, that belongs to the index to add,
+// in the order of the field, which must be unique within the index. Documents are fetched a page at a time,
+// so none are left out however many the index has. A missing store has none.
+func listAll(ctx context.Context, es *elasticsearch.Client, store string, index string, field string, add func(source json.RawMessage) error) error {
+	var after []interface{}
+	for {
+		var (
+			buf bytes.Buffer
+			r   struct {
+				Hits struct {
+					Hits []struct {
+						Source json.RawMessage `json:"_source"`
+						Sort   []interface{}   `json:"sort"`
+					} `json:"hits"`
+				} `json:"hits"`
+			}
+		)
+
+		query := map[string]interface{}{
+			"size":  listPageSize,
+			"query": map[string]interface{}{"term": map[string]interface{}{"index": index}},
+			"sort":  []interface{}{map[string]string{field: "asc"}},
+		}
+		if after != nil {
+			query["search_after"] = after
+		}
+		if err := json.NewEncoder(&buf).Encode(query); err != nil {
+			return err
+		}
+
+		res, err := es.Search(
+			es.Search.WithContext(ctx),
+			es.Search.WithIndex(store),
+			es.Search.WithBody(&buf),
+			es.Search.WithIgnoreUnavailable(true),
+		)
+		if err != nil {
+			return requestError(ctx, err)
+		}
+		if res.IsError() {
+			err = responseError(res, fmt.Sprintf("Error listing the %s of %s", store, index))
+		} else if derr := json.NewDecoder(res.Body).Decode(&r); derr != nil {
+			err = parseError(derr)
+		}
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		hits := r.Hits.Hits
+		for _, h := range hits {
+			if err := add(h.Source); err != nil {
+				return parseError(err)
+			}
+		}
+		if len(hits) < listPageSize || len(hits[len(hits)-1].Sort) == 0 {
+			return nil
+		}
+		after = hits[len(hits)-1].Sort
+	}
+}
@@ -0,0 +1,630 @@
+package searching
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wambozi/elastic-search-api/m/pkg/clients"
+)
+
+// Memory is a Searcher and Indexer keeping documents in memory, to run the API without Elasticsearch, e.g.
+// in tests. A document matches a search when the searched fields, or all of its fields when none are
+// given, contain every word of the search term. Hits score 1, or the boost of their index, and are ordered
+// by score, index and ID, after any pinned documents. Query rules rewrite the search term as they do in
+// Elasticsearch. Filters, facets, sorts, profile scoring, highlighting, spelling corrections and the clauses
+// of advanced searches other than their free words are ignored.
+type Memory struct {
+	// Err, when set, is returned by every call instead of its result
+	Err error
+
+	mu       sync.RWMutex
+	indices  map[string][]memoryDocument
+	searches []memorySearch
+	pins     map[string]Pin
+	rules    map[string]Rule
+}
+
+type memoryDocument struct {
+	id      string
+	version int
+	source  json.RawMessage
+}
+
+// memorySearch is a logged search, along with its date for analytics
+type memorySearch struct {
+	date  time.Time
+	query IndexQuery
+}
+
+// NewMemory returns an empty Memory
+func NewMemory() *Memory {
+	return &Memory{indices: map[string][]memoryDocument{}, pins: map[string]Pin{}, rules: map[string]Rule{}}
+}
+
+// IndexDocument adds the document to its index, creating the index if needed, or replaces the document
+// with the same ID
+func (m *Memory) IndexDocument(d clients.Document) (resSlice []string, errSlice []error) {
+	if m.Err != nil {
+		return []string{}, []error{m.Err}
+	}
+
+	b, err := ioutil.ReadAll(d.Body)
+	if err == nil && !json.Valid(b) {
+		err = fmt.Errorf("document ID=%s is not valid JSON", d.DocumentID)
+	}
+	if err != nil {
+		return []string{}, []error{fmt.Errorf("[400 Bad Request] Error indexing document ID=%s, err=%s", d.DocumentID, err)}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	docs := m.indices[d.Index]
+	for i, doc := range docs {
+		if doc.id == d.DocumentID {
+			docs[i] = memoryDocument{id: doc.id, version: doc.version + 1, source: b}
+			return []string{fmt.Sprintf("[200 OK] updated; version=%d; id=%s", doc.version+1, d.DocumentID)}, []error{}
+		}
+	}
+
+	docs = append(docs, memoryDocument{id: d.DocumentID, version: 1, source: b})
+	sort.Slice(docs, func(i, j int) bool { return docs[i].id < docs[j].id })
+	m.indices[d.Index] = docs
+	return []string{fmt.Sprintf("[201 Created] created; version=1; id=%s", d.DocumentID)}, []error{}
+}
+
+// documents returns the documents of the indices matching the targets, by index, in the order of their
+// names. A target naming an index that doesn't exist is an ErrIndexNotFound error, while a pattern can
+// match none.
+func (m *Memory) documents(targets []string) (map[string][]memoryDocument, []string, error) {
+	found := map[string][]memoryDocument{}
+	for _, t := range targets {
+		if !strings.ContainsAny(t, "*?") {
+			docs, ok := m.indices[t]
+			if !ok {
+				return nil, nil, &Error{Kind: ErrIndexNotFound, Err: fmt.Errorf("no such index [%s]", t)}
+			}
+			found[t] = docs
+			continue
+		}
+		for index, docs := range m.indices {
+			if ok, _ := path.Match(t, index); ok {
+				found[index] = docs
+			}
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for index := range found {
+		names = append(names, index)
+	}
+	sort.Strings(names)
+	return found, names, nil
+}
+
+// Search returns the documents of the indices matching the search term, a page at a time. Index boosts
+// set the score of the hits, which are ordered by score, then index and ID.
+func (m *Memory) Search(r *http.Request, s SearchRequest) (*Results, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	c, err := decodeCursor(s.Cursor)
+	if err != nil {
+		return nil, invalid(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found, indices, err := m.documents(s.targets())
+	if err != nil {
+		return nil, err
+	}
+
+	pins := m.pins[pinID(s.logIndex(), s.SearchTerm)].IDs
+	// the search is logged by the term it was given
+	iq := newSearchQuery(r, s)
+	s = rewrite(s, m.indexRules(s.logIndex()))
+	if s.redirect != "" {
+		// redirected searches find nothing
+		indices = nil
+	}
+	var matches []Hit
+	for _, index := range indices {
+		for _, doc := range found[index] {
+			score, pin := memoryBoost(index, s), memoryPinned(pins, doc.id)
+			if pin >= 0 {
+				score = pinBoost * float64(len(pins)-pin)
+			} else if !memoryMatchAny(doc.source, s) {
+				continue
+			}
+			matches = append(matches, Hit{
+				Index:  index,
+				Type:   "_doc",
+				ID:     doc.id,
+				Score:  score,
+				Source: doc.source,
+				Sort:   []interface{}{json.Number(fmt.Sprint(score)), index + "/" + doc.id},
+				Pinned: pin >= 0,
+			})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+
+	res := &Results{}
+	res.Hits.Total.Value = len(matches)
+	res.Hits.Total.Relation = "eq"
+	res.Hits.Results = memoryPage(matches, s, c)
+	if len(matches) > 0 {
+		res.Hits.MaxScore = matches[0].Score
+	}
+	paginateResults(res, s, c)
+	labelHits(res, s)
+	if err := projectResults(res); err != nil {
+		return nil, &Error{Kind: ErrParse, Err: err}
+	}
+
+	rewriteResults(res, s)
+
+	iq.Hits = &res.Hits.Total.Value
+	iq.Took = &res.Took
+	iq.RewrittenQuery, iq.Redirect = res.RewrittenQuery, res.Redirect
+	res.SearchID, res.LogIndex = iq.SearchID, iq.Index
+	m.searches = append(m.searches, memorySearch{date: time.Now().UTC(), query: iq})
+
+	return res, nil
+}
+
+// memoryMatchAny reports whether the document matches the search term, or one of its expansions
+func memoryMatchAny(source json.RawMessage, s SearchRequest) bool {
+	terms := s.expansions
+	if len(terms) == 0 {
+		terms = []string{s.SearchTerm}
+	}
+	for _, t := range terms {
+		if memoryMatch(source, s.fields(), t) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexRules returns the rules of the index, sorted by name
+func (m *Memory) indexRules(index string) []Rule {
+	rules := []Rule{}
+	for _, r := range m.rules {
+		if r.Index == index {
+			rules = append(rules, r)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// memoryPinned returns the position of the document among the pinned ones, or -1
+func memoryPinned(pins []string, id string) int {
+	for i, p := range pins {
+		if p == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// memoryBoost returns the boost of the first name of the search the index is searched by, or 1
+func memoryBoost(index string, s SearchRequest) float64 {
+	if n := searchedAs(index, s.indexNames(), s); n != "" {
+		if b, ok := s.IndicesBoost[n]; ok {
+			return b
+		}
+	}
+	return 1
+}
+
+// memoryPage returns the page of the hits the request asks for. Like search_after, a cursor continues from
+// the hit it was made from, and a reverse cursor's page is returned in reverse for paginateResults to restore.
+func memoryPage(hits []Hit, s SearchRequest, c *cursor) []Hit {
+	size := pageSize(s)
+	if c == nil {
+		start := from(s)
+		if start > len(hits) {
+			return []Hit{}
+		}
+		end := start + size
+		if end > len(hits) {
+			end = len(hits)
+		}
+		return hits[start:end]
+	}
+
+	page := []Hit{}
+	if c.Reverse {
+		for i := len(hits) - 1; i >= 0 && len(page) < size; i-- {
+			if memoryBefore(hits[i].Sort, c.After) {
+				page = append(page, hits[i])
+			}
+		}
+		return page
+	}
+	for _, h := range hits {
+		if memoryBefore(c.After, h.Sort) && len(page) < size {
+			page = append(page, h)
+		}
+	}
+	return page
+}
+
+// memoryBefore reports whether the hit sorted by a comes before the one sorted by b: by score, highest
+// first, then by index and ID
+func memoryBefore(a, b []interface{}) bool {
+	as, _ := strconv.ParseFloat(fmt.Sprint(a[0]), 64)
+	bs, _ := strconv.ParseFloat(fmt.Sprint(b[0]), 64)
+	if as != bs {
+		return as > bs
+	}
+	return fmt.Sprint(a[len(a)-1]) < fmt.Sprint(b[len(b)-1])
+}
+
+// memoryMatch reports whether the fields of the document contain every word of the term
+func memoryMatch(source json.RawMessage, fields []string, term string) bool {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(source, &doc); err != nil {
+		return false
+	}
+
+	var text []string
+	if len(fields) == 0 {
+		text = memoryText(doc, text)
+	}
+	for _, f := range fields {
+		f = strings.SplitN(f, "^", 2)[0]
+		if strings.Contains(f, "*") {
+			text = memoryText(doc, text)
+			continue
+		}
+		text = memoryText(sourceValue(doc, f), text)
+	}
+
+	all := strings.ToLower(strings.Join(text, " "))
+	for _, w := range strings.Fields(strings.ToLower(term)) {
+		if !strings.Contains(all, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryText appends the strings in a value of a document to text
+func memoryText(v interface{}, text []string) []string {
+	switch v := v.(type) {
+	case string:
+		text = append(text, v)
+	case []interface{}:
+		for _, e := range v {
+			text = memoryText(e, text)
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			text = memoryText(e, text)
+		}
+	}
+	return text
+}
+
+// Suggest returns the values of the suggest fields that have a word starting with the prefix
+func (m *Memory) Suggest(r *http.Request, s SuggestRequest) (*Suggestions, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	found, indices, err := m.documents(s.indices())
+	if err != nil {
+		return nil, err
+	}
+	var docs []memoryDocument
+	for _, index := range indices {
+		docs = append(docs, found[index]...)
+	}
+
+	var sr suggestResults
+	prefix := strings.ToLower(strings.TrimSpace(s.Prefix))
+	for _, doc := range docs {
+		var source map[string]interface{}
+		if err := json.Unmarshal(doc.source, &source); err != nil {
+			continue
+		}
+		for _, f := range s.fields() {
+			text, _ := sourceValue(source, f).(string)
+			if memoryPrefix(text, prefix) && len(sr.Hits.Results) < s.size() {
+				h := struct {
+					Source map[string]interface{} `json:"_source"`
+				}{Source: source}
+				sr.Hits.Results = append(sr.Hits.Results, h)
+				break
+			}
+		}
+	}
+
+	return suggestions(sr, s), nil
+}
+
+// memoryPrefix reports whether a word of the text, or the text itself, starts with the lowercased prefix
+func memoryPrefix(text string, prefix string) bool {
+	text = strings.ToLower(text)
+	if strings.HasPrefix(text, prefix) {
+		return true
+	}
+	for _, w := range strings.Fields(text) {
+		if strings.HasPrefix(w, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// window returns the searches of the index logged in [from, to)
+func (m *Memory) window(index string, from time.Time, to time.Time) []IndexQuery {
+	var queries []IndexQuery
+	for _, s := range m.searches {
+		if s.query.Index == index && !s.date.Before(from) && s.date.Before(to) {
+			queries = append(queries, s.query)
+		}
+	}
+	return queries
+}
+
+// TopQueries returns the most searched terms of the searches made on the Memory
+func (m *Memory) TopQueries(ctx context.Context, a AnalyticsRequest) (*TopQueries, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	to := a.to()
+	top := &TopQueries{From: to.Add(-a.Window), To: to}
+	counts, zero, zeroClicks := map[string]int{}, map[string]int{}, map[string]int{}
+	clicked := 0
+	for _, q := range m.window(a.Index, top.From, to) {
+		top.Total++
+		counts[q.Query]++
+		switch {
+		case q.Hits != nil && *q.Hits == 0:
+			zero[q.Query]++
+		case q.Clicks == 0:
+			zeroClicks[q.Query]++
+		}
+		if q.Clicks > 0 {
+			clicked++
+		}
+	}
+
+	top.Queries = memoryCounts(counts, a.size())
+	top.ZeroResults = memoryCounts(zero, a.size())
+	top.ZeroClicks = memoryCounts(zeroClicks, a.size())
+	if top.Total > 0 {
+		top.ClickThroughRate = float64(clicked) / float64(top.Total)
+	}
+	return top, nil
+}
+
+// memoryCounts returns the size most counted terms, the most counted first
+func memoryCounts(counts map[string]int, size int) []QueryCount {
+	qc := []QueryCount{}
+	for q, c := range counts {
+		qc = append(qc, QueryCount{Query: q, Count: c})
+	}
+	sort.Slice(qc, func(i, j int) bool {
+		if qc[i].Count != qc[j].Count {
+			return qc[i].Count > qc[j].Count
+		}
+		return qc[i].Query < qc[j].Query
+	})
+	if len(qc) > size {
+		qc = qc[:size]
+	}
+	return qc
+}
+
+// TrendingQueries returns the terms searched more in the window than in the window before it
+func (m *Memory) TrendingQueries(ctx context.Context, a AnalyticsRequest) (*TrendingQueries, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	to := a.to()
+	t := &TrendingQueries{From: to.Add(-a.Window), To: to, PreviousFrom: to.Add(-2 * a.Window)}
+	current, previous := map[string]int{}, map[string]int{}
+	for _, q := range m.window(a.Index, t.From, to) {
+		current[q.Query]++
+	}
+	for _, q := range m.window(a.Index, t.PreviousFrom, t.From) {
+		previous[q.Query]++
+	}
+
+	queries := []TrendingQuery{}
+	for q, c := range current {
+		if c > previous[q] {
+			queries = append(queries, TrendingQuery{
+				Query:         q,
+				Count:         c,
+				PreviousCount: previous[q],
+				Growth:        float64(c+1) / float64(previous[q]+1),
+			})
+		}
+	}
+	t.Queries = rankTrending(queries, a.size())
+	return t, nil
+}
+
+// RecordClick counts a click on a search made on the Memory
+func (m *Memory) RecordClick(ctx context.Context, c Click) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, s := range m.searches {
+		if s.query.SearchID == c.SearchID && s.query.Index == c.Index {
+			m.searches[i].query.Clicks++
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no search ID=%s in %s", ErrSearchNotFound, c.SearchID, queriesIndex(c.Index))
+}
+
+// Pins returns the pins of the index
+func (m *Memory) Pins(ctx context.Context, index string) (*Pins, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pins := &Pins{Pins: []Pin{}}
+	for _, p := range m.pins {
+		if p.Index == index {
+			pins.Pins = append(pins.Pins, p)
+		}
+	}
+	sort.Slice(pins.Pins, func(i, j int) bool { return pins.Pins[i].Query < pins.Pins[j].Query })
+	return pins, nil
+}
+
+// Pin pins the documents to the query, replacing those pinned before
+func (m *Memory) Pin(ctx context.Context, p Pin) (*Pin, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	p.Query = normalizeQuery(p.Query)
+	p.Updated = time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pins[pinID(p.Index, p.Query)] = p
+	return &p, nil
+}
+
+// Unpin unpins the documents pinned to the query
+func (m *Memory) Unpin(ctx context.Context, index string, query string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := pinID(index, query)
+	if _, ok := m.pins[id]; !ok {
+		return fmt.Errorf("%w: no documents pinned to %q in %s", ErrPinNotFound, normalizeQuery(query), index)
+	}
+	delete(m.pins, id)
+	return nil
+}
+
+// Rules returns the query rules of the index
+func (m *Memory) Rules(ctx context.Context, index string) (*Rules, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return &Rules{Rules: m.indexRules(index)}, nil
+}
+
+// PutRule stores the query rule, replacing the rule of the same name
+func (m *Memory) PutRule(ctx context.Context, r Rule) (*Rule, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	r.Updated = time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules[ruleID(r.Index, r.Name)] = r
+	return &r, nil
+}
+
+// DeleteRule deletes the query rule of the index
+func (m *Memory) DeleteRule(ctx context.Context, index string, name string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := ruleID(index, name)
+	if _, ok := m.rules[id]; !ok {
+		return fmt.Errorf("%w: no rule %q in %s", ErrRuleNotFound, name, index)
+	}
+	delete(m.rules, id)
+	return nil
+}
+
+// ClusterHealth returns a green health, there being no cluster to be unhealthy
+func (m *Memory) ClusterHealth(ctx context.Context) (*ClusterHealth, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &ClusterHealth{Status: "green", NumberOfNodes: 1, ActiveShardsPercent: 100}, nil
+}
+
+// MissingIndices returns those of the indices no document was indexed to
+func (m *Memory) MissingIndices(ctx context.Context, names []string) ([]string, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	missing := []string{}
+	for _, name := range names {
+		if _, ok := m.indices[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
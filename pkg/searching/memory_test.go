@@ -0,0 +1,175 @@
+package searching
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wambozi/elastic-search-api/m/pkg/clients"
+)
+
+func newDroids(t *testing.T) *Memory {
+	m := NewMemory()
+	for id, doc := range map[string]string{
+		"1": `{"meta":{"title":"R2-D2"},"species":"Robot"}`,
+		"2": `{"meta":{"title":"C-3PO"},"species":"Robot"}`,
+		"3": `{"meta":{"title":"BB-8"},"species":"Robot"}`,
+		"4": `{"meta":{"title":"Chewbacca"},"species":"Wookiee"}`,
+	} {
+		if _, errs := m.IndexDocument(clients.Document{Index: "droids", DocumentID: id, Body: strings.NewReader(doc)}); len(errs) > 0 {
+			t.Fatalf("Unexpected error indexing document %s: %s", id, errs[0])
+		}
+	}
+	return m
+}
+
+func hitIDs(r *Results) []string {
+	ids := []string{}
+	for _, h := range r.Hits.Results {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+func TestMemorySearch(t *testing.T) {
+	m := newDroids(t)
+	req, _ := http.NewRequest("GET", "/search", nil)
+
+	tests := map[string]struct {
+		search SearchRequest
+		ids    []string
+		total  int
+		kind   error
+	}{
+		"term":            {search: SearchRequest{Index: "droids", SearchTerm: "robot"}, ids: []string{"1", "2", "3"}, total: 3},
+		"fields":          {search: SearchRequest{Index: "droids", SearchTerm: "robot", Fields: []string{"meta.title^2"}}, ids: []string{}, total: 0},
+		"page":            {search: SearchRequest{Index: "droids", SearchTerm: "robot", Page: 2, Size: 2}, ids: []string{"3"}, total: 3},
+		"index not found": {search: SearchRequest{Index: "jedi", SearchTerm: "yoda"}, kind: ErrIndexNotFound},
+		"invalid":         {search: SearchRequest{Index: "droids", SearchTerm: "robot", Size: MaxPageSize + 1}, kind: ErrBadRequest},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			res, err := m.Search(req, tc.search)
+			if tc.kind != nil {
+				if !errors.Is(err, tc.kind) {
+					t.Fatalf("error - expected : %s, received : %v", tc.kind, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error searching: %s", err)
+			}
+			if diff := cmp.Diff(tc.ids, hitIDs(res)); diff != "" {
+				t.Fatalf(diff)
+			}
+			if res.Hits.Total.Value != tc.total {
+				t.Fatalf("total - expected : %d, received : %d", tc.total, res.Hits.Total.Value)
+			}
+		})
+	}
+}
+
+func TestMemoryCursors(t *testing.T) {
+	m := newDroids(t)
+	req, _ := http.NewRequest("GET", "/search", nil)
+	s := SearchRequest{Index: "droids", SearchTerm: "robot", Size: 2}
+
+	first, err := m.Search(req, s)
+	if err != nil {
+		t.Fatalf("Unexpected error searching: %s", err)
+	}
+	s.Cursor = first.Pagination.Next
+	second, err := m.Search(req, s)
+	if err != nil {
+		t.Fatalf("Unexpected error searching: %s", err)
+	}
+	if diff := cmp.Diff([]string{"3"}, hitIDs(second)); diff != "" {
+		t.Fatalf(diff)
+	}
+
+	s.Cursor = second.Pagination.Previous
+	back, err := m.Search(req, s)
+	if err != nil {
+		t.Fatalf("Unexpected error searching: %s", err)
+	}
+	if diff := cmp.Diff([]string{"1", "2"}, hitIDs(back)); diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestMemorySuggest(t *testing.T) {
+	m := newDroids(t)
+	req, _ := http.NewRequest("GET", "/suggest", nil)
+
+	res, err := m.Suggest(req, SuggestRequest{Index: "droids", Prefix: "c", Highlight: true})
+	if err != nil {
+		t.Fatalf("Unexpected error suggesting: %s", err)
+	}
+	want := []Suggestion{{Text: "C-3PO", Highlighted: "<em>C</em>-3PO"}, {Text: "Chewbacca", Highlighted: "<em>C</em>hewbacca"}}
+	if diff := cmp.Diff(want, res.Suggestions); diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestMemoryAnalytics(t *testing.T) {
+	m := newDroids(t)
+	req, _ := http.NewRequest("GET", "/search", nil)
+	ctx := context.Background()
+
+	var searchIDs []string
+	for _, term := range []string{"robot", "robot", "yoda", "robot"} {
+		res, err := m.Search(req, SearchRequest{Index: "droids", SearchTerm: term})
+		if err != nil {
+			t.Fatalf("Unexpected error searching: %s", err)
+		}
+		searchIDs = append(searchIDs, res.SearchID)
+	}
+
+	if err := m.RecordClick(ctx, Click{SearchID: searchIDs[3], Index: "droids", DocumentID: "1"}); err != nil {
+		t.Fatalf("Unexpected error recording click: %s", err)
+	}
+	if err := m.RecordClick(ctx, Click{SearchID: "unknown", Index: "droids", DocumentID: "1"}); !errors.Is(err, ErrSearchNotFound) {
+		t.Fatalf("error - expected : %s, received : %v", ErrSearchNotFound, err)
+	}
+
+	a := AnalyticsRequest{Index: "droids", Window: time.Hour, To: time.Now().Add(time.Minute)}
+	top, err := m.TopQueries(ctx, a)
+	if err != nil {
+		t.Fatalf("Unexpected error getting top queries: %s", err)
+	}
+	if diff := cmp.Diff([]QueryCount{{Query: "robot", Count: 3}, {Query: "yoda", Count: 1}}, top.Queries); diff != "" {
+		t.Fatalf(diff)
+	}
+	if diff := cmp.Diff([]QueryCount{{Query: "yoda", Count: 1}}, top.ZeroResults); diff != "" {
+		t.Fatalf(diff)
+	}
+	if diff := cmp.Diff([]QueryCount{{Query: "robot", Count: 2}}, top.ZeroClicks); diff != "" {
+		t.Fatalf(diff)
+	}
+	if top.ClickThroughRate != 0.25 {
+		t.Fatalf("click-through rate - expected : 0.25, received : %v", top.ClickThroughRate)
+	}
+
+	trending, err := m.TrendingQueries(ctx, a)
+	if err != nil {
+		t.Fatalf("Unexpected error getting trending queries: %s", err)
+	}
+	if len(trending.Queries) != 2 || trending.Queries[0].Query != "robot" || trending.Queries[0].Growth != 4 {
+		t.Fatalf("unexpected trending queries : %+v", trending.Queries)
+	}
+}
+
+func TestMemoryErr(t *testing.T) {
+	m := newDroids(t)
+	m.Err = &Error{Kind: ErrUnavailable, Err: errors.New("connection refused")}
+	req, _ := http.NewRequest("GET", "/search", nil)
+
+	if _, err := m.Search(req, SearchRequest{Index: "droids", SearchTerm: "robot"}); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("error - expected : %s, received : %v", ErrUnavailable, err)
+	}
+}
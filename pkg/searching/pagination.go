@@ -0,0 +1,191 @@
+package searching
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+)
+
+const (
+	// DefaultPageSize is the number of hits returned when a request does not specify a size
+	DefaultPageSize = 10
+	// MaxPageSize is the largest number of hits a single request may ask for
+	MaxPageSize = 100
+	// maxResultWindow mirrors Elasticsearch's index.max_result_window default, past which from/size paging fails
+	maxResultWindow = 10000
+)
+
+// Tiebreakers holds, by index name or pattern, a keyword field with doc values that is unique per document.
+// It's sorted on last, so hits with equal sort values keep their order from one page to the next.
+type Tiebreakers map[string]string
+
+// Apply sets the tiebreaker of the search: the field of the indices it searches, when they all have the
+// same one. Searches of other indices have none, and are paged by page and size only: they get no cursors.
+func (t Tiebreakers) Apply(s SearchRequest) SearchRequest {
+	indices := s.indexNames()
+	if len(indices) == 0 {
+		indices = []string{"*"}
+	}
+
+	s.tiebreaker = ""
+	field := ""
+	for _, index := range indices {
+		// the field of the index itself wins over those of the patterns it falls within
+		fields := map[string]bool{}
+		if f, ok := t[index]; ok {
+			fields[f] = true
+		} else {
+			for pattern, f := range t {
+				if ok, _ := path.Match(pattern, index); ok {
+					fields[f] = true
+				}
+			}
+		}
+		if len(fields) != 1 {
+			return s
+		}
+		for f := range fields {
+			if field != "" && f != field {
+				return s
+			}
+			field = f
+		}
+	}
+	s.tiebreaker = field
+	return s
+}
+
+// Pagination represents the paging information returned alongside the hits
+type Pagination struct {
+	Page     int    `json:"page,omitempty"`
+	Size     int    `json:"size"`
+	Next     string `json:"next,omitempty"`
+	Previous string `json:"previous,omitempty"`
+}
+
+// cursor is the decoded form of the opaque cursor token handed to clients
+type cursor struct {
+	Reverse bool          `json:"r,omitempty"`
+	After   []interface{} `json:"a"`
+}
+
+func encodeCursor(c cursor) string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (c *cursor, err error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor: %s", err)
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("Invalid cursor: %s", err)
+	}
+	if len(c.After) == 0 {
+		return nil, fmt.Errorf("Invalid cursor: missing sort values")
+	}
+
+	return c, nil
+}
+
+func validatePagination(s SearchRequest) error {
+	if s.Page < 0 {
+		return fmt.Errorf("page must be a positive number, got %d", s.Page)
+	}
+	if s.Size < 0 || s.Size > MaxPageSize {
+		return fmt.Errorf("size must be between 1 and %d, got %d", MaxPageSize, s.Size)
+	}
+	if s.Cursor != "" {
+		_, err := decodeCursor(s.Cursor)
+		return err
+	}
+	if from(s)+pageSize(s) > maxResultWindow {
+		return fmt.Errorf("page %d is past the result window of %d hits, use a cursor instead", s.Page, maxResultWindow)
+	}
+
+	return nil
+}
+
+func pageSize(s SearchRequest) int {
+	if s.Size == 0 {
+		return DefaultPageSize
+	}
+	return s.Size
+}
+
+// pageNumber returns the 1-based page of a request that isn't using a cursor
+func pageNumber(s SearchRequest) int {
+	if s.Page == 0 {
+		return 1
+	}
+	return s.Page
+}
+
+func from(s SearchRequest) int {
+	if s.Page <= 1 {
+		return 0
+	}
+	return (s.Page - 1) * pageSize(s)
+}
+
+// paginateQuery sets from/size on the query, or search_after when a cursor is given. Every hit carries
+// the sort values of the sort, which end in the tiebreaker when there is one, to be turned into a cursor.
+func paginateQuery(q *Query, s SearchRequest, c *cursor) {
+	q.Size = pageSize(s)
+	q.Sort = sortClauses(s, c != nil && c.Reverse)
+	// hits sorted on fields are only scored when asked to
+	q.TrackScores = len(s.Sort) > 0
+
+	if c != nil {
+		q.SearchAfter = c.After
+		return
+	}
+	q.From = from(s)
+}
+
+// paginateResults restores the display order of a reversed page and builds the next/previous cursors from
+// the sort values of the last and first hits
+func paginateResults(r *Results, s SearchRequest, c *cursor) {
+	hits := r.Hits.Results
+	reverse := c != nil && c.Reverse
+	full := len(hits) == pageSize(s)
+
+	if reverse {
+		for i, j := 0, len(hits)-1; i < j; i, j = i+1, j-1 {
+			hits[i], hits[j] = hits[j], hits[i]
+		}
+	}
+
+	p := &Pagination{Size: pageSize(s)}
+	if c == nil {
+		p.Page = pageNumber(s)
+	}
+
+	// hits of template searches have no sort values to make cursors from
+	if len(hits) > 0 && len(hits[0].Sort) > 0 {
+		hasNext := full || reverse
+		hasPrevious := (c == nil && s.Page > 1) || (c != nil && !c.Reverse) || (reverse && full)
+
+		if hasNext {
+			p.Next = encodeCursor(cursor{After: hits[len(hits)-1].Sort})
+		}
+		if hasPrevious {
+			p.Previous = encodeCursor(cursor{Reverse: true, After: hits[0].Sort})
+		}
+	}
+
+	r.Pagination = p
+}
@@ -0,0 +1,192 @@
+package searching
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	want := cursor{Reverse: true, After: []interface{}{json.Number("1.2876821"), "1234"}}
+
+	got, err := decodeCursor(encodeCursor(want))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding cursor: %s", err)
+	}
+
+	diff := cmp.Diff(want, *got)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestValidatePagination(t *testing.T) {
+	tests := map[string]struct {
+		req   SearchRequest
+		valid bool
+	}{
+		"defaults":         {req: SearchRequest{}, valid: true},
+		"page and size":    {req: SearchRequest{Page: 3, Size: 25}, valid: true},
+		"negative page":    {req: SearchRequest{Page: -1}, valid: false},
+		"size too large":   {req: SearchRequest{Size: MaxPageSize + 1}, valid: false},
+		"past the window":  {req: SearchRequest{Page: 200, Size: 100}, valid: false},
+		"malformed cursor": {req: SearchRequest{Cursor: "not-a-cursor"}, valid: false},
+		"valid cursor":     {req: SearchRequest{Cursor: encodeCursor(cursor{After: []interface{}{"1"}})}, valid: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validatePagination(tc.req)
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestPaginateQuery(t *testing.T) {
+	type results struct {
+		From        int
+		Size        int
+		Sort        []map[string]interface{}
+		SearchAfter []interface{}
+	}
+
+	after := []interface{}{json.Number("2.5"), "abc"}
+
+	tests := map[string]struct {
+		req    SearchRequest
+		cursor *cursor
+		want   results
+	}{
+		"first page": {
+			req:  SearchRequest{},
+			want: results{From: 0, Size: DefaultPageSize, Sort: []map[string]interface{}{{"_score": "desc"}}},
+		},
+		"third page": {
+			req:  SearchRequest{Page: 3, Size: 20, tiebreaker: "id"},
+			want: results{From: 40, Size: 20, Sort: []map[string]interface{}{{"_score": "desc"}, {"id": "asc"}}},
+		},
+		"next cursor": {
+			req:    SearchRequest{Page: 3, Size: 20, tiebreaker: "id"},
+			cursor: &cursor{After: after},
+			want:   results{Size: 20, Sort: []map[string]interface{}{{"_score": "desc"}, {"id": "asc"}}, SearchAfter: after},
+		},
+		"previous cursor": {
+			req:    SearchRequest{Size: 20, tiebreaker: "id"},
+			cursor: &cursor{Reverse: true, After: after},
+			want:   results{Size: 20, Sort: []map[string]interface{}{{"_score": "asc"}, {"id": "desc"}}, SearchAfter: after},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := Query{}
+			paginateQuery(&q, tc.req, tc.cursor)
+
+			got := results{From: q.From, Size: q.Size, Sort: q.Sort, SearchAfter: q.SearchAfter}
+			diff := cmp.Diff(tc.want, got)
+			if diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestPaginateResults(t *testing.T) {
+	hits := func(ids ...string) *Results {
+		r := &Results{}
+		for _, id := range ids {
+			r.Hits.Results = append(r.Hits.Results, Hit{ID: id, Sort: []interface{}{id}})
+		}
+		return r
+	}
+	next := func(id string) string { return encodeCursor(cursor{After: []interface{}{id}}) }
+	prev := func(id string) string { return encodeCursor(cursor{Reverse: true, After: []interface{}{id}}) }
+
+	tests := map[string]struct {
+		req     SearchRequest
+		cursor  *cursor
+		results *Results
+		order   []string
+		want    Pagination
+	}{
+		"full first page": {
+			req:     SearchRequest{Size: 2},
+			results: hits("a", "b"),
+			order:   []string{"a", "b"},
+			want:    Pagination{Page: 1, Size: 2, Next: next("b")},
+		},
+		"last page": {
+			req:     SearchRequest{Page: 2, Size: 2},
+			results: hits("c"),
+			order:   []string{"c"},
+			want:    Pagination{Page: 2, Size: 2, Previous: prev("c")},
+		},
+		"after a cursor": {
+			req:     SearchRequest{Size: 2},
+			cursor:  &cursor{After: []interface{}{"b"}},
+			results: hits("c", "d"),
+			order:   []string{"c", "d"},
+			want:    Pagination{Size: 2, Next: next("d"), Previous: prev("c")},
+		},
+		"before a cursor": {
+			req:     SearchRequest{Size: 2},
+			cursor:  &cursor{Reverse: true, After: []interface{}{"c"}},
+			results: hits("b", "a"),
+			order:   []string{"a", "b"},
+			want:    Pagination{Size: 2, Next: next("b"), Previous: prev("a")},
+		},
+		"no hits": {
+			req:     SearchRequest{},
+			results: hits(),
+			want:    Pagination{Page: 1, Size: DefaultPageSize},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			paginateResults(tc.results, tc.req, tc.cursor)
+
+			var order []string
+			for _, h := range tc.results.Hits.Results {
+				order = append(order, h.ID)
+			}
+
+			if diff := cmp.Diff(tc.order, order); diff != "" {
+				t.Fatalf(diff)
+			}
+			if diff := cmp.Diff(tc.want, *tc.results.Pagination); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestTiebreakers(t *testing.T) {
+	tiebreakers := Tiebreakers{"droids": "id", "ships-*": "id", "planets": "name", "*": "uuid"}
+
+	tests := map[string]struct {
+		tiebreakers Tiebreakers
+		search      SearchRequest
+		tiebreaker  string
+	}{
+		"index":         {tiebreakers: tiebreakers, search: SearchRequest{Index: "planets"}, tiebreaker: "name"},
+		"same field":    {tiebreakers: tiebreakers, search: SearchRequest{Indices: []string{"droids", "droids"}}, tiebreaker: "id"},
+		"other fields":  {tiebreakers: tiebreakers, search: SearchRequest{Indices: []string{"droids", "planets"}}},
+		"pattern":       {tiebreakers: tiebreakers, search: SearchRequest{Index: "moons"}, tiebreaker: "uuid"},
+		"patterns":      {tiebreakers: Tiebreakers{"ships-*": "id", "ships-1*": "id"}, search: SearchRequest{Index: "ships-1"}, tiebreaker: "id"},
+		"ambiguous":     {tiebreakers: tiebreakers, search: SearchRequest{Index: "ships-1"}},
+		"no field":      {tiebreakers: Tiebreakers{"droids": "id"}, search: SearchRequest{Index: "moons"}},
+		"no tiebreaker": {search: SearchRequest{Index: "droids", tiebreaker: "id"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.tiebreaker, tc.tiebreakers.Apply(tc.search).tiebreaker); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package searching
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+const (
+	// PinsIndex is the index pinned results are stored in
+	PinsIndex    = "pinned-results"
+	pinsTemplate = "pinned-results"
+	// MaxPinnedIDs is the most documents that can be pinned to a query
+	MaxPinnedIDs = 20
+	// pinBoost is the score of the last pinned document, well above any score of a match, so pinned
+	// documents come first in the order they are pinned
+	pinBoost = 1e9
+)
+
+// ErrPinNotFound is returned, wrapped, when no documents are pinned to a query
+var ErrPinNotFound = errors.New("pin not found")
+
+var pinsMapping = map[string]interface{}{
+	"index_patterns": []string{PinsIndex},
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"index":   map[string]interface{}{"type": "keyword"},
+			"query":   map[string]interface{}{"type": "keyword"},
+			"ids":     map[string]interface{}{"type": "keyword"},
+			"updated": map[string]interface{}{"type": "date"},
+		},
+	},
+}
+
+// Pin promotes documents to the top of the searches of a query, in the order of their IDs. The query is
+// matched once lowercased and with its spaces collapsed, and the index is the name searches are logged
+// under.
+type Pin struct {
+	Index   string    `json:"index"`
+	Query   string    `json:"query"`
+	IDs     []string  `json:"ids"`
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// Pins lists the pins of an index
+type Pins struct {
+	Pins []Pin `json:"pins"`
+}
+
+// Pinner manages the documents pinned to queries
+type Pinner interface {
+	Pins(ctx context.Context, index string) (*Pins, error)
+	Pin(ctx context.Context, p Pin) (*Pin, error)
+	Unpin(ctx context.Context, index string, query string) error
+}
+
+// Validate checks that the pin has a query and documents to promote. Its errors are of kind ErrBadRequest.
+func (p Pin) Validate() error {
+	if p.Index == "" || normalizeQuery(p.Query) == "" {
+		return invalid(fmt.Errorf("pins need an index and a query"))
+	}
+	if len(p.IDs) == 0 || len(p.IDs) > MaxPinnedIDs {
+		return invalid(fmt.Errorf("pins need between 1 and %d document IDs, got %d", MaxPinnedIDs, len(p.IDs)))
+	}
+	for _, id := range p.IDs {
+		if id == "" {
+			return invalid(fmt.Errorf("pinned document IDs can't be empty"))
+		}
+	}
+	return nil
+}
+
+// normalizeQuery returns the form of a query pins are matched by
+func normalizeQuery(q string) string {
+	return strings.ToLower(strings.Join(strings.Fields(q), " "))
+}
+
+// pinID returns the ID of the document storing the pin of the query
+func pinID(index string, query string) string {
+	h := sha1.Sum([]byte(index + "\x00" + normalizeQuery(query)))
+	return hex.EncodeToString(h[:])
+}
+
+// PutPinsTemplate installs the index template mapping the index pins are stored in
+func PutPinsTemplate(es *elasticsearch.Client) error {
+	body, err := json.Marshal(pinsMapping)
+	if err != nil {
+		return err
+	}
+
+	res, err := es.Indices.PutTemplate(pinsTemplate, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error installing the pinned results index template: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("[%s] Error installing the pinned results index template: %s", res.Status(), res.String())
+	}
+	return nil
+}
+
+// GetPins returns the pins of the index, sorted by query
+func GetPins(ctx context.Context, es *elasticsearch.Client, index string) (*Pins, error) {
+	pins := &Pins{Pins: []Pin{}}
+	err := listAll(ctx, es, PinsIndex, index, "query", func(source json.RawMessage) error {
+		var p Pin
+		if err := json.Unmarshal(source, &p); err != nil {
+			return err
+		}
+		pins.Pins = append(pins.Pins, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+// PutPin pins the documents to the query, replacing those pinned before. The pin applies to searches
+// right away.
+func PutPin(ctx context.Context, es *elasticsearch.Client, p Pin) (*Pin, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	p.Query = normalizeQuery(p.Query)
+	p.Updated = time.Now().UTC()
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	req := esapi.IndexRequest{
+		Index:      PinsIndex,
+		DocumentID: pinID(p.Index, p.Query),
+		Body:       bytes.NewReader(body),
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, es)
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res, fmt.Sprintf("Error pinning documents to %q", p.Query))
+	}
+	return &p, nil
+}
+
+// DeletePin unpins the documents pinned to the query
+func DeletePin(ctx context.Context, es *elasticsearch.Client, index string, query string) error {
+	req := esapi.DeleteRequest{
+		Index:      PinsIndex,
+		DocumentID: pinID(index, query),
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, es)
+	if err != nil {
+		return requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: no documents pinned to %q in %s", ErrPinNotFound, normalizeQuery(query), index)
+	}
+	if res.IsError() {
+		return responseError(res, fmt.Sprintf("Error unpinning documents from %q", query))
+	}
+	return nil
+}
+
+// pinnedIDs returns the IDs of the documents pinned to the search term, if any. The pins of the index are
+// taken from the cache, which may be nil.
+func pinnedIDs(ctx context.Context, es *elasticsearch.Client, cache *indexCache, s SearchRequest) ([]string, error) {
+	index := s.logIndex()
+	byQuery, err := cache.get(index, func() (interface{}, error) {
+		pins, err := GetPins(ctx, es, index)
+		if err != nil {
+			return nil, err
+		}
+		byQuery := map[string][]string{}
+		for _, p := range pins.Pins {
+			byQuery[p.Query] = p.IDs
+		}
+		return byQuery, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return byQuery.(map[string][]string)[normalizeQuery(s.SearchTerm)], nil
+}
+
+// pinQuery returns the query promoting the pinned documents above the hits of the query, which still
+// must match the filters. Sorted searches sort on the score first, so the hits that aren't pinned score
+// 0 to be ordered by the sort.
+func pinQuery(query map[string]interface{}, pins []string, filters []Filter, sorted bool) map[string]interface{} {
+	var should []interface{}
+	for i, id := range pins {
+		should = append(should, map[string]interface{}{
+			"constant_score": map[string]interface{}{
+				"filter": map[string]interface{}{"ids": map[string]interface{}{"values": []string{id}}},
+				"boost":  pinBoost * float64(len(pins)-i),
+			},
+		})
+	}
+
+	if sorted {
+		query = map[string]interface{}{"constant_score": map[string]interface{}{"filter": query, "boost": 0}}
+	}
+	b := map[string]interface{}{
+		"should":               append(should, query),
+		"minimum_should_match": 1,
+	}
+	if len(filters) > 0 {
+		b["filter"] = filterClauses(filters)
+	}
+	return map[string]interface{}{"bool": b}
+}
+
+// markPinned flags the hits that are pinned
+func markPinned(r *Results, pins []string) {
+	pinned := map[string]bool{}
+	for _, id := range pins {
+		pinned[id] = true
+	}
+	for i, h := range r.Hits.Results {
+		r.Hits.Results[i].Pinned = pinned[h.ID]
+	}
+}
@@ -0,0 +1,336 @@
+package searching
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+)
+
+func TestPinValidate(t *testing.T) {
+	tests := map[string]struct {
+		pin Pin
+		err string
+	}{
+		"valid":        {pin: Pin{Index: "droids", Query: "droid", IDs: []string{"2", "1"}}},
+		"no index":     {pin: Pin{Query: "droid", IDs: []string{"1"}}, err: "pins need an index and a query"},
+		"blank query":  {pin: Pin{Index: "droids", Query: "  ", IDs: []string{"1"}}, err: "pins need an index and a query"},
+		"no ids":       {pin: Pin{Index: "droids", Query: "droid"}, err: "pins need between 1 and 20 document IDs, got 0"},
+		"too many ids": {pin: Pin{Index: "droids", Query: "droid", IDs: make([]string, 21)}, err: "pins need between 1 and 20 document IDs, got 21"},
+		"empty id":     {pin: Pin{Index: "droids", Query: "droid", IDs: []string{"1", ""}}, err: "pinned document IDs can't be empty"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.pin.Validate()
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+				if !errors.Is(err, ErrBadRequest) {
+					t.Fatalf("error should be a bad request, received : %v", err)
+				}
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestPinID(t *testing.T) {
+	if pinID("droids", "  Protocol   Droid ") != pinID("droids", "protocol droid") {
+		t.Fatalf("pins should match queries regardless of case and spaces")
+	}
+	if pinID("droids", "droid") == pinID("ships", "droid") {
+		t.Fatalf("pins of different indices should not match")
+	}
+}
+
+func TestBuildQueryPins(t *testing.T) {
+	match := map[string]interface{}{"multi_match": map[string]interface{}{"query": "droid"}}
+	pinned := func(id string, boost float64) map[string]interface{} {
+		return map[string]interface{}{
+			"constant_score": map[string]interface{}{
+				"filter": map[string]interface{}{"ids": map[string]interface{}{"values": []string{id}}},
+				"boost":  boost,
+			},
+		}
+	}
+
+	tests := map[string]struct {
+		search   SearchRequest
+		expected map[string]interface{}
+		sort     []map[string]interface{}
+	}{
+		"pins": {
+			search: SearchRequest{SearchTerm: "droid", pins: []string{"2", "1"}},
+			expected: map[string]interface{}{"bool": map[string]interface{}{
+				"should":               []interface{}{pinned("2", 2*pinBoost), pinned("1", pinBoost), match},
+				"minimum_should_match": 1,
+			}},
+		},
+		"pins and filters": {
+			search: SearchRequest{SearchTerm: "droid", pins: []string{"2"}, Filters: []Filter{{Field: "species", Term: "robot"}}},
+			expected: map[string]interface{}{"bool": map[string]interface{}{
+				"should":               []interface{}{pinned("2", pinBoost), match},
+				"minimum_should_match": 1,
+				"filter":               []interface{}{map[string]interface{}{"term": map[string]interface{}{"species": "robot"}}},
+			}},
+		},
+		// the matches score 0 so they're ordered by the sort after the pins
+		"pins and sort": {
+			search: SearchRequest{SearchTerm: "droid", pins: []string{"2"}, Sort: []SortField{{Field: "name"}}},
+			expected: map[string]interface{}{"bool": map[string]interface{}{
+				"should":               []interface{}{pinned("2", pinBoost), map[string]interface{}{"constant_score": map[string]interface{}{"filter": match, "boost": 0}}},
+				"minimum_should_match": 1,
+			}},
+			sort: []map[string]interface{}{{"_score": "desc"}, {"name": "asc"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			q := buildQuery(tc.search, nil)
+			if diff := cmp.Diff(tc.expected, q.Query); diff != "" {
+				t.Fatalf(diff)
+			}
+			if diff := cmp.Diff(tc.sort, q.Sort); tc.sort != nil && diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestFunctionScore(t *testing.T) {
+	match := map[string]interface{}{"multi_match": map[string]interface{}{"query": "droid"}}
+	missing := 0.5
+
+	tests := map[string]struct {
+		profile  Profile
+		expected map[string]interface{}
+	}{
+		"none": {profile: Profile{}, expected: match},
+		"decay": {
+			profile: Profile{Decay: &Decay{Function: "gauss", Field: "published", Scale: "30d", Offset: "7d", DecayValue: 0.5}},
+			expected: map[string]interface{}{"function_score": map[string]interface{}{
+				"query": match,
+				"functions": []interface{}{map[string]interface{}{"gauss": map[string]interface{}{
+					"published": map[string]interface{}{"origin": "now", "scale": "30d", "offset": "7d", "decay": 0.5},
+				}}},
+				"score_mode": "multiply",
+			}},
+		},
+		"popularity": {
+			profile: Profile{Popularity: &Popularity{Field: "views", Factor: 1.2, Modifier: "log1p"}, BoostMode: "sum"},
+			expected: map[string]interface{}{"function_score": map[string]interface{}{
+				"query": match,
+				"functions": []interface{}{map[string]interface{}{"field_value_factor": map[string]interface{}{
+					"field": "views", "factor": 1.2, "modifier": "log1p", "missing": 1.0,
+				}}},
+				"score_mode": "multiply",
+				"boost_mode": "sum",
+			}},
+		},
+		"both": {
+			profile: Profile{
+				Decay:      &Decay{Function: "exp", Field: "published", Origin: "2020-01-01", Scale: "10d"},
+				Popularity: &Popularity{Field: "views", Missing: &missing},
+			},
+			expected: map[string]interface{}{"function_score": map[string]interface{}{
+				"query": match,
+				"functions": []interface{}{
+					map[string]interface{}{"exp": map[string]interface{}{
+						"published": map[string]interface{}{"origin": "2020-01-01", "scale": "10d"},
+					}},
+					map[string]interface{}{"field_value_factor": map[string]interface{}{"field": "views", "missing": 0.5}},
+				},
+				"score_mode": "multiply",
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := SearchRequest{SearchTerm: "droid", profile: &tc.profile}
+			if diff := cmp.Diff(tc.expected, buildQuery(s, nil).Query); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestProfileScoringValidate(t *testing.T) {
+	tests := map[string]struct {
+		profile Profile
+		err     string
+	}{
+		"valid":          {profile: Profile{Decay: &Decay{Function: "linear", Field: "published", Scale: "1d"}, Popularity: &Popularity{Field: "views"}}},
+		"unknown decay":  {profile: Profile{Decay: &Decay{Function: "cubic", Field: "published", Scale: "1d"}}, err: `decay function must be gauss, exp or linear, got "cubic"`},
+		"no scale":       {profile: Profile{Decay: &Decay{Function: "gauss", Field: "published"}}, err: "decay needs a field and a scale"},
+		"decay value":    {profile: Profile{Decay: &Decay{Function: "gauss", Field: "published", Scale: "1d", DecayValue: 1}}, err: "decay value must be between 0 and 1, got 1"},
+		"no popularity":  {profile: Profile{Popularity: &Popularity{}}, err: "popularity needs a field"},
+		"template decay": {profile: Profile{Template: "t", Decay: &Decay{Function: "gauss", Field: "published", Scale: "1d"}}, err: "a template profile can't have other options"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.profile.validate()
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestPins(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Index("droids", "1", `{"meta":{"title":"R2-D2","description":"An astromech droid"}}`)
+	srv.Index("droids", "2", `{"meta":{"title":"C-3PO","description":"A protocol droid"}}`)
+	es := srv.Client()
+	ctx := context.Background()
+
+	pin, err := PutPin(ctx, es, Pin{Index: "droids", Query: " Droid ", IDs: []string{"2"}})
+	if err != nil {
+		t.Fatalf("Unexpected error pinning: %s", err)
+	}
+	if pin.Query != "droid" || pin.Updated.IsZero() {
+		t.Fatalf("pins should be stored normalized and dated, received : %+v", pin)
+	}
+
+	pins, err := GetPins(ctx, es, "droids")
+	if err != nil {
+		t.Fatalf("Unexpected error getting pins: %s", err)
+	}
+	if len(pins.Pins) != 1 || pins.Pins[0].Query != "droid" {
+		t.Fatalf("expected the pin of droid, received : %+v", pins)
+	}
+
+	req, _ := http.NewRequest("GET", "/search", nil)
+	res, err := Search(es, nil, req, SearchRequest{Index: "droids", SearchTerm: "DROID"}, logrus.New())
+	if err != nil {
+		t.Fatalf("Unexpected error searching: %s", err)
+	}
+	for _, h := range res.Hits.Results {
+		if h.Pinned != (h.ID == "2") {
+			t.Fatalf("only the pinned document should be marked pinned, received : %+v", h)
+		}
+	}
+	searches := srv.RequestsTo("", "/droids/_search")
+	if len(searches) != 1 || !strings.Contains(string(searches[0].Body), `"ids":{"values":["2"]}`) {
+		t.Fatalf("search should promote the pinned document, received : %+v", searches)
+	}
+
+	if err := DeletePin(ctx, es, "droids", "droid"); err != nil {
+		t.Fatalf("Unexpected error unpinning: %s", err)
+	}
+	if err := DeletePin(ctx, es, "droids", "droid"); !errors.Is(err, ErrPinNotFound) {
+		t.Fatalf("unpinning twice should fail with pin not found, received : %v", err)
+	}
+	ids, err := pinnedIDs(ctx, es, nil, SearchRequest{Index: "droids", SearchTerm: "droid"})
+	if err != nil || ids != nil {
+		t.Fatalf("nothing should be pinned after unpinning, received : %v, %v", ids, err)
+	}
+}
+
+func TestElasticCachesPins(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Index("droids", "1", `{"meta":{"title":"R2-D2","description":"An astromech droid"}}`)
+	srv.Index("droids", "2", `{"meta":{"title":"C-3PO","description":"A protocol droid"}}`)
+	e := NewElastic(srv.Client(), nil, logrus.New())
+	ctx := context.Background()
+
+	search := func() *Results {
+		req, _ := http.NewRequest("GET", "/search", nil)
+		res, err := e.Search(req, SearchRequest{Index: "droids", SearchTerm: "droid"})
+		if err != nil {
+			t.Fatalf("Unexpected error searching: %s", err)
+		}
+		return res
+	}
+	pinned := func(res *Results) []string {
+		var ids []string
+		for _, h := range res.Hits.Results {
+			if h.Pinned {
+				ids = append(ids, h.ID)
+			}
+		}
+		return ids
+	}
+
+	search()
+	search()
+	if n := len(srv.RequestsTo("", "/"+PinsIndex+"/_search")); n != 1 {
+		t.Fatalf("pins should be fetched once, received : %d requests", n)
+	}
+
+	// pinning through the searcher applies to its searches right away
+	if _, err := e.Pin(ctx, Pin{Index: "droids", Query: "droid", IDs: []string{"2"}}); err != nil {
+		t.Fatalf("Unexpected error pinning: %s", err)
+	}
+	if diff := cmp.Diff([]string{"2"}, pinned(search())); diff != "" {
+		t.Fatalf(diff)
+	}
+	if err := e.Unpin(ctx, "droids", "droid"); err != nil {
+		t.Fatalf("Unexpected error unpinning: %s", err)
+	}
+	if diff := cmp.Diff([]string(nil), pinned(search())); diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestGetPinsPages(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	defer func(size int) { listPageSize = size }(listPageSize)
+	listPageSize = 2
+	pages := []string{
+		`{"hits":{"hits":[{"_source":{"query":"a","ids":["1"]},"sort":["a"]},{"_source":{"query":"b","ids":["1"]},"sort":["b"]}]}}`,
+		`{"hits":{"hits":[{"_source":{"query":"c","ids":["1"]},"sort":["c"]}]}}`,
+	}
+	for _, p := range pages {
+		srv.HandleOnce("", "/"+PinsIndex+"/_search", func(body string) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(body))
+			}
+		}(p))
+	}
+
+	pins, err := GetPins(context.Background(), srv.Client(), "droids")
+	if err != nil {
+		t.Fatalf("Unexpected error getting pins: %s", err)
+	}
+	var queries []string
+	for _, p := range pins.Pins {
+		queries = append(queries, p.Query)
+	}
+	if diff := cmp.Diff([]string{"a", "b", "c"}, queries); diff != "" {
+		t.Fatalf(diff)
+	}
+	searches := srv.RequestsTo("", "/"+PinsIndex+"/_search")
+	if len(searches) != 2 || !strings.Contains(string(searches[1].Body), `"search_after":["b"]`) {
+		t.Fatalf("the second page should follow the first, received : %+v", searches)
+	}
+}
+
+func TestPinnedIDsNoIndex(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	ids, err := pinnedIDs(context.Background(), srv.Client(), newIndexCache(), SearchRequest{Index: "droids", SearchTerm: "droid"})
+	if err != nil || ids != nil {
+		t.Fatalf("searches should have no pins without the pins index, received : %v, %v", ids, err)
+	}
+}
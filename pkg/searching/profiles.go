@@ -0,0 +1,364 @@
+package searching
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// DefaultProfile is the name of the profile searches use when they don't name one and have no fields
+const DefaultProfile = "default"
+
+// DefaultFields are searched by the default profile, unless a default profile is configured
+var DefaultFields = []string{"meta.description^2", "meta.title", "source.h1", "source.h2", "source.p"}
+
+// multiMatchTypes are the types of multi_match query a profile can use
+var multiMatchTypes = map[string]bool{
+	"best_fields":   true,
+	"most_fields":   true,
+	"cross_fields":  true,
+	"phrase":        true,
+	"phrase_prefix": true,
+	"bool_prefix":   true,
+}
+
+// decayFunctions are the decay functions a profile can score dates with
+var decayFunctions = map[string]bool{"gauss": true, "exp": true, "linear": true}
+
+// boostModes are the ways a profile can combine its adjustments with the score of the match
+var boostModes = map[string]bool{"multiply": true, "replace": true, "sum": true, "avg": true, "max": true, "min": true}
+
+// modifiers are the functions a profile can apply to the value of its popularity field
+var modifiers = map[string]bool{
+	"none":       true,
+	"log":        true,
+	"log1p":      true,
+	"log2p":      true,
+	"ln":         true,
+	"ln1p":       true,
+	"ln2p":       true,
+	"square":     true,
+	"sqrt":       true,
+	"reciprocal": true,
+}
+
+// Profile describes how the search term is matched: the fields searched, with optional ^boosts, and the
+// options of the multi_match query. Decay and Popularity adjust the score of the matching documents. A
+// profile can instead name a search template stored in Elasticsearch, which is then given the search
+// term and page as params.
+type Profile struct {
+	Fields             []string
+	Type               string
+	Fuzziness          string
+	Operator           string
+	MinimumShouldMatch string
+	Decay              *Decay
+	Popularity         *Popularity
+	// BoostMode is how the adjustments combine with the score of the match, multiply by default
+	BoostMode string
+	// Template is the ID of a stored search template, used instead of the other options
+	Template string
+}
+
+// Decay lowers the score of documents as their date gets further from Origin, e.g. to favour fresh pages.
+// A document Scale away from Origin, past Offset, has its score multiplied by DecayValue.
+type Decay struct {
+	// Function is gauss, exp or linear
+	Function string
+	Field    string
+	// Origin is a date or date math, now by default
+	Origin     string
+	Scale      string
+	Offset     string
+	DecayValue float64
+}
+
+// Popularity raises the score of documents with a field_value_factor on a numeric field, e.g. page views
+type Popularity struct {
+	Field  string
+	Factor float64
+	// Modifier is applied to the value of the field, such as log1p or sqrt
+	Modifier string
+	// Missing is the value of documents without the field, 1 by default so they keep their score
+	Missing *float64
+}
+
+func (p Profile) validate() error {
+	if p.Template != "" {
+		if len(p.Fields) > 0 || p.Type != "" || p.Fuzziness != "" || p.Operator != "" || p.MinimumShouldMatch != "" ||
+			p.Decay != nil || p.Popularity != nil || p.BoostMode != "" {
+			return fmt.Errorf("a template profile can't have other options")
+		}
+		return nil
+	}
+	if d := p.Decay; d != nil {
+		if !decayFunctions[d.Function] {
+			return fmt.Errorf("decay function must be gauss, exp or linear, got %q", d.Function)
+		}
+		if d.Field == "" || d.Scale == "" {
+			return fmt.Errorf("decay needs a field and a scale")
+		}
+		if d.DecayValue < 0 || d.DecayValue >= 1 {
+			return fmt.Errorf("decay value must be between 0 and 1, got %g", d.DecayValue)
+		}
+	}
+	if pop := p.Popularity; pop != nil {
+		if pop.Field == "" {
+			return fmt.Errorf("popularity needs a field")
+		}
+		if pop.Modifier != "" && !modifiers[pop.Modifier] {
+			return fmt.Errorf("unknown popularity modifier %q", pop.Modifier)
+		}
+	}
+	if p.BoostMode != "" && !boostModes[p.BoostMode] {
+		return fmt.Errorf("boost mode must be multiply, replace, sum, avg, max or min, got %q", p.BoostMode)
+	}
+	if p.Type != "" && !multiMatchTypes[p.Type] {
+		return fmt.Errorf("unknown multi_match type %q", p.Type)
+	}
+	if p.Operator != "" && p.Operator != "and" && p.Operator != "or" {
+		return fmt.Errorf("operator must be and or or, got %q", p.Operator)
+	}
+	if p.Fuzziness != "" && (p.Type == "cross_fields" || p.Type == "phrase" || p.Type == "phrase_prefix") {
+		return fmt.Errorf("fuzziness can't be used with the %s type", p.Type)
+	}
+	return nil
+}
+
+// Profiles holds the named search profiles, which can be replaced while searches use them. Names are case
+// insensitive, as configuration keys are. A nil Profiles only has the default profile.
+type Profiles struct {
+	mu       sync.RWMutex
+	profiles map[string]Profile
+}
+
+// NewProfiles returns the profiles, or an error when one of them is invalid
+func NewProfiles(profiles map[string]Profile) (*Profiles, error) {
+	p := &Profiles{}
+	if err := p.Set(profiles); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Set replaces the profiles, unless one of them is invalid or two have the same name in different cases
+func (p *Profiles) Set(profiles map[string]Profile) error {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	// sort so the same profiles fail with the same error
+	sort.Strings(names)
+	normalized := make(map[string]Profile, len(profiles))
+	for _, name := range names {
+		if err := profiles[name].validate(); err != nil {
+			return fmt.Errorf("Invalid search profile %q: %s", name, err)
+		}
+		if _, ok := normalized[strings.ToLower(name)]; ok {
+			return fmt.Errorf("Invalid search profile %q: another profile has the same name", name)
+		}
+		normalized[strings.ToLower(name)] = profiles[name]
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profiles = normalized
+	return nil
+}
+
+// Get returns the named profile. The default profile searches DefaultFields when it isn't configured.
+func (p *Profiles) Get(name string) (Profile, bool) {
+	name = strings.ToLower(name)
+	if p != nil {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		if profile, ok := p.profiles[name]; ok {
+			return profile, true
+		}
+	}
+	if name == DefaultProfile {
+		return Profile{Fields: DefaultFields}, true
+	}
+	return Profile{}, false
+}
+
+// Names returns the names of the profiles, sorted
+func (p *Profiles) Names() []string {
+	names := []string{DefaultProfile}
+	if p != nil {
+		p.mu.RLock()
+		for name := range p.profiles {
+			if name != DefaultProfile {
+				names = append(names, name)
+			}
+		}
+		p.mu.RUnlock()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply sets the profile the search names on it, the default profile when it names none and has no fields.
+// A highlight without fields of a search without fields gets those of the profile. Its errors are of kind
+// ErrBadRequest.
+func (p *Profiles) Apply(s SearchRequest) (SearchRequest, error) {
+	if s.Profile == "" {
+		if len(s.Fields) > 0 {
+			return s, nil
+		}
+		s.Profile = DefaultProfile
+	}
+
+	profile, ok := p.Get(s.Profile)
+	if !ok {
+		return s, invalid(fmt.Errorf("unknown search profile %q, expected one of %s", s.Profile, strings.Join(p.Names(), ", ")))
+	}
+	if profile.Template != "" {
+		if s.Cursor != "" || len(s.Facets) > 0 || len(s.Sort) > 0 || s.Source != nil || s.Highlight != nil || len(s.IndicesBoost) > 0 {
+			return s, invalid(fmt.Errorf("search profile %q is a template, which can't be combined with cursors, facets, sorts, source fields, highlights or index boosts", s.Profile))
+		}
+	}
+	s.profile = &profile
+
+	if h := s.Highlight; h != nil && len(h.Fields) == 0 && len(s.Fields) == 0 {
+		highlight := *h
+		for _, f := range profile.Fields {
+			// strip the boost, e.g. meta.description^2
+			highlight.Fields = append(highlight.Fields, strings.SplitN(f, "^", 2)[0])
+		}
+		s.Highlight = &highlight
+	}
+	return s, nil
+}
+
+// fields returns the fields the search term is matched against: those of the request, else those of
+// its profile
+func (s SearchRequest) fields() []string {
+	if len(s.Fields) > 0 || s.profile == nil {
+		return s.Fields
+	}
+	return s.profile.Fields
+}
+
+// multiMatch returns the multi_match clause of the search term
+func multiMatch(s SearchRequest) map[string]interface{} {
+	mm := map[string]interface{}{"query": s.SearchTerm}
+	if fields := s.fields(); len(fields) > 0 {
+		mm["fields"] = fields
+	}
+	if p := s.profile; p != nil {
+		for k, v := range map[string]string{
+			"type":                 p.Type,
+			"fuzziness":            p.Fuzziness,
+			"operator":             p.Operator,
+			"minimum_should_match": p.MinimumShouldMatch,
+		} {
+			if v != "" {
+				mm[k] = v
+			}
+		}
+	}
+	return map[string]interface{}{"multi_match": mm}
+}
+
+// functionScore wraps the query in a function_score applying the decay and popularity of the profile,
+// unless it has neither
+func functionScore(query map[string]interface{}, p *Profile) map[string]interface{} {
+	if p == nil || (p.Decay == nil && p.Popularity == nil) {
+		return query
+	}
+
+	var functions []interface{}
+	if d := p.Decay; d != nil {
+		decay := map[string]interface{}{"scale": d.Scale, "origin": "now"}
+		if d.Origin != "" {
+			decay["origin"] = d.Origin
+		}
+		if d.Offset != "" {
+			decay["offset"] = d.Offset
+		}
+		if d.DecayValue > 0 {
+			decay["decay"] = d.DecayValue
+		}
+		functions = append(functions, map[string]interface{}{
+			d.Function: map[string]interface{}{d.Field: decay},
+		})
+	}
+	if pop := p.Popularity; pop != nil {
+		factor := map[string]interface{}{"field": pop.Field, "missing": 1.0}
+		if pop.Missing != nil {
+			factor["missing"] = *pop.Missing
+		}
+		if pop.Factor > 0 {
+			factor["factor"] = pop.Factor
+		}
+		if pop.Modifier != "" {
+			factor["modifier"] = pop.Modifier
+		}
+		functions = append(functions, map[string]interface{}{"field_value_factor": factor})
+	}
+
+	fs := map[string]interface{}{
+		"query":      query,
+		"functions":  functions,
+		"score_mode": "multiply",
+	}
+	if p.BoostMode != "" {
+		fs["boost_mode"] = p.BoostMode
+	}
+	return map[string]interface{}{"function_score": fs}
+}
+
+// templateQuery returns the body of a search with a stored template. The template is given the search
+// term as query, the page as from and size, the filter clauses as filter, and the shard timeout as timeout
+// when there is one, since the search template API takes no timeout of its own.
+func templateQuery(s SearchRequest, timeout time.Duration) map[string]interface{} {
+	params := map[string]interface{}{
+		"query": s.SearchTerm,
+		"from":  from(s),
+		"size":  pageSize(s),
+	}
+	if len(s.Filters) > 0 {
+		params["filter"] = filterClauses(s.Filters)
+	}
+	if timeout > 0 {
+		params["timeout"] = fmt.Sprintf("%dms", timeout.Milliseconds())
+	}
+	return map[string]interface{}{"id": s.profile.Template, "params": params}
+}
+
+// searchTemplate runs the search with the stored template of its profile
+func searchTemplate(ctx context.Context, es *elasticsearch.Client, s SearchRequest) (r *Results, err error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(templateQuery(s, shardTimeout(ctx))); err != nil {
+		return nil, err
+	}
+
+	res, err := es.SearchTemplate(
+		&buf,
+		es.SearchTemplate.WithContext(ctx),
+		es.SearchTemplate.WithIndex(s.targets()...),
+	)
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res, fmt.Sprintf("Error searching %s with template %s", strings.Join(s.indexNames(), ","), s.profile.Template))
+	}
+
+	d := json.NewDecoder(res.Body)
+	d.UseNumber()
+	if err := d.Decode(&r); err != nil {
+		return nil, parseError(err)
+	}
+	return r, nil
+}
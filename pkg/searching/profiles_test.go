@@ -0,0 +1,238 @@
+package searching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+)
+
+func TestNewProfiles(t *testing.T) {
+	tests := map[string]struct {
+		profiles map[string]Profile
+		err      string
+	}{
+		"valid": {profiles: map[string]Profile{
+			"default": {Fields: []string{"title^2", "body"}, Type: "best_fields", Fuzziness: "AUTO", Operator: "and", MinimumShouldMatch: "75%"},
+			"docs":    {Template: "docs-search"},
+		}},
+		"unknown type":   {profiles: map[string]Profile{"a": {Type: "fuzzy_fields"}}, err: `Invalid search profile "a": unknown multi_match type "fuzzy_fields"`},
+		"bad operator":   {profiles: map[string]Profile{"a": {Operator: "xor"}}, err: `Invalid search profile "a": operator must be and or or, got "xor"`},
+		"fuzzy phrase":   {profiles: map[string]Profile{"a": {Type: "phrase", Fuzziness: "AUTO"}}, err: `Invalid search profile "a": fuzziness can't be used with the phrase type`},
+		"mixed template": {profiles: map[string]Profile{"a": {Template: "t", Fields: []string{"title"}}}, err: `Invalid search profile "a": a template profile can't have other options`},
+		"first invalid":  {profiles: map[string]Profile{"b": {Operator: "xor"}, "a": {Type: "x"}}, err: `Invalid search profile "a": unknown multi_match type "x"`},
+		"same name":      {profiles: map[string]Profile{"news": {}, "News": {}}, err: `Invalid search profile "news": another profile has the same name`},
+		"boost mode":     {profiles: map[string]Profile{"a": {Popularity: &Popularity{Field: "views"}, BoostMode: "mutliply"}}, err: `Invalid search profile "a": boost mode must be multiply, replace, sum, avg, max or min, got "mutliply"`},
+		"modifier":       {profiles: map[string]Profile{"a": {Popularity: &Popularity{Field: "views", Modifier: "log10"}}}, err: `Invalid search profile "a": unknown popularity modifier "log10"`},
+		"adjusted":       {profiles: map[string]Profile{"a": {Popularity: &Popularity{Field: "views", Modifier: "log1p"}, BoostMode: "sum"}}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewProfiles(tc.profiles)
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestProfilesSetKeepsValid(t *testing.T) {
+	p, err := NewProfiles(map[string]Profile{"exact": {Type: "phrase"}})
+	if err != nil {
+		t.Fatalf("Unexpected error creating profiles: %s", err)
+	}
+	if err := p.Set(map[string]Profile{"exact": {Type: "nope"}}); err == nil {
+		t.Fatalf("invalid profiles should not be set")
+	}
+	if profile, _ := p.Get("exact"); profile.Type != "phrase" {
+		t.Fatalf("profiles should be kept when the new ones are invalid, received : %+v", profile)
+	}
+}
+
+func TestMultiMatch(t *testing.T) {
+	p, err := NewProfiles(map[string]Profile{
+		"fuzzy": {Fields: []string{"title^3", "body"}, Fuzziness: "AUTO", Operator: "and", MinimumShouldMatch: "2<75%"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating profiles: %s", err)
+	}
+
+	tests := map[string]struct {
+		search   SearchRequest
+		profiles *Profiles
+		expected map[string]interface{}
+	}{
+		"no profile": {
+			search:   SearchRequest{SearchTerm: "r2d2"},
+			expected: map[string]interface{}{"query": "r2d2", "fields": DefaultFields},
+		},
+		"no profile, fields": {
+			search:   SearchRequest{SearchTerm: "r2d2", Fields: []string{"name"}},
+			expected: map[string]interface{}{"query": "r2d2", "fields": []string{"name"}},
+		},
+		"default": {
+			search:   SearchRequest{SearchTerm: "r2d2", Profile: DefaultProfile},
+			expected: map[string]interface{}{"query": "r2d2", "fields": DefaultFields},
+		},
+		"profile": {
+			search:   SearchRequest{SearchTerm: "r2d2", Profile: "Fuzzy"},
+			profiles: p,
+			expected: map[string]interface{}{"query": "r2d2", "fields": []string{"title^3", "body"}, "fuzziness": "AUTO", "operator": "and", "minimum_should_match": "2<75%"},
+		},
+		"request fields": {
+			search:   SearchRequest{SearchTerm: "r2d2", Profile: "fuzzy", Fields: []string{"name"}},
+			profiles: p,
+			expected: map[string]interface{}{"query": "r2d2", "fields": []string{"name"}, "fuzziness": "AUTO", "operator": "and", "minimum_should_match": "2<75%"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := tc.profiles.Apply(tc.search)
+			if err != nil {
+				t.Fatalf("Unexpected error applying profile: %s", err)
+			}
+			if diff := cmp.Diff(map[string]interface{}{"multi_match": tc.expected}, buildQuery(s, nil).Query); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestProfilesApply(t *testing.T) {
+	p, err := NewProfiles(map[string]Profile{"Docs": {Template: "docs-search"}})
+	if err != nil {
+		t.Fatalf("Unexpected error creating profiles: %s", err)
+	}
+
+	tests := map[string]struct {
+		search SearchRequest
+		err    string
+	}{
+		"template":           {search: SearchRequest{Profile: "docs", Page: 2}},
+		"case":               {search: SearchRequest{Profile: "DOCS", Page: 2}},
+		"default":            {search: SearchRequest{Cursor: "abc"}},
+		"unknown":            {search: SearchRequest{Profile: "jedi"}, err: `unknown search profile "jedi", expected one of default, docs`},
+		"template cursor":    {search: SearchRequest{Profile: "docs", Cursor: "abc"}, err: `search profile "docs" is a template, which can't be combined with cursors, facets, sorts, source fields, highlights or index boosts`},
+		"template sort":      {search: SearchRequest{Profile: "docs", Sort: []SortField{{Field: "_score"}}}, err: `search profile "docs" is a template, which can't be combined with cursors, facets, sorts, source fields, highlights or index boosts`},
+		"template highlight": {search: SearchRequest{Profile: "docs", Highlight: &Highlight{}}, err: `search profile "docs" is a template, which can't be combined with cursors, facets, sorts, source fields, highlights or index boosts`},
+		"template boost":     {search: SearchRequest{Profile: "docs", IndicesBoost: map[string]float64{"docs": 2}}, err: `search profile "docs" is a template, which can't be combined with cursors, facets, sorts, source fields, highlights or index boosts`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := p.Apply(tc.search)
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+				if !errors.Is(err, ErrBadRequest) {
+					t.Fatalf("error should be a bad request, received : %v", err)
+				}
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestProfilesApplyHighlight(t *testing.T) {
+	p, err := NewProfiles(map[string]Profile{"news": {Fields: []string{"headline^3", "body"}}})
+	if err != nil {
+		t.Fatalf("Unexpected error creating profiles: %s", err)
+	}
+
+	tests := map[string]struct {
+		search SearchRequest
+		fields []string
+	}{
+		"profile":          {search: SearchRequest{Profile: "news", Highlight: &Highlight{FragmentSize: 150}}, fields: []string{"headline", "body"}},
+		"default":          {search: SearchRequest{Highlight: &Highlight{}}, fields: []string{"meta.description", "meta.title", "source.h1", "source.h2", "source.p"}},
+		"highlight fields": {search: SearchRequest{Profile: "news", Highlight: &Highlight{Fields: []string{"summary"}}}, fields: []string{"summary"}},
+		"request fields":   {search: SearchRequest{Profile: "news", Fields: []string{"title"}, Highlight: &Highlight{}}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			highlight := *tc.search.Highlight
+			s, err := p.Apply(tc.search)
+			if err != nil {
+				t.Fatalf("Unexpected error applying profile: %s", err)
+			}
+			if diff := cmp.Diff(tc.fields, s.Highlight.Fields); diff != "" {
+				t.Fatalf(diff)
+			}
+			if diff := cmp.Diff(highlight, *tc.search.Highlight); diff != "" {
+				t.Fatalf("the highlight of the request should be left as it is: %s", diff)
+			}
+		})
+	}
+}
+
+func TestSearchTemplate(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Respond("", "/docs/_search/template", 200, `{"took":2,"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_index":"docs","_id":"1","_score":1.5,"_source":{"title":"R2-D2"}}]}}`)
+
+	p, err := NewProfiles(map[string]Profile{"docs": {Template: "docs-search"}})
+	if err != nil {
+		t.Fatalf("Unexpected error creating profiles: %s", err)
+	}
+	s, err := p.Apply(SearchRequest{Index: "docs", SearchTerm: "r2d2", Profile: "docs", Page: 2, Size: 5, Filters: []Filter{{Field: "species", Term: "robot"}}})
+	if err != nil {
+		t.Fatalf("Unexpected error applying profile: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/search", nil)
+	res, err := Search(srv.Client(), nil, req, s, logrus.New())
+	if err != nil {
+		t.Fatalf("Unexpected error searching: %s", err)
+	}
+	if diff := cmp.Diff([]string{"1"}, hitIDs(res)); diff != "" {
+		t.Fatalf(diff)
+	}
+	if res.Pagination.Next != "" || res.Pagination.Page != 2 {
+		t.Fatalf("template searches should page without cursors, received : %+v", res.Pagination)
+	}
+
+	requests := srv.RequestsTo("", "/docs/_search/template")
+	if len(requests) != 1 {
+		t.Fatalf("expected a single template search, received : %d", len(requests))
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(requests[0].Body, &body); err != nil {
+		t.Fatalf("could not decode template search: %s", err)
+	}
+	// the shard timeout is a tenth short of the time left, which has started running down
+	params := body["params"].(map[string]interface{})
+	if timeout, _ := params["timeout"].(string); !strings.HasSuffix(timeout, "ms") {
+		t.Fatalf("the template should be given the shard timeout, received : %v", params["timeout"])
+	}
+	delete(params, "timeout")
+	expected := map[string]interface{}{
+		"id": "docs-search",
+		"params": map[string]interface{}{
+			"query":  "r2d2",
+			"from":   float64(5),
+			"size":   float64(5),
+			"filter": []interface{}{map[string]interface{}{"term": map[string]interface{}{"species": "robot"}}},
+		},
+	}
+	if diff := cmp.Diff(expected, body); diff != "" {
+		t.Fatalf(diff)
+	}
+}
@@ -0,0 +1,370 @@
+package searching
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/metrics"
+	"github.com/wambozi/elastic-search-api/m/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultQueryLogQueueSize     = 10000
+	defaultQueryLogBatchSize     = 500
+	defaultQueryLogFlushInterval = time.Second
+	// bulkTimeout bounds a single flush so a slow cluster can't stall the queue indefinitely
+	bulkTimeout = 10 * time.Second
+)
+
+// queryLogDropped and queryLogFailed count the logged queries that never made it to the queries indices,
+// as the dropped and failed of QueryLogStats do
+var (
+	queryLogDropped = promauto.With(metrics.Registry).NewCounter(prometheus.CounterOpts{
+		Name: "querylog_dropped_total",
+		Help: "Logged queries dropped because the query log queue was full or the log closed.",
+	})
+	queryLogFailed = promauto.With(metrics.Registry).NewCounter(prometheus.CounterOpts{
+		Name: "querylog_failed_total",
+		Help: "Logged queries that failed to be indexed.",
+	})
+)
+
+// QueryLog indexes logged queries in the background. Queries are queued without blocking the search,
+// and dropped when the queue is full, then indexed with the _bulk API once a batch fills up or the
+// flush interval passes.
+type QueryLog struct {
+	// counters first so they're 64-bit aligned for atomic access
+	dropped uint64
+	indexed uint64
+	failed  uint64
+
+	client    *elasticsearch.Client
+	log       *logrus.Logger
+	queue     chan queuedQuery
+	batchSize int
+	interval  time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+
+	// queued holds the clicks on the searches queued or being indexed, by search ID, so the clicks made
+	// before a query is indexed are indexed with it
+	clicksMu sync.Mutex
+	queued   map[string]*queuedClicks
+}
+
+// QueryLogStats represents the state of the query log pipeline
+type QueryLogStats struct {
+	QueueDepth    int    `json:"queueDepth"`
+	QueueCapacity int    `json:"queueCapacity"`
+	Dropped       uint64 `json:"dropped"`
+	Indexed       uint64 `json:"indexed"`
+	Failed        uint64 `json:"failed"`
+	Closed        bool   `json:"closed"`
+}
+
+type queuedQuery struct {
+	index string
+	query IndexQuery
+}
+
+// queuedClicks are the clicks on a search whose query isn't indexed yet
+type queuedClicks struct {
+	index  string
+	clicks int
+	ids    []string
+}
+
+func (c *queuedClicks) add(id string) {
+	c.clicks++
+	for _, clicked := range c.ids {
+		if clicked == id {
+			return
+		}
+	}
+	c.ids = append(c.ids, id)
+}
+
+// bulkResponse represents the parts of a _bulk response needed to count failed items
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// NewQueryLog starts the worker indexing the queries logged to the returned QueryLog. Zero options are
+// replaced with defaults.
+func NewQueryLog(es *elasticsearch.Client, opts conf.QueryLogOptions, logger *logrus.Logger) *QueryLog {
+	q := &QueryLog{
+		client:    es,
+		log:       logger,
+		queue:     make(chan queuedQuery, defaultQueryLogQueueSize),
+		batchSize: defaultQueryLogBatchSize,
+		interval:  defaultQueryLogFlushInterval,
+		done:      make(chan struct{}),
+		queued:    map[string]*queuedClicks{},
+	}
+	if opts.QueueSize > 0 {
+		q.queue = make(chan queuedQuery, opts.QueueSize)
+	}
+	if opts.BatchSize > 0 {
+		q.batchSize = opts.BatchSize
+	}
+	if opts.FlushIntervalMillis > 0 {
+		q.interval = time.Duration(opts.FlushIntervalMillis) * time.Millisecond
+	}
+
+	go q.run()
+	return q
+}
+
+// Log queues a query to be indexed in the queries index of index i. It never blocks: the query is dropped,
+// and false returned, when the queue is full or the log is closed.
+func (q *QueryLog) Log(i string, iq IndexQuery) bool {
+	if q == nil {
+		return false
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		atomic.AddUint64(&q.dropped, 1)
+		queryLogDropped.Inc()
+		return false
+	}
+
+	// the search is known before the worker can take it off the queue
+	q.clicksMu.Lock()
+	q.queued[iq.SearchID] = &queuedClicks{index: i}
+	q.clicksMu.Unlock()
+
+	select {
+	case q.queue <- queuedQuery{index: i, query: iq}:
+		return true
+	default:
+		q.clicksMu.Lock()
+		delete(q.queued, iq.SearchID)
+		q.clicksMu.Unlock()
+		atomic.AddUint64(&q.dropped, 1)
+		queryLogDropped.Inc()
+		return false
+	}
+}
+
+// Click counts a click on a search whose query is queued or being indexed, and returns false when the
+// search isn't, for the click to be recorded on the indexed query instead
+func (q *QueryLog) Click(c Click) bool {
+	if q == nil {
+		return false
+	}
+
+	q.clicksMu.Lock()
+	defer q.clicksMu.Unlock()
+
+	qc, ok := q.queued[c.SearchID]
+	if !ok || qc.index != c.Index {
+		return false
+	}
+	qc.add(c.DocumentID)
+	return true
+}
+
+// Stats returns the current queue depth and the number of queries dropped, indexed and failed so far
+func (q *QueryLog) Stats() QueryLogStats {
+	q.mu.RLock()
+	closed := q.closed
+	q.mu.RUnlock()
+
+	return QueryLogStats{
+		QueueDepth:    len(q.queue),
+		QueueCapacity: cap(q.queue),
+		Dropped:       atomic.LoadUint64(&q.dropped),
+		Indexed:       atomic.LoadUint64(&q.indexed),
+		Failed:        atomic.LoadUint64(&q.failed),
+		Closed:        closed,
+	}
+}
+
+// Close stops accepting queries and waits for the queued ones to be indexed, or for the context to be done
+func (q *QueryLog) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Query log closed with %d queries still queued: %w", len(q.queue), ctx.Err())
+	}
+}
+
+func (q *QueryLog) run() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	batch := make([]queuedQuery, 0, q.batchSize)
+	var lastDropped uint64
+
+	for {
+		select {
+		case qq, ok := <-q.queue:
+			if !ok {
+				q.flush(batch)
+				return
+			}
+			batch = append(batch, qq)
+			if len(batch) >= q.batchSize {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+			if dropped := atomic.LoadUint64(&q.dropped); dropped > lastDropped {
+				q.log.Warnf("Query log dropped %d queries, queue depth is %d of %d", dropped-lastDropped, len(q.queue), cap(q.queue))
+				lastDropped = dropped
+			}
+		}
+	}
+}
+
+// bulkBody returns the NDJSON body of a _bulk request indexing the batch
+func bulkBody(batch []queuedQuery) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	for _, qq := range batch {
+		action := map[string]interface{}{
+			"index": map[string]interface{}{"_index": queriesIndex(qq.index), "_id": qq.query.SearchID},
+		}
+		if err := enc.Encode(action); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(qq.query); err != nil {
+			return nil, err
+		}
+	}
+	return &buf, nil
+}
+
+func (q *QueryLog) flush(batch []queuedQuery) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// the clicks made so far are indexed with their queries
+	q.clicksMu.Lock()
+	for i, qq := range batch {
+		if qc := q.queued[qq.query.SearchID]; qc != nil {
+			batch[i].query.Clicks, batch[i].query.ClickedIDs = qc.clicks, qc.ids
+			qc.clicks, qc.ids = 0, nil
+		}
+	}
+	q.clicksMu.Unlock()
+
+	failed, err := q.bulk(batch)
+	if err != nil {
+		q.log.Errorf("Error indexing %d logged queries: %s", len(batch), err)
+	}
+	atomic.AddUint64(&q.failed, uint64(failed))
+	queryLogFailed.Add(float64(failed))
+	atomic.AddUint64(&q.indexed, uint64(len(batch)-failed))
+
+	// and those made while they were being indexed are added to them
+	late := map[string]*queuedClicks{}
+	q.clicksMu.Lock()
+	for _, qq := range batch {
+		if qc := q.queued[qq.query.SearchID]; qc != nil && qc.clicks > 0 {
+			late[qq.query.SearchID] = qc
+		}
+		delete(q.queued, qq.query.SearchID)
+	}
+	q.clicksMu.Unlock()
+
+	for id, qc := range late {
+		ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+		if err := updateClicks(ctx, q.client, qc.index, id, qc.clicks, qc.ids); err != nil {
+			q.log.Errorf("Error recording %d clicks on search ID=%s: %s", qc.clicks, id, err)
+		}
+		cancel()
+	}
+}
+
+// bulk indexes the batch and returns how many of its queries failed to be indexed
+func (q *QueryLog) bulk(batch []queuedQuery) (failed int, err error) {
+	var r bulkResponse
+
+	body, err := bulkBody(batch)
+	if err != nil {
+		return len(batch), err
+	}
+
+	ctx, span := tracing.Start(context.Background(), "queryLog.bulk")
+	defer span.End()
+	span.SetAttributes(attribute.Int("queryLog.batch", len(batch)))
+	defer func() {
+		span.SetAttributes(attribute.Int("queryLog.failed", failed))
+		tracing.Fail(span, err)
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, bulkTimeout)
+	defer cancel()
+
+	req := esapi.BulkRequest{Body: body}
+	res, err := req.Do(ctx, q.client)
+	if err != nil {
+		return len(batch), err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return len(batch), fmt.Errorf("[%s] %s", res.Status(), res.String())
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return len(batch), fmt.Errorf("Error parsing the bulk response body: %s", err)
+	}
+	if !r.Errors {
+		return 0, nil
+	}
+
+	for _, item := range r.Items {
+		for _, result := range item {
+			if result.Status > 299 {
+				if failed == 0 {
+					err = fmt.Errorf("[%d] Error indexing document ID=%s: %s: %s", result.Status, result.ID, result.Error.Type, result.Error.Reason)
+				}
+				failed++
+			}
+		}
+	}
+	return failed, err
+}
@@ -0,0 +1,183 @@
+package searching
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/conf"
+)
+
+// bulkServer stands in for Elasticsearch's _bulk API, recording the IDs of the documents it indexes
+type bulkServer struct {
+	mu       sync.Mutex
+	ids      []string
+	requests int
+	release  chan struct{}
+}
+
+func (b *bulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if b.release != nil {
+		<-b.release
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+
+	s := bufio.NewScanner(r.Body)
+	for s.Scan() {
+		var line map[string]map[string]interface{}
+		if err := json.Unmarshal(s.Bytes(), &line); err == nil && line["index"] != nil {
+			b.ids = append(b.ids, line["index"]["_id"].(string))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+}
+
+func newBulkClient(t *testing.T, b *bulkServer) (*elasticsearch.Client, func()) {
+	srv := httptest.NewServer(b)
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatalf("Unexpected error creating Elasticsearch client: %s", err)
+	}
+	return es, srv.Close
+}
+
+func TestQueryLogBatches(t *testing.T) {
+	b := &bulkServer{}
+	es, stop := newBulkClient(t, b)
+	defer stop()
+
+	ql := NewQueryLog(es, conf.QueryLogOptions{BatchSize: 2, FlushIntervalMillis: 60000}, logrus.New())
+	for _, id := range []string{"a", "b", "c"} {
+		if !ql.Log("droids", IndexQuery{SearchID: id}) {
+			t.Fatalf("query %s should have been queued", id)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ql.Close(ctx); err != nil {
+		t.Fatalf("Unexpected error closing the query log: %s", err)
+	}
+
+	// a and b are flushed as a full batch, c when the log is drained
+	if diff := cmp.Diff([]string{"a", "b", "c"}, b.ids); diff != "" {
+		t.Fatalf(diff)
+	}
+	if b.requests != 2 {
+		t.Fatalf("bulk requests - expected : 2, received : %d", b.requests)
+	}
+
+	want := QueryLogStats{QueueCapacity: defaultQueryLogQueueSize, Indexed: 3, Closed: true}
+	if diff := cmp.Diff(want, ql.Stats()); diff != "" {
+		t.Fatalf(diff)
+	}
+
+	if ql.Log("droids", IndexQuery{SearchID: "d"}) {
+		t.Fatal("queries logged after closing should be dropped")
+	}
+}
+
+func TestQueryLogDropsWhenFull(t *testing.T) {
+	b := &bulkServer{release: make(chan struct{})}
+	es, stop := newBulkClient(t, b)
+	defer stop()
+
+	ql := NewQueryLog(es, conf.QueryLogOptions{QueueSize: 1, BatchSize: 1, FlushIntervalMillis: 60000}, logrus.New())
+	dropped := testutil.ToFloat64(queryLogDropped)
+
+	// the worker takes the first query and blocks flushing it, the second fills the queue
+	ql.Log("droids", IndexQuery{SearchID: "a"})
+	deadline := time.Now().Add(5 * time.Second)
+	for ql.Stats().QueueDepth != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ql.Log("droids", IndexQuery{SearchID: "b"})
+
+	if ql.Log("droids", IndexQuery{SearchID: "c"}) {
+		t.Fatal("query should have been dropped while the queue is full")
+	}
+
+	stats := ql.Stats()
+	if stats.Dropped != 1 || stats.QueueDepth != 1 {
+		t.Fatalf("unexpected stats : %+v", stats)
+	}
+	if got := testutil.ToFloat64(queryLogDropped) - dropped; got != 1 {
+		t.Fatalf("dropped queries counted - expected : 1, received : %g", got)
+	}
+
+	close(b.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ql.Close(ctx); err != nil {
+		t.Fatalf("Unexpected error closing the query log: %s", err)
+	}
+
+	if diff := cmp.Diff([]string{"a", "b"}, b.ids); diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestQueryLogFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"errors":true,"items":[` +
+			`{"index":{"_id":"a","status":201}},` +
+			`{"index":{"_id":"b","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`))
+	}))
+	defer srv.Close()
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatalf("Unexpected error creating Elasticsearch client: %s", err)
+	}
+	failed := testutil.ToFloat64(queryLogFailed)
+
+	ql := NewQueryLog(es, conf.QueryLogOptions{BatchSize: 2, FlushIntervalMillis: 60000}, logrus.New())
+	ql.Log("droids", IndexQuery{SearchID: "a"})
+	ql.Log("droids", IndexQuery{SearchID: "b"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ql.Close(ctx); err != nil {
+		t.Fatalf("Unexpected error closing the query log: %s", err)
+	}
+
+	if stats := ql.Stats(); stats.Indexed != 1 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats : %+v", stats)
+	}
+	if got := testutil.ToFloat64(queryLogFailed) - failed; got != 1 {
+		t.Fatalf("failed queries counted - expected : 1, received : %g", got)
+	}
+}
+
+func TestBulkBody(t *testing.T) {
+	body, err := bulkBody([]queuedQuery{{index: "droids", query: IndexQuery{SearchID: "a", Query: "r2d2"}}})
+	if err != nil {
+		t.Fatalf("Unexpected error building bulk body: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("bulk body lines - expected : 2, received : %d", len(lines))
+	}
+	if diff := cmp.Diff(`{"index":{"_id":"a","_index":"droids-queries"}}`, lines[0]); diff != "" {
+		t.Fatalf(diff)
+	}
+	if diff := cmp.Diff(`{"searchId":"a","index":"","searchTerm":"r2d2","user-agent":"","date":"","latencyMillis":0}`, lines[1]); diff != "" {
+		t.Fatalf(diff)
+	}
+}
@@ -0,0 +1,421 @@
+package searching
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// The types of query rules
+const (
+	// RuleSynonyms searches for every term of the rule when the search term contains one of them
+	RuleSynonyms = "synonyms"
+	// RuleReplace replaces the terms of the rule with its replacement, e.g. k8s with kubernetes
+	RuleReplace = "replace"
+	// RuleStrip removes the terms of the rule, e.g. stop phrases such as "how do i"
+	RuleStrip = "strip"
+	// RuleRedirect sends searches of exactly one of the terms of the rule to its URL instead of searching
+	RuleRedirect = "redirect"
+)
+
+const (
+	// RulesIndex is the index query rules are stored in
+	RulesIndex    = "query-rules"
+	rulesTemplate = "query-rules"
+	// MaxRuleTerms is the most terms a rule can have
+	MaxRuleTerms = 50
+	// maxExpansions is the most queries synonyms expand a search term to
+	maxExpansions = 10
+)
+
+// ErrRuleNotFound is returned, wrapped, when an index has no rule of the name
+var ErrRuleNotFound = errors.New("rule not found")
+
+var ruleTypes = map[string]bool{RuleSynonyms: true, RuleReplace: true, RuleStrip: true, RuleRedirect: true}
+
+var rulesMapping = map[string]interface{}{
+	"index_patterns": []string{RulesIndex},
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"index":       map[string]interface{}{"type": "keyword"},
+			"name":        map[string]interface{}{"type": "keyword"},
+			"type":        map[string]interface{}{"type": "keyword"},
+			"terms":       map[string]interface{}{"type": "keyword"},
+			"replacement": map[string]interface{}{"type": "keyword"},
+			"url":         map[string]interface{}{"type": "keyword"},
+			"updated":     map[string]interface{}{"type": "date"},
+		},
+	},
+}
+
+// Rule rewrites the search terms of the searches logged under its index before they are searched. Terms
+// are matched as whole words, regardless of case. A search is redirected when it's exactly one of the terms
+// of a redirect rule, then strip and replace rules are applied in the order of their names, and the
+// rewritten term is expanded with the synonyms it contains.
+type Rule struct {
+	Index string `json:"index"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	// Terms are the equivalent terms of synonyms, or the words and phrases the other rules match
+	Terms []string `json:"terms"`
+	// Replacement replaces the terms of a replace rule
+	Replacement string `json:"replacement,omitempty"`
+	// URL is where a redirect rule sends searches, a path of the site or a URL on an allowed host
+	URL     string    `json:"url,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// Rules lists the rules of an index
+type Rules struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rewriter manages the rules rewriting search terms
+type Rewriter interface {
+	Rules(ctx context.Context, index string) (*Rules, error)
+	PutRule(ctx context.Context, r Rule) (*Rule, error)
+	DeleteRule(ctx context.Context, index string, name string) error
+}
+
+// Validate checks that the rule can be applied. Its errors are of kind ErrBadRequest.
+func (r Rule) Validate() error {
+	return invalid(r.validate())
+}
+
+func (r Rule) validate() error {
+	if r.Index == "" || strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("rules need an index and a name")
+	}
+	if !ruleTypes[r.Type] {
+		return fmt.Errorf("rule type must be synonyms, replace, strip or redirect, got %q", r.Type)
+	}
+
+	min := 1
+	if r.Type == RuleSynonyms {
+		min = 2
+	}
+	if len(r.Terms) < min || len(r.Terms) > MaxRuleTerms {
+		return fmt.Errorf("%s rules need between %d and %d terms, got %d", r.Type, min, MaxRuleTerms, len(r.Terms))
+	}
+	for _, t := range r.Terms {
+		if normalizeQuery(t) == "" {
+			return fmt.Errorf("rule terms can't be empty")
+		}
+	}
+
+	if (r.Type == RuleReplace) != (normalizeQuery(r.Replacement) != "") {
+		return fmt.Errorf("replace rules, and only them, need a replacement")
+	}
+	if (r.Type == RuleRedirect) != (r.URL != "") {
+		return fmt.Errorf("redirect rules, and only them, need a URL")
+	}
+	if r.URL != "" {
+		if _, err := redirectURL(r.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateRedirect checks that a redirect rule sends searches to a path of the site, or to one of the
+// hosts. Validate doesn't know the hosts, so rules are checked with both before they're stored. Its errors
+// are of kind ErrBadRequest.
+func (r Rule) ValidateRedirect(hosts []string) error {
+	if r.URL == "" {
+		return nil
+	}
+	u, err := redirectURL(r.URL)
+	if err != nil {
+		return invalid(err)
+	}
+	if u.Host == "" {
+		return nil
+	}
+	for _, h := range hosts {
+		if strings.EqualFold(u.Hostname(), h) {
+			return nil
+		}
+	}
+	return invalid(fmt.Errorf("redirect URL must be a path of the site, or on an allowed host, got %q", r.URL))
+}
+
+// redirectURL parses the URL of a redirect rule, an absolute http(s) URL or a path of the site. Paths
+// starting with // or holding a backslash are refused, since browsers follow them to another host.
+func redirectURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil || strings.Contains(raw, `\`):
+	case (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.User == nil:
+		return u, nil
+	case u.Scheme == "" && u.Host == "" && strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//"):
+		return u, nil
+	}
+	return nil, fmt.Errorf("redirect URL must be an http(s) URL or a path, got %q", raw)
+}
+
+// ruleID returns the ID of the document storing the rule
+func ruleID(index string, name string) string {
+	h := sha1.Sum([]byte(index + "\x00" + name))
+	return hex.EncodeToString(h[:])
+}
+
+// PutRulesTemplate installs the index template mapping the index rules are stored in
+func PutRulesTemplate(es *elasticsearch.Client) error {
+	body, err := json.Marshal(rulesMapping)
+	if err != nil {
+		return err
+	}
+
+	res, err := es.Indices.PutTemplate(rulesTemplate, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error installing the query rules index template: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("[%s] Error installing the query rules index template: %s", res.Status(), res.String())
+	}
+	return nil
+}
+
+// GetRules returns the rules of the index, sorted by name
+func GetRules(ctx context.Context, es *elasticsearch.Client, index string) (*Rules, error) {
+	rules := &Rules{Rules: []Rule{}}
+	err := listAll(ctx, es, RulesIndex, index, "name", func(source json.RawMessage) error {
+		var r Rule
+		if err := json.Unmarshal(source, &r); err != nil {
+			return err
+		}
+		rules.Rules = append(rules.Rules, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// cachedRules returns the rules of the index from the cache, which may be nil
+func cachedRules(ctx context.Context, es *elasticsearch.Client, cache *indexCache, index string) (*Rules, error) {
+	rules, err := cache.get(index, func() (interface{}, error) {
+		return GetRules(ctx, es, index)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules.(*Rules), nil
+}
+
+// PutRule stores the rule, replacing the rule of the same name. The rule applies to searches right away.
+func PutRule(ctx context.Context, es *elasticsearch.Client, r Rule) (*Rule, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	r.Updated = time.Now().UTC()
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := esapi.IndexRequest{
+		Index:      RulesIndex,
+		DocumentID: ruleID(r.Index, r.Name),
+		Body:       bytes.NewReader(body),
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, es)
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res, fmt.Sprintf("Error storing rule %q", r.Name))
+	}
+	return &r, nil
+}
+
+// DeleteRule deletes the rule of the index
+func DeleteRule(ctx context.Context, es *elasticsearch.Client, index string, name string) error {
+	req := esapi.DeleteRequest{
+		Index:      RulesIndex,
+		DocumentID: ruleID(index, name),
+		Refresh:    "true",
+	}
+	res, err := req.Do(ctx, es)
+	if err != nil {
+		return requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: no rule %q in %s", ErrRuleNotFound, name, index)
+	}
+	if res.IsError() {
+		return responseError(res, fmt.Sprintf("Error deleting rule %q", name))
+	}
+	return nil
+}
+
+// rewrite applies the rules to the search term of the search. The search is redirected, or searches the
+// rewritten term and its expansions instead. Only the free words of advanced searches are rewritten, while
+// redirects apply to their whole search term.
+func rewrite(s SearchRequest, rules []Rule) SearchRequest {
+	for _, r := range rules {
+		if r.Type != RuleRedirect {
+			continue
+		}
+		for _, t := range r.Terms {
+			if normalizeQuery(t) == normalizeQuery(s.SearchTerm) {
+				s.redirect = r.URL
+				return s
+			}
+		}
+	}
+
+	if s.syntax != nil {
+		// the rest of an advanced search term is matched by the clauses parsed from it
+		s.SearchTerm = s.syntax.text
+	}
+	s.original = s.SearchTerm
+
+	words := strings.Fields(s.SearchTerm)
+	for _, r := range rules {
+		if r.Type != RuleReplace && r.Type != RuleStrip {
+			continue
+		}
+		var with []string
+		if r.Type == RuleReplace {
+			with = strings.Fields(r.Replacement)
+		}
+		for _, t := range r.Terms {
+			words = replaceWords(words, strings.Fields(t), with)
+		}
+	}
+	// a term that is all stop phrases is searched as it is
+	if len(words) > 0 {
+		s.SearchTerm = strings.Join(words, " ")
+	}
+
+	expansions := [][]string{strings.Fields(s.SearchTerm)}
+	for _, r := range rules {
+		if r.Type == RuleSynonyms {
+			expansions = expand(expansions, r.Terms)
+		}
+	}
+	s.expansions = nil
+	// templates are given a single query
+	if len(expansions) > 1 && (s.profile == nil || s.profile.Template == "") {
+		for _, e := range expansions {
+			s.expansions = append(s.expansions, strings.Join(e, " "))
+		}
+	}
+	return s
+}
+
+// expand adds to the queries their variants with each of the synonyms in place of the first one they
+// contain, up to maxExpansions queries
+func expand(queries [][]string, synonyms []string) [][]string {
+	var found []string
+	for _, t := range synonyms {
+		if t := strings.Fields(t); indexWords(queries[0], t) >= 0 {
+			found = t
+			break
+		}
+	}
+	if found == nil {
+		return queries
+	}
+
+	expanded := append([][]string{}, queries...)
+	for _, q := range queries {
+		for _, t := range synonyms {
+			v := replaceWords(q, found, strings.Fields(t))
+			if len(expanded) < maxExpansions && !containsWords(expanded, v) {
+				expanded = append(expanded, v)
+			}
+		}
+	}
+	return expanded
+}
+
+// replaceWords returns the words with every occurrence of the phrase replaced
+func replaceWords(words []string, phrase []string, with []string) []string {
+	var replaced []string
+	for {
+		i := indexWords(words, phrase)
+		if i < 0 {
+			return append(replaced, words...)
+		}
+		replaced = append(append(replaced, words[:i]...), with...)
+		words = words[i+len(phrase):]
+	}
+}
+
+// indexWords returns the position of the phrase in the words, regardless of case, or -1
+func indexWords(words []string, phrase []string) int {
+	if len(phrase) == 0 {
+		return -1
+	}
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j, p := range phrase {
+			if !strings.EqualFold(words[i+j], p) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsWords(queries [][]string, words []string) bool {
+	for _, q := range queries {
+		if strings.EqualFold(strings.Join(q, " "), strings.Join(words, " ")) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteResults tells what the search searched for, when its rules rewrote it
+func rewriteResults(r *Results, s SearchRequest) {
+	if s.redirect != "" {
+		r.Redirect = s.redirect
+		return
+	}
+	if s.original != "" && s.SearchTerm != s.original {
+		r.RewrittenQuery = s.SearchTerm
+	}
+	r.ExpandedQueries = s.expansions
+}
+
+// expandedMatch returns the match of the search term, or the best match among its expansions
+func expandedMatch(s SearchRequest) map[string]interface{} {
+	if len(s.expansions) == 0 {
+		return multiMatch(s)
+	}
+
+	var queries []interface{}
+	for _, e := range s.expansions {
+		q := s
+		q.SearchTerm = e
+		queries = append(queries, multiMatch(q))
+	}
+	return map[string]interface{}{"dis_max": map[string]interface{}{"queries": queries}}
+}
@@ -0,0 +1,319 @@
+package searching
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+)
+
+func TestRuleValidate(t *testing.T) {
+	tests := map[string]struct {
+		rule Rule
+		err  string
+	}{
+		"synonyms":         {rule: Rule{Index: "docs", Name: "k8s", Type: RuleSynonyms, Terms: []string{"k8s", "kubernetes"}}},
+		"replace":          {rule: Rule{Index: "docs", Name: "k8s", Type: RuleReplace, Terms: []string{"k8s"}, Replacement: "kubernetes"}},
+		"strip":            {rule: Rule{Index: "docs", Name: "stop", Type: RuleStrip, Terms: []string{"how do i"}}},
+		"redirect":         {rule: Rule{Index: "docs", Name: "pricing", Type: RuleRedirect, Terms: []string{"pricing"}, URL: "/pricing"}},
+		"redirect away":    {rule: Rule{Index: "docs", Name: "pricing", Type: RuleRedirect, Terms: []string{"pricing"}, URL: "https://example.com/pricing"}},
+		"no name":          {rule: Rule{Index: "docs", Type: RuleStrip, Terms: []string{"the"}}, err: "rules need an index and a name"},
+		"unknown type":     {rule: Rule{Index: "docs", Name: "a", Type: "boost", Terms: []string{"the"}}, err: `rule type must be synonyms, replace, strip or redirect, got "boost"`},
+		"single synonym":   {rule: Rule{Index: "docs", Name: "a", Type: RuleSynonyms, Terms: []string{"k8s"}}, err: "synonyms rules need between 2 and 50 terms, got 1"},
+		"empty term":       {rule: Rule{Index: "docs", Name: "a", Type: RuleStrip, Terms: []string{" "}}, err: "rule terms can't be empty"},
+		"no replacement":   {rule: Rule{Index: "docs", Name: "a", Type: RuleReplace, Terms: []string{"k8s"}}, err: "replace rules, and only them, need a replacement"},
+		"misplaced url":    {rule: Rule{Index: "docs", Name: "a", Type: RuleStrip, Terms: []string{"the"}, URL: "/the"}, err: "redirect rules, and only them, need a URL"},
+		"relative url":     {rule: Rule{Index: "docs", Name: "a", Type: RuleRedirect, Terms: []string{"a"}, URL: "pricing"}, err: `redirect URL must be an http(s) URL or a path, got "pricing"`},
+		"javascript url":   {rule: Rule{Index: "docs", Name: "a", Type: RuleRedirect, Terms: []string{"a"}, URL: "javascript:alert(1)"}, err: `redirect URL must be an http(s) URL or a path, got "javascript:alert(1)"`},
+		"scheme relative":  {rule: Rule{Index: "docs", Name: "a", Type: RuleRedirect, Terms: []string{"a"}, URL: "//evil.example"}, err: `redirect URL must be an http(s) URL or a path, got "//evil.example"`},
+		"backslash":        {rule: Rule{Index: "docs", Name: "a", Type: RuleRedirect, Terms: []string{"a"}, URL: `/\evil.example`}, err: `redirect URL must be an http(s) URL or a path, got "/\\evil.example"`},
+		"user info":        {rule: Rule{Index: "docs", Name: "a", Type: RuleRedirect, Terms: []string{"a"}, URL: "https://example.com@evil.example"}, err: `redirect URL must be an http(s) URL or a path, got "https://example.com@evil.example"`},
+		"too many synonym": {rule: Rule{Index: "docs", Name: "a", Type: RuleSynonyms, Terms: make([]string, 51)}, err: "synonyms rules need between 2 and 50 terms, got 51"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.rule.Validate()
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+				if !errors.Is(err, ErrBadRequest) {
+					t.Fatalf("error should be a bad request, received : %v", err)
+				}
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestRuleValidateRedirect(t *testing.T) {
+	hosts := []string{"shop.example.com"}
+	redirect := func(url string) Rule {
+		return Rule{Index: "docs", Name: "a", Type: RuleRedirect, Terms: []string{"a"}, URL: url}
+	}
+
+	tests := map[string]struct {
+		rule Rule
+		err  string
+	}{
+		"path":            {rule: redirect("/pricing?plan=team")},
+		"allowed host":    {rule: redirect("https://shop.example.com/droids")},
+		"host case":       {rule: redirect("https://SHOP.example.com:8443/droids")},
+		"no redirect":     {rule: Rule{Index: "docs", Name: "a", Type: RuleStrip, Terms: []string{"the"}}},
+		"other host":      {rule: redirect("https://evil.example"), err: `redirect URL must be a path of the site, or on an allowed host, got "https://evil.example"`},
+		"subdomain":       {rule: redirect("https://evil.shop.example.com/"), err: `redirect URL must be a path of the site, or on an allowed host, got "https://evil.shop.example.com/"`},
+		"scheme relative": {rule: redirect("//evil.example"), err: `redirect URL must be an http(s) URL or a path, got "//evil.example"`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.rule.ValidateRedirect(hosts)
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+				if !errors.Is(err, ErrBadRequest) {
+					t.Fatalf("error should be a bad request, received : %v", err)
+				}
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	rules := []Rule{
+		{Name: "a-stop", Type: RuleStrip, Terms: []string{"how do i", "please"}},
+		{Name: "b-k8s", Type: RuleReplace, Terms: []string{"k8s", "kube"}, Replacement: "kubernetes"},
+		{Name: "c-pricing", Type: RuleRedirect, Terms: []string{"Pricing", "price list"}, URL: "/pricing"},
+		{Name: "d-deploy", Type: RuleSynonyms, Terms: []string{"deploy", "release", "ship it"}},
+		{Name: "e-pod", Type: RuleSynonyms, Terms: []string{"pod", "container"}},
+	}
+
+	tests := map[string]struct {
+		term       string
+		rewritten  string
+		expansions []string
+		redirect   string
+	}{
+		"untouched":      {term: "Nodes", rewritten: "Nodes"},
+		"replace":        {term: "K8S nodes", rewritten: "kubernetes nodes"},
+		"strip":          {term: "How do I scale please", rewritten: "scale"},
+		"all stop":       {term: "please", rewritten: "please"},
+		"whole words":    {term: "kubectl", rewritten: "kubectl"},
+		"redirect":       {term: " price  LIST ", redirect: "/pricing"},
+		"redirect exact": {term: "pricing plans", rewritten: "pricing plans"},
+		"synonyms": {
+			term:       "how do i deploy k8s",
+			rewritten:  "deploy kubernetes",
+			expansions: []string{"deploy kubernetes", "release kubernetes", "ship it kubernetes"},
+		},
+		"phrase synonym": {
+			term:       "ship it",
+			rewritten:  "ship it",
+			expansions: []string{"ship it", "deploy", "release"},
+		},
+		"several synonyms": {
+			term:       "deploy pod",
+			rewritten:  "deploy pod",
+			expansions: []string{"deploy pod", "release pod", "ship it pod", "deploy container", "release container", "ship it container"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := rewrite(SearchRequest{SearchTerm: tc.term}, rules)
+			if s.redirect != tc.redirect {
+				t.Fatalf("redirect - expected : %q, received : %q", tc.redirect, s.redirect)
+			}
+			if tc.redirect != "" {
+				return
+			}
+			if diff := cmp.Diff(tc.rewritten, s.SearchTerm); diff != "" {
+				t.Fatalf(diff)
+			}
+			if diff := cmp.Diff(tc.expansions, s.expansions); diff != "" {
+				t.Fatalf(diff)
+			}
+			if s.original != tc.term {
+				t.Fatalf("original term should be kept, received : %q", s.original)
+			}
+		})
+	}
+}
+
+func TestRewriteMaxExpansions(t *testing.T) {
+	rules := []Rule{
+		{Name: "a", Type: RuleSynonyms, Terms: []string{"a", "b", "c", "d"}},
+		{Name: "x", Type: RuleSynonyms, Terms: []string{"x", "y", "z", "w"}},
+	}
+	s := rewrite(SearchRequest{SearchTerm: "a x"}, rules)
+	if len(s.expansions) != maxExpansions || s.expansions[0] != "a x" {
+		t.Fatalf("expected %d expansions starting with the term, received : %v", maxExpansions, s.expansions)
+	}
+}
+
+func TestExpandedMatch(t *testing.T) {
+	s := SearchRequest{SearchTerm: "deploy", Fields: []string{"title"}, expansions: []string{"deploy", "release"}}
+	expected := map[string]interface{}{"dis_max": map[string]interface{}{"queries": []interface{}{
+		map[string]interface{}{"multi_match": map[string]interface{}{"query": "deploy", "fields": []string{"title"}}},
+		map[string]interface{}{"multi_match": map[string]interface{}{"query": "release", "fields": []string{"title"}}},
+	}}}
+	if diff := cmp.Diff(expected, buildQuery(s, nil).Query); diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestRules(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Index("droids", "1", `{"meta":{"title":"R2-D2","description":"An astromech droid"}}`)
+	srv.Index("droids", "2", `{"meta":{"title":"C-3PO","description":"A protocol droid"}}`)
+	es := srv.Client()
+	ctx := context.Background()
+
+	for _, r := range []Rule{
+		{Index: "droids", Name: "astromech", Type: RuleReplace, Terms: []string{"r2"}, Replacement: "astromech"},
+		{Index: "droids", Name: "shop", Type: RuleRedirect, Terms: []string{"buy a droid"}, URL: "/shop"},
+	} {
+		if _, err := PutRule(ctx, es, r); err != nil {
+			t.Fatalf("Unexpected error storing rule: %s", err)
+		}
+	}
+	rules, err := GetRules(ctx, es, "droids")
+	if err != nil {
+		t.Fatalf("Unexpected error getting rules: %s", err)
+	}
+	if len(rules.Rules) != 2 || rules.Rules[0].Updated.IsZero() {
+		t.Fatalf("expected the stored rules, received : %+v", rules)
+	}
+
+	req, _ := http.NewRequest("GET", "/search", nil)
+	res, err := Search(es, nil, req, SearchRequest{Index: "droids", SearchTerm: "R2 droid"}, logrus.New())
+	if err != nil {
+		t.Fatalf("Unexpected error searching: %s", err)
+	}
+	if res.RewrittenQuery != "astromech droid" || res.Redirect != "" {
+		t.Fatalf("search should be rewritten, received : %+v", res)
+	}
+	if diff := cmp.Diff([]string{"1"}, hitIDs(res)); diff != "" {
+		t.Fatalf(diff)
+	}
+
+	searched := len(srv.RequestsTo("", "/droids/_search"))
+	res, err = Search(es, nil, req, SearchRequest{Index: "droids", SearchTerm: "Buy a droid"}, logrus.New())
+	if err != nil {
+		t.Fatalf("Unexpected error searching: %s", err)
+	}
+	if res.Redirect != "/shop" || len(res.Hits.Results) != 0 {
+		t.Fatalf("search should be redirected, received : %+v", res)
+	}
+	if len(srv.RequestsTo("", "/droids/_search")) != searched {
+		t.Fatalf("redirected searches should not search")
+	}
+
+	if err := DeleteRule(ctx, es, "droids", "shop"); err != nil {
+		t.Fatalf("Unexpected error deleting rule: %s", err)
+	}
+	if err := DeleteRule(ctx, es, "droids", "shop"); !errors.Is(err, ErrRuleNotFound) {
+		t.Fatalf("deleting twice should fail with rule not found, received : %v", err)
+	}
+}
+
+func TestRulesUnavailable(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Index("droids", "1", `{"meta":{"title":"R2-D2","description":"An astromech droid"}}`)
+	srv.Fail("", "/"+RulesIndex+"/_search", 503, "unavailable_shards_exception", "no shards")
+
+	req, _ := http.NewRequest("GET", "/search", nil)
+	res, err := Search(srv.Client(), nil, req, SearchRequest{Index: "droids", SearchTerm: "r2-d2"}, logrus.New())
+	if err != nil {
+		t.Fatalf("searches should not fail without their rules, received : %s", err)
+	}
+	if diff := cmp.Diff([]string{"1"}, hitIDs(res)); diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestElasticCachesRules(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Index("droids", "1", `{"meta":{"title":"R2-D2","description":"An astromech droid"}}`)
+	e := NewElastic(srv.Client(), nil, logrus.New())
+	ctx := context.Background()
+
+	search := func() *Results {
+		req, _ := http.NewRequest("GET", "/search", nil)
+		res, err := e.Search(req, SearchRequest{Index: "droids", SearchTerm: "buy a droid"})
+		if err != nil {
+			t.Fatalf("Unexpected error searching: %s", err)
+		}
+		return res
+	}
+
+	search()
+	search()
+	if n := len(srv.RequestsTo("", "/"+RulesIndex+"/_search")); n != 1 {
+		t.Fatalf("rules should be fetched once, received : %d requests", n)
+	}
+
+	// rules stored through the searcher apply to its searches right away
+	if _, err := e.PutRule(ctx, Rule{Index: "droids", Name: "shop", Type: RuleRedirect, Terms: []string{"buy a droid"}, URL: "/shop"}); err != nil {
+		t.Fatalf("Unexpected error storing rule: %s", err)
+	}
+	if res := search(); res.Redirect != "/shop" {
+		t.Fatalf("search should be redirected, received : %+v", res)
+	}
+	if err := e.DeleteRule(ctx, "droids", "shop"); err != nil {
+		t.Fatalf("Unexpected error deleting rule: %s", err)
+	}
+	if res := search(); res.Redirect != "" {
+		t.Fatalf("search should no longer be redirected, received : %+v", res)
+	}
+}
+
+func TestGetRulesPages(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	defer func(size int) { listPageSize = size }(listPageSize)
+	listPageSize = 2
+	pages := []string{
+		`{"hits":{"hits":[{"_source":{"name":"a"},"sort":["a"]},{"_source":{"name":"b"},"sort":["b"]}]}}`,
+		`{"hits":{"hits":[{"_source":{"name":"c"},"sort":["c"]},{"_source":{"name":"d"},"sort":["d"]}]}}`,
+		`{"hits":{"hits":[]}}`,
+	}
+	for _, p := range pages {
+		srv.HandleOnce("", "/"+RulesIndex+"/_search", func(body string) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(body))
+			}
+		}(p))
+	}
+
+	rules, err := GetRules(context.Background(), srv.Client(), "droids")
+	if err != nil {
+		t.Fatalf("Unexpected error getting rules: %s", err)
+	}
+	var names []string
+	for _, r := range rules.Rules {
+		names = append(names, r.Name)
+	}
+	if diff := cmp.Diff([]string{"a", "b", "c", "d"}, names); diff != "" {
+		t.Fatalf(diff)
+	}
+	searches := srv.RequestsTo("", "/"+RulesIndex+"/_search")
+	if len(searches) != 3 || !strings.Contains(string(searches[2].Body), `"search_after":["d"]`) {
+		t.Fatalf("every page should be fetched, received : %+v", searches)
+	}
+}
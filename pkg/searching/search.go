@@ -3,186 +3,420 @@ package searching
 import (
 	"bytes"
 	"context"
-	"crypto/md5"
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/pkg/metrics"
+	"github.com/wambozi/elastic-search-api/m/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// searchTook and searchDuration compare the time Elasticsearch spends on searches with the time they take
+// the API, which adds the network and encoding the queries and decoding the results
+var (
+	searchTook = promauto.With(metrics.Registry).NewHistogram(prometheus.HistogramOpts{
+		Name: "search_took_seconds",
+		Help: "Time Elasticsearch reported spending on searches that succeeded.",
+	})
+	searchDuration = promauto.With(metrics.Registry).NewHistogram(prometheus.HistogramOpts{
+		Name: "search_duration_seconds",
+		Help: "Wall time of the searches that succeeded, from building the query to decoding the results.",
+	})
 )
 
 // SearchRequest represents a search request on the POST /search route
 type SearchRequest struct {
-	SearchTerm string   `json:"searchTerm"`
-	Index      string   `json:"index"`
-	Fields     []string `json:"fields"`
+	SearchTerm string `json:"searchTerm"`
+	// Index and Indices name the indices to search, which can be comma separated in Index. Names can be
+	// patterns such as crawler-*, or logical names resolved by an IndexMap.
+	Index   string   `json:"index"`
+	Indices []string `json:"indices,omitempty"`
+	// IndicesBoost multiplies the scores of the hits from the named indices
+	IndicesBoost map[string]float64 `json:"indicesBoost,omitempty"`
+	// Fields are searched instead of those of the profile
+	Fields []string `json:"fields"`
+	// Profile names the search profile matching the search term
+	Profile   string        `json:"profile,omitempty"`
+	Page      int           `json:"page,omitempty"`
+	Size      int           `json:"size,omitempty"`
+	Cursor    string        `json:"cursor,omitempty"`
+	Filters   []Filter      `json:"filters,omitempty"`
+	Facets    []Facet       `json:"facets,omitempty"`
+	Highlight *Highlight    `json:"highlight,omitempty"`
+	Source    *SourceFilter `json:"source,omitempty"`
+	// Sort orders the hits, by score when empty
+	Sort []SortField `json:"sort,omitempty"`
+	// SpellCheck looks for a correction of the search term when it finds nothing, and AutoCorrect
+	// searches for that correction instead
+	SpellCheck  bool `json:"spellcheck,omitempty"`
+	AutoCorrect bool `json:"autoCorrect,omitempty"`
+	// Advanced parses the search term as the query syntax, see QuerySyntax
+	Advanced bool `json:"advanced,omitempty"`
+
+	// resolved holds the indices behind each name, once resolved by an IndexMap
+	resolved map[string][]string
+	// sortTypes holds the types of the sorted fields, once checked by a SortAllowlist
+	sortTypes map[string]string
+	// tiebreaker is the field sorted on last, once applied from Tiebreakers
+	tiebreaker string
+	// profile is the profile named by Profile, once applied from Profiles
+	profile *Profile
+	// pins are the IDs of the documents pinned to the search term
+	pins []string
+	// original is the search term before rules rewrote it, expansions the queries its synonyms expand
+	// it to, and redirect where a rule sends the search instead
+	original   string
+	expansions []string
+	redirect   string
+	// syntax is the search term of an advanced search, once parsed by a QuerySyntax
+	syntax *parsedQuery
+}
+
+// Validate checks that the request can be turned into a query Elasticsearch will accept. Its errors are
+// of kind ErrBadRequest.
+func (s SearchRequest) Validate() error {
+	return invalid(s.validate())
+}
+
+func (s SearchRequest) validate() error {
+	if err := s.validateIndices(); err != nil {
+		return err
+	}
+	if err := validatePagination(s); err != nil {
+		return err
+	}
+	for _, f := range s.Sort {
+		if err := f.validate(); err != nil {
+			return err
+		}
+	}
+	for _, f := range s.Filters {
+		if err := f.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := validateFacets(s.Facets); err != nil {
+		return err
+	}
+
+	if err := s.Highlight.validate(); err != nil {
+		return err
+	}
+
+	return s.Source.validate()
 }
 
 // IndexQuery represents the document indexed in Elastic that contains the search term and relevant info
 type IndexQuery struct {
-	Query     string `json:"searchTerm"`
-	UserAgent string `json:"user-agent"`
-	Date      string `json:"date"`
+	SearchID      string   `json:"searchId"`
+	Index         string   `json:"index"`
+	Indices       []string `json:"indices,omitempty"`
+	Query         string   `json:"searchTerm"`
+	UserAgent     string   `json:"user-agent"`
+	Date          string   `json:"date"`
+	Hits          *int     `json:"hits,omitempty"`
+	Took          *int     `json:"took,omitempty"`
+	LatencyMillis int64    `json:"latencyMillis"`
+	Page          int      `json:"page,omitempty"`
+	Filters       []string `json:"filters,omitempty"`
+	// Clicks and ClickedIDs are those recorded while the query was queued, before it was indexed
+	Clicks     int      `json:"clicks,omitempty"`
+	ClickedIDs []string `json:"clickedIds,omitempty"`
+	// RewrittenQuery and Redirect record how the rules of the index rewrote the search term
+	RewrittenQuery string `json:"rewrittenQuery,omitempty"`
+	Redirect       string `json:"redirect,omitempty"`
 }
 
 // Results represents the Results response coming from Elasticsearch when performing a query
 type Results struct {
 	Took     int  `json:"took,omitempty"`
 	TimedOut bool `json:"timed_out,omitempty"`
-	Shards   struct {
+	// TimedOut and Shards tell whether the hits are partial, because some shards didn't answer in time
+	Shards struct {
 		Total      int `json:"total"`
 		Successful int `json:"successful"`
 		Skipped    int `json:"skipped"`
 		Failed     int `json:"failed"`
-	}
+	} `json:"_shards"`
 	Hits struct {
 		Total struct {
 			Value    int    `json:"value"`
 			Relation string `json:"relation"`
 		}
 		MaxScore float64 `json:"max_score,omitempty"`
-		Results  []struct {
-			Index  string  `json:"_index"`
-			Type   string  `json:"_type"`
-			ID     string  `json:"_id"`
-			Score  float64 `json:"_score"`
-			Source struct {
-				Meta struct {
-					OgImage     string `json:"ogimage,omitempty"`
-					Title       string `json:"title"`
-					Description string `json:"Description"`
-					Keywords    string `json:"Keywords"`
-				} `json:"meta"`
-				URI string `json:"uri"`
-			} `json:"_source"`
-		} `json:"hits"`
+		Results  []Hit   `json:"hits"`
 	} `json:"hits"`
+	// Aggregations holds the raw aggregations until they are normalized into Facets
+	Aggregations map[string]json.RawMessage `json:"aggregations,omitempty"`
+	Facets       []FacetResult              `json:"facets,omitempty"`
+	Pagination   *Pagination                `json:"pagination,omitempty"`
+	Correction   *Correction                `json:"correction,omitempty"`
+	// SearchID identifies the logged query, and LogIndex the index it's logged under, for recording
+	// clicks on its hits
+	SearchID string `json:"searchId,omitempty"`
+	LogIndex string `json:"logIndex,omitempty"`
+	// RewrittenQuery is the search term searched instead of the one given, and ExpandedQueries the
+	// queries its synonyms expanded it to, when rules rewrote it
+	RewrittenQuery  string   `json:"rewrittenQuery,omitempty"`
+	ExpandedQueries []string `json:"expandedQueries,omitempty"`
+	// Redirect is where a rule sends the search, instead of searching
+	Redirect string `json:"redirect,omitempty"`
 }
 
-// Search takes an elasticsearch Client and SearchRequest and returns results for that request
-func Search(elasticClient *elasticsearch.Client, r *http.Request, s SearchRequest, logger *logrus.Logger) *Results {
-	go func(es *elasticsearch.Client, logger *logrus.Logger, i string, req *http.Request, q string) {
-		// we don't care about a successful index response, so ignore it
-		_, err := indexQuery(es, i, req, q)
-		if err != nil {
-			logger.Error(err)
-		}
-	}(elasticClient, logger, s.Index, r, s.SearchTerm)
+// Hit represents a single document matched by a query
+type Hit struct {
+	Index string  `json:"_index"`
+	Type  string  `json:"_type"`
+	ID    string  `json:"_id"`
+	Score float64 `json:"_score"`
+	// Source is the document as stored, unless a projection is registered for the index
+	Source    json.RawMessage     `json:"_source,omitempty"`
+	Highlight map[string][]string `json:"highlight,omitempty"`
+	Sort      []interface{}       `json:"sort,omitempty"`
+	// SearchedAs is the name the index of the hit was searched by, when it isn't the index's own
+	SearchedAs string `json:"searchedAs,omitempty"`
+	// Pinned tells the hit was promoted by a pin rather than matched
+	Pinned bool `json:"pinned,omitempty"`
+}
 
-	res, err := searchQuery(elasticClient, s.Index, s.SearchTerm, s.Fields)
-	if err != nil {
-		logger.Error(err)
-	}
-	return res
+// Search takes an elasticsearch Client and SearchRequest and returns results for that request. The search
+// term is logged to the QueryLog, unless it is nil. Elasticsearch is queried within the request's context,
+// so the search stops when the client goes away or the context's deadline passes. The rules of the index
+// rewrite the search term before it is searched, or redirect the search, which then finds nothing. Errors
+// are of one of the kinds of Error. The pins and rules of the index are fetched for every search, while an
+// Elastic caches them.
+func Search(elasticClient *elasticsearch.Client, ql *QueryLog, r *http.Request, s SearchRequest, logger *logrus.Logger) (*Results, error) {
+	e := &Elastic{Client: elasticClient, QueryLog: ql, Log: logger}
+	return e.search(r, s)
 }
 
-func indexQuery(es *elasticsearch.Client, i string, req *http.Request, q string) (response string, err error) {
-	var (
-		buf bytes.Buffer
-		r   map[string]interface{}
-	)
+func (e *Elastic) search(r *http.Request, s SearchRequest) (*Results, error) {
+	elasticClient, ql, logger := e.Client, e.QueryLog, e.Log
+
+	ctx, span := tracing.Start(r.Context(), "searching.Search")
+	defer span.End()
+	r = r.WithContext(ctx)
+	span.SetAttributes(attribute.String("search.index", s.logIndex()))
 
-	iq := IndexQuery{}
-	iq.Query = q
-	iq.UserAgent = req.Header.Get("user-agent")
-	iq.Date = time.Now().Format("2006-01-02 15:04:05")
+	iq := newSearchQuery(r, s)
+	start := time.Now()
 
-	if err := json.NewEncoder(&buf).Encode(iq); err != nil {
-		return "", err
+	if s.profile == nil || s.profile.Template == "" {
+		// like corrections, pins are extras a search can do without
+		pins, perr := pinnedIDs(r.Context(), elasticClient, e.pins, s)
+		if perr != nil {
+			logger.Warnf("Error getting the pins of %q: %s", s.SearchTerm, perr)
+		}
+		s.pins = pins
+	}
+	rules, rerr := cachedRules(r.Context(), elasticClient, e.rules, s.logIndex())
+	if rerr != nil {
+		// searching the term as it is beats failing the search
+		logger.Warnf("Error getting the rules of %s: %s", s.logIndex(), rerr)
+		rules = &Rules{}
 	}
+	s = rewrite(s, rules.Rules)
 
-	qb := []byte(q)
-	tb := []byte(iq.Date)
-	idBytes := md5.Sum(append(qb, tb...))
-	idHash := hex.EncodeToString(idBytes[:])
-	indexReq := esapi.IndexRequest{
-		Index:      i + "-queries",
-		DocumentID: idHash,
-		Body:       strings.NewReader(buf.String()),
-		Refresh:    "true",
+	var (
+		res *Results
+		err error
+	)
+	if s.redirect != "" {
+		res = &Results{}
+	} else {
+		queried := time.Now()
+		if res, err = searchQuery(r.Context(), elasticClient, s); err == nil {
+			searchDuration.Observe(time.Since(queried).Seconds())
+			searchTook.Observe(float64(res.Took) / 1000)
+		}
+	}
+	if err == nil && s.SpellCheck && s.redirect == "" && s.SearchTerm != "" && res.Hits.Total.Value == 0 {
+		// the search itself succeeded, so a failed correction only loses the "did you mean"
+		var cerr error
+		if s, res, cerr = correct(r.Context(), elasticClient, s, rules.Rules, res); cerr != nil {
+			logger.Warnf("Error correcting %q: %s", s.SearchTerm, cerr)
+		}
 	}
 
-	// Perform the request with the client.
-	res, err := indexReq.Do(context.Background(), es)
-	if err != nil {
-		return "", err
+	// the query is logged once the search is done so its outcome can be recorded with it, and searches
+	// that failed are logged without hits
+	iq.LatencyMillis = time.Since(start).Milliseconds()
+	if s.Cursor == "" {
+		iq.Page = pageNumber(s)
+	}
+	for _, f := range s.Filters {
+		iq.Filters = append(iq.Filters, f.String())
+	}
+	if res != nil {
+		iq.Hits = &res.Hits.Total.Value
+		iq.Took = &res.Took
+		res.SearchID, res.LogIndex = iq.SearchID, iq.Index
+		rewriteResults(res, s)
+		iq.RewrittenQuery, iq.Redirect = res.RewrittenQuery, res.Redirect
+		span.SetAttributes(attribute.Int("search.hits", res.Hits.Total.Value), attribute.Int("search.took_millis", res.Took))
 	}
-	defer res.Body.Close()
+	tracing.Fail(span, err)
 
-	if res.IsError() {
-		err := fmt.Errorf("[%s] Error indexing document ID=%s", res.Status(), idHash)
-		return "", err
+	_, logSpan := tracing.Start(ctx, "queryLog.Log")
+	logSpan.SetAttributes(attribute.Bool("queryLog.queued", ql.Log(iq.Index, iq)))
+	logSpan.End()
+
+	return res, err
+}
+
+// newSearchQuery returns the document logging a search, under the name the search is logged by
+func newSearchQuery(r *http.Request, s SearchRequest) IndexQuery {
+	iq := newIndexQuery(r, s.logIndex(), s.SearchTerm)
+	if names := s.indexNames(); len(names) > 1 {
+		iq.Indices = names
 	}
+	return iq
+}
 
-	// Deserialize the response into a map.
-	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		e := fmt.Errorf("Error parsing the index response body: %s", err)
-		return "", e
+// newIndexQuery returns the document logging a search term, with a new search ID
+func newIndexQuery(req *http.Request, i string, q string) IndexQuery {
+	return IndexQuery{
+		SearchID:  newSearchID(),
+		Index:     i,
+		Query:     q,
+		UserAgent: req.Header.Get("user-agent"),
+		Date:      time.Now().UTC().Format(queryDateFormat),
 	}
+}
 
-	// Print the response status and indexed document version.
-	responseString := fmt.Sprintf("[%s] %s; version=%d", res.Status(), r["result"], int(r["_version"].(float64)))
-	return responseString, err
+func newSearchID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// fall back on the time, which is unique enough to log the query under
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
 
 // Query represents the query to Elasticsearch
 type Query struct {
-	Query struct {
-		MultiMatch struct {
-			Query  string   `json:"query"`
-			Fields []string `json:"fields"`
-		} `json:"multi_match"`
-	} `json:"query"`
+	From         int                      `json:"from,omitempty"`
+	Size         int                      `json:"size,omitempty"`
+	Query        map[string]interface{}   `json:"query"`
+	IndicesBoost []map[string]float64     `json:"indices_boost,omitempty"`
+	Sort         []map[string]interface{} `json:"sort,omitempty"`
+	TrackScores  bool                     `json:"track_scores,omitempty"`
+	SearchAfter  []interface{}            `json:"search_after,omitempty"`
+	Aggregations map[string]interface{}   `json:"aggs,omitempty"`
+	PostFilter   map[string]interface{}   `json:"post_filter,omitempty"`
+	Highlight    map[string]interface{}   `json:"highlight,omitempty"`
+	Source       *SourceFilter            `json:"_source,omitempty"`
+}
+
+// buildQuery translates a search request into the body of an Elasticsearch _search call. The free-text
+// term, or the best of its expansions, is matched with multi_match, along with the clauses of an advanced
+// search, and scored as set by the profile, any pinned documents are promoted
+// above the matches, and any filters are added as a non-scoring bool filter clause. Selected facet values
+// go in the post_filter so they narrow the hits but not the facet counts.
+func buildQuery(s SearchRequest, c *cursor) Query {
+	match := functionScore(syntaxQuery(expandedMatch(s), s), s.profile)
+
+	query := Query{Query: match}
+	switch {
+	case len(s.pins) > 0:
+		query.Query = pinQuery(match, s.pins, s.Filters, len(s.Sort) > 0)
+	case len(s.Filters) > 0:
+		query.Query = map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":   []interface{}{match},
+				"filter": filterClauses(s.Filters),
+			},
+		}
+	}
+	boostQuery(&query, s)
+	paginateQuery(&query, s, c)
+	facetQuery(&query, s.Facets)
+	highlightQuery(&query, s)
+	query.Source = s.Source
+
+	return query
 }
 
-func searchQuery(es *elasticsearch.Client, i string, q string, f []string) (r *Results, err error) {
+func searchQuery(ctx context.Context, es *elasticsearch.Client, s SearchRequest) (r *Results, err error) {
 	var (
 		buf bytes.Buffer
 	)
 
-	query := Query{}
-	query.Query.MultiMatch.Query = q
-	query.Query.MultiMatch.Fields = f
+	c, err := decodeCursor(s.Cursor)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	// without a tiebreaker, a cursor could skip or repeat the hits with equal sort values
+	if c != nil && s.tiebreaker == "" {
+		return nil, invalid(fmt.Errorf("cursors need a tiebreaker for the indices searched, page with page and size instead"))
+	}
+
+	if s.profile != nil && s.profile.Template != "" {
+		if r, err = searchTemplate(ctx, es, s); err != nil {
+			return nil, err
+		}
+		paginateResults(r, s, nil)
+		labelHits(r, s)
+		if err := projectResults(r); err != nil {
+			return nil, &Error{Kind: ErrParse, Err: err}
+		}
+		return r, nil
+	}
+
+	query := buildQuery(s, c)
 
 	if err := json.NewEncoder(&buf).Encode(query); err != nil {
 		return nil, err
 	}
 
 	searchRes, err := es.Search(
-		es.Search.WithContext(context.Background()),
-		es.Search.WithIndex(i),
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(s.targets()...),
 		es.Search.WithBody(&buf),
+		es.Search.WithTimeout(shardTimeout(ctx)),
 		es.Search.WithPretty(),
 	)
 	if err != nil {
-		log.Fatalf("Error getting response: %s", err)
+		return nil, requestError(ctx, err)
 	}
 	defer searchRes.Body.Close()
 
 	if searchRes.IsError() {
-		var e map[string]interface{}
-		if err := json.NewDecoder(searchRes.Body).Decode(&e); err != nil {
-			jErr := fmt.Errorf("Error parsing the response body: %s", err)
-			return nil, jErr
-		}
-		// Print the response status and error information.
-		err = fmt.Errorf("[%s] %s: %s",
-			searchRes.Status(),
-			e["error"].(map[string]interface{})["type"],
-			e["error"].(map[string]interface{})["reason"],
-		)
-		return nil, err
+		return nil, responseError(searchRes, fmt.Sprintf("Error searching %s", strings.Join(s.indexNames(), ",")))
 	}
 
-	if err := json.NewDecoder(searchRes.Body).Decode(&r); err != nil {
-		return nil, err
+	// decode numbers as json.Number so sort values round-trip through cursors unchanged
+	d := json.NewDecoder(searchRes.Body)
+	d.UseNumber()
+	if err := d.Decode(&r); err != nil {
+		return nil, parseError(err)
+	}
+	paginateResults(r, s, c)
+	if s.tiebreaker == "" {
+		r.Pagination.Next, r.Pagination.Previous = "", ""
+	}
+	labelHits(r, s)
+	markPinned(r, s.pins)
+	if err := projectResults(r); err != nil {
+		return nil, &Error{Kind: ErrParse, Err: err}
+	}
+
+	if len(s.Facets) > 0 {
+		if r.Facets, err = facetResults(r.Aggregations, s.Facets); err != nil {
+			return nil, &Error{Kind: ErrParse, Err: err}
+		}
 	}
+	r.Aggregations = nil
 
 	return r, nil
 }
@@ -3,26 +3,24 @@ package searching
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
-	"github.com/wambozi/elastic-search-api/m/pkg/clients"
-)
-
-var (
-	ee       = "http://localhost:9200"
-	username = "elastic"
-	password = "changeme"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
 )
 
 func TestSearch(t *testing.T) {
 	l := logrus.New()
-	cfg := clients.GenerateElasticConfig([]string{ee}, username, password)
-	ec, err := clients.CreateElasticClient(cfg)
-	if err != nil {
-		t.Errorf("Unexpected error creating Elasticsearch client: %s", err)
-	}
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Index("test", "1", `{"text":"a test document"}`)
+	srv.Index("test", "2", `{"text":"another document"}`)
 
 	searchReq := SearchRequest{
 		SearchTerm: "test",
@@ -30,10 +28,114 @@ func TestSearch(t *testing.T) {
 		Fields:     []string{"text"},
 	}
 	bodyJSON, err := json.Marshal(searchReq)
-	encodedBody := bytes.NewReader(bodyJSON)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding search request: %s", err)
+	}
+
+	req, _ := http.NewRequest("POST", "/search", bytes.NewReader(bodyJSON))
+	actual, err := Search(srv.Client(), nil, req, searchReq, l)
+	if err != nil {
+		t.Fatalf("Unexpected error searching: %s", err)
+	}
+
+	var ids []string
+	for _, h := range actual.Hits.Results {
+		ids = append(ids, h.ID)
+	}
+	if diff := cmp.Diff([]string{"1"}, ids); diff != "" {
+		t.Fatalf(diff)
+	}
+	if actual.SearchID == "" {
+		t.Errorf("search should have an ID")
+	}
+}
+
+func TestSearchIndexNotFound(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", "/search?qt=test&i=missing", nil)
+	_, err := Search(srv.Client(), nil, req, SearchRequest{SearchTerm: "test", Index: "missing"}, logrus.New())
+	if !errors.Is(err, ErrIndexNotFound) {
+		t.Fatalf("expected an index not found error, got %v", err)
+	}
+}
 
-	req, err := http.NewRequest("POST", "/search", encodedBody)
-	actual := Search(ec, req, searchReq, l)
+func TestSearchCursors(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	for _, id := range []string{"1", "2", "3"} {
+		srv.Index("test", id, `{"text":"a test document"}`)
+	}
+	cursor := encodeCursor(cursor{After: []interface{}{"1", "1"}})
+
+	tests := map[string]struct {
+		search SearchRequest
+		next   bool
+		err    error
+	}{
+		"tiebreaker":           {search: SearchRequest{SearchTerm: "test", Index: "test", Size: 2, tiebreaker: "id"}, next: true},
+		"tiebreaker, cursor":   {search: SearchRequest{SearchTerm: "test", Index: "test", Size: 2, Cursor: cursor, tiebreaker: "id"}, next: true},
+		"no tiebreaker":        {search: SearchRequest{SearchTerm: "test", Index: "test", Size: 2}},
+		"no tiebreaker cursor": {search: SearchRequest{SearchTerm: "test", Index: "test", Size: 2, Cursor: cursor}, err: ErrBadRequest},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/search", nil)
+			res, err := Search(srv.Client(), nil, req, tc.search, logrus.New())
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("error - expected : %v, received : %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if (res.Pagination.Next != "") != tc.next {
+				t.Fatalf("next cursor - expected : %t, received : %+v", tc.next, res.Pagination)
+			}
+			if res.Pagination.Previous != "" && tc.search.tiebreaker == "" {
+				t.Fatalf("searches without a tiebreaker should have no cursors, received : %+v", res.Pagination)
+			}
+		})
+	}
+}
+
+func TestSearchMetrics(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Index("test", "1", `{"text":"a test document"}`)
+	took, duration := observations(searchTook), observations(searchDuration)
+
+	req, _ := http.NewRequest("GET", "/search?qt=test&i=test", nil)
+	for _, index := range []string{"test", "missing"} {
+		Search(srv.Client(), nil, req, SearchRequest{SearchTerm: "test", Index: index}, logrus.New())
+	}
+	if observations(searchTook)-took != 1 || observations(searchDuration)-duration != 1 {
+		t.Fatalf("only the search that succeeded should be timed, received : %d took, %d durations", observations(searchTook)-took, observations(searchDuration)-duration)
+	}
+}
+
+// observations returns how many values the histogram observed
+func observations(h prometheus.Observer) uint64 {
+	var m dto.Metric
+	h.(prometheus.Metric).Write(&m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestNewIndexQuery(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/search?qt=r2d2&i=droids", nil)
+	req.Header.Set("user-agent", "test-agent")
 
-	print(actual)
+	iq := newIndexQuery(req, "droids", "r2d2")
+	other := newIndexQuery(req, "droids", "r2d2")
+
+	if len(iq.SearchID) != 32 || iq.SearchID == other.SearchID {
+		t.Fatalf("search IDs should be unique 32 character hex strings, got %q and %q", iq.SearchID, other.SearchID)
+	}
+	if iq.Index != "droids" || iq.Query != "r2d2" || iq.UserAgent != "test-agent" {
+		t.Fatalf("unexpected index query : %+v", iq)
+	}
+	if _, err := time.Parse(time.RFC3339, iq.Date); err != nil {
+		t.Fatalf("date should be ISO 8601, got %q: %s", iq.Date, err)
+	}
 }
@@ -0,0 +1,132 @@
+package searching
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/pkg/clients"
+)
+
+// Searcher runs the searches, suggestions and query analytics the API serves
+type Searcher interface {
+	Search(r *http.Request, s SearchRequest) (*Results, error)
+	Suggest(r *http.Request, s SuggestRequest) (*Suggestions, error)
+	TopQueries(ctx context.Context, a AnalyticsRequest) (*TopQueries, error)
+	TrendingQueries(ctx context.Context, a AnalyticsRequest) (*TrendingQueries, error)
+	RecordClick(ctx context.Context, c Click) error
+	Pinner
+	Rewriter
+	HealthChecker
+}
+
+// Indexer indexes documents to be searched
+type Indexer interface {
+	IndexDocument(d clients.Document) (resSlice []string, errSlice []error)
+}
+
+var (
+	_ Searcher = (*Elastic)(nil)
+	_ Indexer  = (*Elastic)(nil)
+	_ Searcher = (*Memory)(nil)
+	_ Indexer  = (*Memory)(nil)
+)
+
+// Elastic is the Searcher and Indexer backed by an Elasticsearch cluster. Searches are logged to its
+// QueryLog, unless it is nil.
+type Elastic struct {
+	Client   *elasticsearch.Client
+	QueryLog *QueryLog
+	Log      *logrus.Logger
+
+	// pins and rules cache those of the indices searched, when set
+	pins  *indexCache
+	rules *indexCache
+}
+
+// NewElastic returns a Searcher and Indexer querying the cluster of the client, caching the pins and rules
+// of the indices it searches
+func NewElastic(es *elasticsearch.Client, ql *QueryLog, logger *logrus.Logger) *Elastic {
+	return &Elastic{Client: es, QueryLog: ql, Log: logger, pins: newIndexCache(), rules: newIndexCache()}
+}
+
+// Search runs the search, see Search
+func (e *Elastic) Search(r *http.Request, s SearchRequest) (*Results, error) {
+	return e.search(r, s)
+}
+
+// Suggest returns completions of the prefix, see Suggest
+func (e *Elastic) Suggest(r *http.Request, s SuggestRequest) (*Suggestions, error) {
+	return Suggest(e.Client, e.QueryLog, r, s)
+}
+
+// TopQueries returns the most searched terms, see GetTopQueries
+func (e *Elastic) TopQueries(ctx context.Context, a AnalyticsRequest) (*TopQueries, error) {
+	return GetTopQueries(ctx, e.Client, a)
+}
+
+// TrendingQueries returns the fastest rising terms, see GetTrendingQueries
+func (e *Elastic) TrendingQueries(ctx context.Context, a AnalyticsRequest) (*TrendingQueries, error) {
+	return GetTrendingQueries(ctx, e.Client, a)
+}
+
+// RecordClick adds a click to a logged search, see RecordClick. Clicks on searches the query log hasn't
+// indexed yet are indexed with them.
+func (e *Elastic) RecordClick(ctx context.Context, c Click) error {
+	if e.QueryLog.Click(c) {
+		return nil
+	}
+	return RecordClick(ctx, e.Client, c)
+}
+
+// Pins returns the pins of the index, see GetPins
+func (e *Elastic) Pins(ctx context.Context, index string) (*Pins, error) {
+	return GetPins(ctx, e.Client, index)
+}
+
+// Pin pins documents to a query, see PutPin. The cached pins of the index are dropped, so its searches
+// apply the pin right away.
+func (e *Elastic) Pin(ctx context.Context, p Pin) (*Pin, error) {
+	defer e.pins.invalidate(p.Index)
+	return PutPin(ctx, e.Client, p)
+}
+
+// Unpin unpins the documents pinned to a query, see DeletePin. The cached pins of the index are dropped.
+func (e *Elastic) Unpin(ctx context.Context, index string, query string) error {
+	defer e.pins.invalidate(index)
+	return DeletePin(ctx, e.Client, index, query)
+}
+
+// Rules returns the query rules of the index, see GetRules
+func (e *Elastic) Rules(ctx context.Context, index string) (*Rules, error) {
+	return GetRules(ctx, e.Client, index)
+}
+
+// PutRule stores a query rule, see PutRule. The cached rules of the index are dropped, so its searches
+// apply the rule right away.
+func (e *Elastic) PutRule(ctx context.Context, r Rule) (*Rule, error) {
+	defer e.rules.invalidate(r.Index)
+	return PutRule(ctx, e.Client, r)
+}
+
+// DeleteRule deletes a query rule, see DeleteRule. The cached rules of the index are dropped.
+func (e *Elastic) DeleteRule(ctx context.Context, index string, name string) error {
+	defer e.rules.invalidate(index)
+	return DeleteRule(ctx, e.Client, index, name)
+}
+
+// ClusterHealth returns the health of the cluster, see GetClusterHealth
+func (e *Elastic) ClusterHealth(ctx context.Context) (*ClusterHealth, error) {
+	return GetClusterHealth(ctx, e.Client)
+}
+
+// MissingIndices returns the indices or aliases that don't exist, see GetMissingIndices
+func (e *Elastic) MissingIndices(ctx context.Context, names []string) ([]string, error) {
+	return GetMissingIndices(ctx, e.Client, names)
+}
+
+// IndexDocument indexes the document, see clients.IndexDocument
+func (e *Elastic) IndexDocument(d clients.Document) ([]string, []error) {
+	return clients.IndexDocument(e.Client, d)
+}
@@ -0,0 +1,237 @@
+package searching
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The types of sortable fields, which decide how a field can be sorted on
+const (
+	SortKeyword  = "keyword"
+	SortNumeric  = "numeric"
+	SortDate     = "date"
+	SortGeoPoint = "geo_point"
+)
+
+const (
+	scoreField = "_score"
+	// defaultDistanceUnit is the unit of the distances geo sorts return
+	defaultDistanceUnit = "km"
+)
+
+// SortField orders hits by a field, the score, or the distance of a geo point field to Near, which is
+// ignored for other fields. Order is asc or desc, and defaults to the most useful order for the field:
+// highest score first, most recent date first, closest first, and ascending otherwise.
+type SortField struct {
+	Field string    `json:"field"`
+	Order string    `json:"order,omitempty"`
+	Near  *GeoPoint `json:"near,omitempty"`
+	// Unit is the unit of geo distances, such as km or mi
+	Unit string `json:"unit,omitempty"`
+}
+
+// GeoPoint represents a location to sort by distance from
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// SortableField is a field of an index that can be sorted on, and its type
+type SortableField struct {
+	Field string
+	Type  string
+}
+
+// SortAllowlist holds the fields that can be sorted on by index name or pattern, e.g. crawler-*. Sorting
+// on text fields makes Elasticsearch load fielddata, or fail, so only fields listed here can be sorted on.
+// The score can always be sorted on.
+type SortAllowlist map[string][]SortableField
+
+// ParseSort reads a sort parameter such as published:desc,_score into sort fields. near is the point geo
+// point fields are sorted by distance from, as lat,lon, which geo sorts need.
+func ParseSort(sort string, near string) ([]SortField, error) {
+	var point *GeoPoint
+	if near != "" {
+		p, err := parseGeoPoint(near)
+		if err != nil {
+			return nil, err
+		}
+		point = p
+	}
+
+	var fields []SortField
+	for _, spec := range strings.Split(sort, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		f := SortField{Field: spec}
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			f.Field, f.Order = spec[:i], spec[i+1:]
+		}
+		if f.Field != scoreField {
+			f.Near = point
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func parseGeoPoint(s string) (*GeoPoint, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("near must be lat,lon, got %q", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("near must be lat,lon, got %q", s)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("near must be lat,lon, got %q", s)
+	}
+	return &GeoPoint{Lat: lat, Lon: lon}, nil
+}
+
+func (f SortField) validate() error {
+	if f.Field == "" {
+		return fmt.Errorf("sort is missing a field")
+	}
+	if f.Order != "" && f.Order != "asc" && f.Order != "desc" {
+		return fmt.Errorf("sort order of %q must be asc or desc, got %q", f.Field, f.Order)
+	}
+	if f.Near != nil && (f.Near.Lat < -90 || f.Near.Lat > 90 || f.Near.Lon < -180 || f.Near.Lon > 180) {
+		return fmt.Errorf("sort of %q is near an invalid point %g,%g", f.Field, f.Near.Lat, f.Near.Lon)
+	}
+	return nil
+}
+
+// Check verifies that every field the search sorts on can be sorted on in each index it searches, and
+// sets the type of the fields for the query to be built. Its errors are of kind ErrBadRequest.
+func (a SortAllowlist) Check(s SearchRequest) (SearchRequest, error) {
+	if len(s.Sort) == 0 {
+		return s, nil
+	}
+
+	s.sortTypes = map[string]string{}
+	for _, f := range s.Sort {
+		if f.Field == scoreField {
+			continue
+		}
+		t, err := a.fieldType(f.Field, s.indexNames())
+		if err != nil {
+			return s, invalid(err)
+		}
+		if f.Near == nil && t == SortGeoPoint {
+			return s, invalid(fmt.Errorf("sorting on geo point field %q needs a point to sort by distance from", f.Field))
+		}
+		s.sortTypes[f.Field] = t
+	}
+	return s, nil
+}
+
+// fieldType returns the type of the field, which must be sortable, and of the same type, in every index
+func (a SortAllowlist) fieldType(field string, indices []string) (string, error) {
+	if len(indices) == 0 {
+		indices = []string{"*"}
+	}
+
+	fieldType := ""
+	for _, index := range indices {
+		t, ok := a.sortable(index, field)
+		if !ok {
+			return "", fmt.Errorf("%q can't be sorted on in %s", field, index)
+		}
+		if fieldType != "" && t != fieldType {
+			return "", fmt.Errorf("%q is of different types in the searched indices", field)
+		}
+		fieldType = t
+	}
+	return fieldType, nil
+}
+
+// sortable returns the type of the field when the index, or a pattern it falls within, lists it
+func (a SortAllowlist) sortable(index, field string) (string, bool) {
+	for pattern, fields := range a {
+		if ok, _ := path.Match(pattern, index); !ok {
+			continue
+		}
+		for _, f := range fields {
+			if f.Field == field {
+				return f.Type, true
+			}
+		}
+	}
+	return "", false
+}
+
+// order returns the order of the sort field, reversed for a reverse cursor
+func (f SortField) order(fieldType string, reverse bool) string {
+	order := f.Order
+	if order == "" {
+		order = "asc"
+		if f.Field == scoreField || fieldType == SortDate {
+			order = "desc"
+		}
+	}
+	if reverse {
+		if order == "asc" {
+			return "desc"
+		}
+		return "asc"
+	}
+	return order
+}
+
+// byDistance reports whether the field is sorted by distance: when it's a geo point field, or when the
+// allowlist wasn't checked, when it has a point to sort from
+func (f SortField) byDistance(s SearchRequest) bool {
+	if s.sortTypes == nil {
+		return f.Near != nil
+	}
+	return s.sortTypes[f.Field] == SortGeoPoint
+}
+
+// sortClauses returns the sort of the query: by the sort fields of the search, or by score, then by the
+// tiebreaker, if any, so every hit has distinct sort values for cursors. Pinned documents are sorted first, by the
+// score pinQuery gives them.
+func sortClauses(s SearchRequest, reverse bool) []map[string]interface{} {
+	fields := s.Sort
+	if len(fields) == 0 {
+		fields = []SortField{{Field: scoreField}}
+	} else if len(s.pins) > 0 {
+		fields = append([]SortField{{Field: scoreField}}, fields...)
+	}
+
+	var clauses []map[string]interface{}
+	for _, f := range fields {
+		order := f.order(s.sortTypes[f.Field], reverse)
+		if !f.byDistance(s) {
+			clauses = append(clauses, map[string]interface{}{f.Field: order})
+			continue
+		}
+
+		unit := f.Unit
+		if unit == "" {
+			unit = defaultDistanceUnit
+		}
+		clauses = append(clauses, map[string]interface{}{
+			"_geo_distance": map[string]interface{}{
+				f.Field: f.Near,
+				"order": order,
+				"unit":  unit,
+			},
+		})
+	}
+
+	if s.tiebreaker == "" {
+		return clauses
+	}
+	tiebreakerOrder := "asc"
+	if reverse {
+		tiebreakerOrder = "desc"
+	}
+	return append(clauses, map[string]interface{}{s.tiebreaker: tiebreakerOrder})
+}
@@ -0,0 +1,164 @@
+package searching
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSort(t *testing.T) {
+	amsterdam := &GeoPoint{Lat: 52.37, Lon: 4.89}
+
+	tests := map[string]struct {
+		sort     string
+		near     string
+		expected []SortField
+		err      string
+	}{
+		"empty":      {sort: "", expected: nil},
+		"field":      {sort: "title.keyword", expected: []SortField{{Field: "title.keyword"}}},
+		"orders":     {sort: "published:desc, _score", expected: []SortField{{Field: "published", Order: "desc"}, {Field: "_score"}}},
+		"near":       {sort: "location,_score:asc", near: "52.37,4.89", expected: []SortField{{Field: "location", Near: amsterdam}, {Field: "_score", Order: "asc"}}},
+		"bad near":   {sort: "location", near: "amsterdam", err: `near must be lat,lon, got "amsterdam"`},
+		"short near": {sort: "location", near: "52.37", err: `near must be lat,lon, got "52.37"`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			actual, err := ParseSort(tc.sort, tc.near)
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestValidateSort(t *testing.T) {
+	tests := map[string]struct {
+		sort  SortField
+		valid bool
+	}{
+		"field":       {sort: SortField{Field: "published", Order: "desc"}, valid: true},
+		"no field":    {sort: SortField{Order: "desc"}, valid: false},
+		"bad order":   {sort: SortField{Field: "published", Order: "newest"}, valid: false},
+		"bad point":   {sort: SortField{Field: "location", Near: &GeoPoint{Lat: 91}}, valid: false},
+		"valid point": {sort: SortField{Field: "location", Near: &GeoPoint{Lat: -33.86, Lon: 151.2}}, valid: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := SearchRequest{Sort: []SortField{tc.sort}}.Validate()
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+			if err != nil && !errors.Is(err, ErrBadRequest) {
+				t.Fatalf("error should be a bad request, received : %v", err)
+			}
+		})
+	}
+}
+
+func TestSortAllowlistCheck(t *testing.T) {
+	a := SortAllowlist{
+		"droids":    {{Field: "published", Type: SortDate}, {Field: "location", Type: SortGeoPoint}},
+		"crawler-*": {{Field: "published", Type: SortDate}, {Field: "rank", Type: SortNumeric}},
+		"ships":     {{Field: "published", Type: SortKeyword}},
+	}
+	near := &GeoPoint{Lat: 52.37, Lon: 4.89}
+
+	tests := map[string]struct {
+		search SearchRequest
+		types  map[string]string
+		err    string
+	}{
+		"no sort":       {search: SearchRequest{Index: "jedi"}},
+		"score":         {search: SearchRequest{Index: "jedi", Sort: []SortField{{Field: "_score"}}}, types: map[string]string{}},
+		"date":          {search: SearchRequest{Index: "droids", Sort: []SortField{{Field: "published"}, {Field: "_score"}}}, types: map[string]string{"published": SortDate}},
+		"pattern":       {search: SearchRequest{Index: "crawler-2020", Sort: []SortField{{Field: "rank"}}}, types: map[string]string{"rank": SortNumeric}},
+		"every index":   {search: SearchRequest{Indices: []string{"droids", "crawler-2020"}, Sort: []SortField{{Field: "published"}}}, types: map[string]string{"published": SortDate}},
+		"geo":           {search: SearchRequest{Index: "droids", Sort: []SortField{{Field: "location", Near: near}}}, types: map[string]string{"location": SortGeoPoint}},
+		"not listed":    {search: SearchRequest{Index: "droids", Sort: []SortField{{Field: "title"}}}, err: `"title" can't be sorted on in droids`},
+		"not in all":    {search: SearchRequest{Indices: []string{"droids", "crawler-2020"}, Sort: []SortField{{Field: "rank"}}}, err: `"rank" can't be sorted on in droids`},
+		"no index":      {search: SearchRequest{Sort: []SortField{{Field: "published"}}}, err: `"published" can't be sorted on in *`},
+		"mixed types":   {search: SearchRequest{Indices: []string{"droids", "ships"}, Sort: []SortField{{Field: "published"}}}, err: `"published" is of different types in the searched indices`},
+		"geo no point":  {search: SearchRequest{Index: "droids", Sort: []SortField{{Field: "location"}}}, err: `sorting on geo point field "location" needs a point to sort by distance from`},
+		"unknown index": {search: SearchRequest{Index: "jedi", Sort: []SortField{{Field: "published"}}}, err: `"published" can't be sorted on in jedi`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := a.Check(tc.search)
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+				if !errors.Is(err, ErrBadRequest) {
+					t.Fatalf("error should be a bad request, received : %v", err)
+				}
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+			if err == nil {
+				if diff := cmp.Diff(tc.types, s.sortTypes); diff != "" {
+					t.Fatalf(diff)
+				}
+			}
+		})
+	}
+}
+
+func TestSortClauses(t *testing.T) {
+	a := SortAllowlist{"droids": {{Field: "published", Type: SortDate}, {Field: "name", Type: SortKeyword}, {Field: "location", Type: SortGeoPoint}}}
+	near := &GeoPoint{Lat: 52.37, Lon: 4.89}
+
+	tests := map[string]struct {
+		sort     []SortField
+		reverse  bool
+		expected []map[string]interface{}
+	}{
+		"score": {
+			expected: []map[string]interface{}{{"_score": "desc"}, {"id": "asc"}},
+		},
+		"recent first": {
+			sort:     []SortField{{Field: "published"}, {Field: "_score"}},
+			expected: []map[string]interface{}{{"published": "desc"}, {"_score": "desc"}, {"id": "asc"}},
+		},
+		"keyword": {
+			sort:     []SortField{{Field: "name"}},
+			expected: []map[string]interface{}{{"name": "asc"}, {"id": "asc"}},
+		},
+		"reverse": {
+			sort:     []SortField{{Field: "name", Order: "desc"}, {Field: "_score"}},
+			reverse:  true,
+			expected: []map[string]interface{}{{"name": "asc"}, {"_score": "asc"}, {"id": "desc"}},
+		},
+		"geo": {
+			sort: []SortField{{Field: "location", Near: near, Unit: "mi"}},
+			expected: []map[string]interface{}{
+				{"_geo_distance": map[string]interface{}{"location": near, "order": "asc", "unit": "mi"}},
+				{"id": "asc"},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := a.Check(SearchRequest{Index: "droids", Sort: tc.sort})
+			if err != nil {
+				t.Fatalf("Unexpected error checking sort: %s", err)
+			}
+			s = Tiebreakers{"droids": "id"}.Apply(s)
+			if diff := cmp.Diff(tc.expected, sortClauses(s, tc.reverse)); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package searching
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+)
+
+// SourceFilter represents the _source fields to return with each hit. Wildcards such as meta.* are allowed.
+type SourceFilter struct {
+	Includes []string `json:"includes,omitempty"`
+	Excludes []string `json:"excludes,omitempty"`
+}
+
+// Projection converts the raw _source of a hit into the document returned to the client
+type Projection func(source json.RawMessage) (interface{}, error)
+
+// Page represents the documents indexed by the crawler
+type Page struct {
+	Meta struct {
+		OgImage     string `json:"ogimage,omitempty"`
+		Title       string `json:"title"`
+		Description string `json:"Description"`
+		Keywords    string `json:"Keywords"`
+	} `json:"meta"`
+	URI string `json:"uri"`
+}
+
+// projection is a projection and the index pattern it is registered for
+type projection struct {
+	pattern string
+	project Projection
+}
+
+var (
+	projectionsMu sync.RWMutex
+	// projections are kept in the order they were registered, so the first matching one applies
+	projections []projection
+)
+
+func (f *SourceFilter) validate() error {
+	if f == nil {
+		return nil
+	}
+	for _, p := range append(append([]string{}, f.Includes...), f.Excludes...) {
+		if p == "" {
+			return fmt.Errorf("source includes and excludes can't be empty")
+		}
+	}
+	return nil
+}
+
+// RegisterProjection sets the projection applied to the hits of the indices matching the pattern, e.g.
+// crawler-* or droids. An index registered by name gets its own projection, and otherwise the first
+// registered pattern it matches applies. Registering a pattern again replaces its projection in place, and
+// registering a nil projection removes it, returning the raw _source again.
+func RegisterProjection(pattern string, p Projection) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("Invalid index pattern %q: %s", pattern, err)
+	}
+
+	projectionsMu.Lock()
+	defer projectionsMu.Unlock()
+
+	for i, r := range projections {
+		if r.pattern != pattern {
+			continue
+		}
+		if p == nil {
+			projections = append(projections[:i:i], projections[i+1:]...)
+		} else {
+			projections[i].project = p
+		}
+		return nil
+	}
+	if p != nil {
+		projections = append(projections, projection{pattern: pattern, project: p})
+	}
+	return nil
+}
+
+// ProjectAs returns a projection that decodes the _source into the value returned by newDoc, dropping any
+// field the type doesn't declare. For instance: ProjectAs(func() interface{} { return &Page{} })
+func ProjectAs(newDoc func() interface{}) Projection {
+	return func(source json.RawMessage) (interface{}, error) {
+		doc := newDoc()
+		if err := json.Unmarshal(source, doc); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+}
+
+func projectionFor(index string) Projection {
+	projectionsMu.RLock()
+	defer projectionsMu.RUnlock()
+
+	for _, r := range projections {
+		if r.pattern == index {
+			return r.project
+		}
+	}
+	for _, r := range projections {
+		if ok, _ := path.Match(r.pattern, index); ok {
+			return r.project
+		}
+	}
+	return nil
+}
+
+// projectResults replaces the _source of each hit with its projection, if one is registered for its index
+func projectResults(r *Results) error {
+	for i, h := range r.Hits.Results {
+		p := projectionFor(h.Index)
+		if p == nil || len(h.Source) == 0 {
+			continue
+		}
+
+		doc, err := p(h.Source)
+		if err != nil {
+			return fmt.Errorf("Error projecting document ID=%s of index %s: %s", h.ID, h.Index, err)
+		}
+		source, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("Error projecting document ID=%s of index %s: %s", h.ID, h.Index, err)
+		}
+		r.Hits.Results[i].Source = source
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package searching
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProjectResults(t *testing.T) {
+	source := json.RawMessage(`{"meta":{"title":"R2D2","Description":"droid","Keywords":"astromech","author":"lucas"},"uri":"https://example.com/r2d2","body":"..."}`)
+
+	err := RegisterProjection("crawler-*", ProjectAs(func() interface{} { return &Page{} }))
+	if err != nil {
+		t.Fatalf("Unexpected error registering projection: %s", err)
+	}
+	defer RegisterProjection("crawler-*", nil)
+
+	r := &Results{}
+	r.Hits.Results = []Hit{
+		{Index: "crawler-2020", ID: "1", Source: source},
+		{Index: "droids", ID: "2", Source: source},
+	}
+
+	if err := projectResults(r); err != nil {
+		t.Fatalf("Unexpected error projecting results: %s", err)
+	}
+
+	want := []string{
+		`{"meta":{"title":"R2D2","Description":"droid","Keywords":"astromech"},"uri":"https://example.com/r2d2"}`,
+		string(source),
+	}
+	got := []string{string(r.Hits.Results[0].Source), string(r.Hits.Results[1].Source)}
+
+	diff := cmp.Diff(want, got)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestRegisterProjection(t *testing.T) {
+	tests := map[string]struct {
+		pattern string
+		valid   bool
+	}{
+		"name":      {pattern: "droids", valid: true},
+		"wildcard":  {pattern: "droids-*", valid: true},
+		"malformed": {pattern: "droids-[", valid: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := RegisterProjection(tc.pattern, ProjectAs(func() interface{} { return &Page{} }))
+			defer RegisterProjection(tc.pattern, nil)
+
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestProjectionFor(t *testing.T) {
+	project := func(name string) Projection {
+		return func(json.RawMessage) (interface{}, error) { return name, nil }
+	}
+	for _, r := range []struct{ pattern, name string }{
+		{"droids-*", "droids-*"},
+		{"*", "all"},
+		{"droids-r2", "droids-r2"},
+		{"droids-*", "droids-* again"},
+	} {
+		if err := RegisterProjection(r.pattern, project(r.name)); err != nil {
+			t.Fatalf("Unexpected error registering projection: %s", err)
+		}
+		defer RegisterProjection(r.pattern, nil)
+	}
+
+	tests := map[string]struct {
+		index      string
+		projection string
+	}{
+		"name":             {index: "droids-r2", projection: "droids-r2"},
+		"first registered": {index: "droids-c3po", projection: "droids-* again"},
+		"other pattern":    {index: "ships", projection: "all"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				doc, _ := projectionFor(tc.index)(nil)
+				if diff := cmp.Diff(tc.projection, doc); diff != "" {
+					t.Fatalf(diff)
+				}
+			}
+		})
+	}
+}
+
+func TestSourceQuery(t *testing.T) {
+	req := SearchRequest{Source: &SourceFilter{Includes: []string{"meta.*"}, Excludes: []string{"meta.keywords"}}}
+
+	got, err := json.Marshal(buildQuery(req, nil).Source)
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling query: %s", err)
+	}
+
+	diff := cmp.Diff(`{"includes":["meta.*"],"excludes":["meta.keywords"]}`, string(got))
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
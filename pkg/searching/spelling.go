@@ -0,0 +1,154 @@
+package searching
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Correction represents the "did you mean" suggestion for a search term that found nothing
+type Correction struct {
+	Original    string  `json:"original"`
+	Text        string  `json:"text"`
+	Highlighted string  `json:"highlighted,omitempty"`
+	Score       float64 `json:"score"`
+	// Applied is true when the hits in the response are for the corrected term rather than the original
+	Applied bool `json:"applied"`
+}
+
+// spellcheckResults represents the parts of the response to a phrase suggester query the correction is taken from
+type spellcheckResults struct {
+	Suggest map[string][]struct {
+		Options []struct {
+			Text        string  `json:"text"`
+			Highlighted string  `json:"highlighted"`
+			Score       float64 `json:"score"`
+		} `json:"options"`
+	} `json:"suggest"`
+}
+
+// spellcheckFields returns the searched fields the phrase suggester can run on, i.e. without boosts or wildcards
+func spellcheckFields(s SearchRequest) []string {
+	var fields []string
+	for _, f := range s.fields() {
+		f = strings.SplitN(f, "^", 2)[0]
+		if !strings.Contains(f, "*") {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// buildSpellcheckQuery returns a query with a phrase suggester per searched field. Each one generates
+// candidates from its own field, and the best scoring option across them is the correction.
+func buildSpellcheckQuery(s SearchRequest) map[string]interface{} {
+	suggest := map[string]interface{}{"text": s.SearchTerm}
+	for i, f := range spellcheckFields(s) {
+		suggest[fmt.Sprintf("field%d", i)] = map[string]interface{}{
+			"phrase": map[string]interface{}{
+				"field": f,
+				"size":  1,
+				"direct_generator": []interface{}{
+					map[string]interface{}{"field": f, "suggest_mode": "always"},
+				},
+				"highlight": map[string]interface{}{"pre_tag": "<em>", "post_tag": "</em>"},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"size":    0,
+		"suggest": suggest,
+	}
+}
+
+// bestCorrection returns the highest scoring suggestion that differs from the search term, or nil if there is none
+func bestCorrection(r spellcheckResults, term string) *Correction {
+	var best *Correction
+	for _, entries := range r.Suggest {
+		for _, e := range entries {
+			for _, o := range e.Options {
+				if strings.EqualFold(o.Text, term) || (best != nil && best.Score >= o.Score) {
+					continue
+				}
+				best = &Correction{Original: term, Text: o.Text, Highlighted: o.Highlighted, Score: o.Score}
+			}
+		}
+	}
+	return best
+}
+
+func spellcheckQuery(ctx context.Context, es *elasticsearch.Client, s SearchRequest) (*Correction, error) {
+	var (
+		buf bytes.Buffer
+		r   spellcheckResults
+	)
+
+	if len(spellcheckFields(s)) == 0 {
+		return nil, nil
+	}
+
+	if err := json.NewEncoder(&buf).Encode(buildSpellcheckQuery(s)); err != nil {
+		return nil, err
+	}
+
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(s.targets()...),
+		es.Search.WithBody(&buf),
+		es.Search.WithTimeout(shardTimeout(ctx)),
+	)
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res, "Error getting spelling corrections")
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, parseError(err)
+	}
+
+	return bestCorrection(r, s.SearchTerm), nil
+}
+
+// correct looks for a correction of a search term that found nothing. With AutoCorrect, the corrected term
+// is rewritten by the rules and searched, and its hits replace the empty results when it finds any. It
+// returns the search the results are for: the corrected one once applied.
+func correct(ctx context.Context, es *elasticsearch.Client, s SearchRequest, rules []Rule, r *Results) (SearchRequest, *Results, error) {
+	c, err := spellcheckQuery(ctx, es, s)
+	if err != nil || c == nil {
+		return s, r, err
+	}
+
+	if s.AutoCorrect {
+		corrected := s
+		corrected.SearchTerm = c.Text
+		corrected.Cursor = ""
+		if s.syntax != nil {
+			syntax := *s.syntax
+			syntax.text = c.Text
+			corrected.syntax = &syntax
+		}
+		// a corrected term the rules redirect is only suggested
+		if corrected = rewrite(corrected, rules); corrected.redirect == "" {
+			cr, err := searchQuery(ctx, es, corrected)
+			if err != nil {
+				return s, r, err
+			}
+			if cr.Hits.Total.Value > 0 {
+				c.Applied = true
+				s, r = corrected, cr
+			}
+		}
+	}
+
+	r.Correction = c
+	return s, r, nil
+}
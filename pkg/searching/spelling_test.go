@@ -0,0 +1,106 @@
+package searching
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+)
+
+func TestBuildSpellcheckQuery(t *testing.T) {
+	req := SearchRequest{SearchTerm: "r2d3", Fields: []string{"meta.description^2", "meta.*", "name"}}
+
+	got, err := json.Marshal(buildSpellcheckQuery(req))
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling query: %s", err)
+	}
+
+	want := `{"size":0,"suggest":{` +
+		`"field0":{"phrase":{"direct_generator":[{"field":"meta.description","suggest_mode":"always"}],"field":"meta.description","highlight":{"post_tag":"\u003c/em\u003e","pre_tag":"\u003cem\u003e"},"size":1}},` +
+		`"field1":{"phrase":{"direct_generator":[{"field":"name","suggest_mode":"always"}],"field":"name","highlight":{"post_tag":"\u003c/em\u003e","pre_tag":"\u003cem\u003e"},"size":1}},` +
+		`"text":"r2d3"}}`
+
+	diff := cmp.Diff(want, string(got))
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestBestCorrection(t *testing.T) {
+	tests := map[string]struct {
+		body string
+		want *Correction
+	}{
+		"best score": {
+			body: `{"suggest":{` +
+				`"field0":[{"options":[{"text":"r2d2","highlighted":"<em>r2d2</em>","score":0.5}]}],` +
+				`"field1":[{"options":[{"text":"r2d4","highlighted":"<em>r2d4</em>","score":0.2}]}]}}`,
+			want: &Correction{Original: "r2d3", Text: "r2d2", Highlighted: "<em>r2d2</em>", Score: 0.5},
+		},
+		"same as the term": {
+			body: `{"suggest":{"field0":[{"options":[{"text":"R2D3","score":0.9}]}]}}`,
+			want: nil,
+		},
+		"no options": {
+			body: `{"suggest":{"field0":[{"options":[]}]}}`,
+			want: nil,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var r spellcheckResults
+			if err := json.Unmarshal([]byte(tc.body), &r); err != nil {
+				t.Fatalf("Unexpected error decoding response: %s", err)
+			}
+
+			if diff := cmp.Diff(tc.want, bestCorrection(r, "r2d3")); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestCorrectRewrites(t *testing.T) {
+	tests := map[string]struct {
+		rules      []Rule
+		applied    bool
+		term       string
+		rewritten  string
+		expansions []string
+	}{
+		"no rules":  {term: "drobot"},
+		"replace":   {rules: []Rule{{Type: RuleReplace, Terms: []string{"robot"}, Replacement: "droid"}}, applied: true, term: "droid", rewritten: "droid"},
+		"synonyms":  {rules: []Rule{{Type: RuleSynonyms, Terms: []string{"droid", "robot"}}, {Type: RuleReplace, Terms: []string{"robot"}, Replacement: "droid"}}, applied: true, term: "droid", rewritten: "droid", expansions: []string{"droid", "robot"}},
+		"redirect":  {rules: []Rule{{Type: RuleRedirect, Terms: []string{"robot"}, URL: "/robots"}}, term: "drobot"},
+		"unrelated": {rules: []Rule{{Type: RuleReplace, Terms: []string{"drobot"}, Replacement: "droid"}}, term: "drobot"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := estest.NewServer()
+			defer srv.Close()
+			srv.Index("droids", "1", `{"meta":{"description":"An astromech droid"}}`)
+			srv.HandleOnce("", "/droids/_search", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"suggest":{"field0":[{"options":[{"text":"robot","score":0.5}]}]}}`))
+			})
+
+			s := rewrite(SearchRequest{Index: "droids", SearchTerm: "drobot", Fields: []string{"meta.description"}, SpellCheck: true, AutoCorrect: true}, nil)
+			s, res, err := correct(context.Background(), srv.Client(), s, tc.rules, &Results{})
+			if err != nil {
+				t.Fatalf("Unexpected error correcting: %s", err)
+			}
+			if res.Correction == nil || res.Correction.Applied != tc.applied {
+				t.Fatalf("correction applied - expected : %t, received : %+v", tc.applied, res.Correction)
+			}
+			rewriteResults(res, s)
+			if diff := cmp.Diff([]interface{}{tc.term, tc.rewritten, tc.expansions}, []interface{}{s.SearchTerm, res.RewrittenQuery, res.ExpandedQueries}); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package searching
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+const (
+	// DefaultSuggestSize is the number of suggestions returned when a request does not specify a size
+	DefaultSuggestSize = 5
+	// MaxSuggestSize is the largest number of suggestions a single request may ask for
+	MaxSuggestSize = 20
+)
+
+// DefaultSuggestFields are the fields suggestions are taken from when neither a completion field nor
+// search_as_you_type fields are configured
+var DefaultSuggestFields = []string{"meta.title"}
+
+// SuggestRequest represents a request for completions of a partial search term on the GET /suggest route.
+// Suggestions come from the completion suggester when CompletionField is set, and otherwise from a
+// bool_prefix multi_match on Fields, which should be search_as_you_type fields (listed along with their
+// ._2gram and ._3gram subfields) or edge-ngram fields. The text of a suggestion is taken from the first of
+// the Fields a hit has a value for.
+type SuggestRequest struct {
+	Prefix          string
+	Index           string
+	CompletionField string
+	Fields          []string
+	Size            int
+	Highlight       bool
+	LogQuery        bool
+
+	// targets holds the indices behind Index, once resolved by an IndexMap
+	targets []string
+}
+
+// Suggestions represents the response of the GET /suggest route
+type Suggestions struct {
+	Took        int          `json:"took"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+// Suggestion represents a single completion of the partial search term
+type Suggestion struct {
+	Text        string `json:"text"`
+	Highlighted string `json:"highlighted,omitempty"`
+}
+
+// suggestResults represents the parts of the response to a suggest query the suggestions are taken from
+type suggestResults struct {
+	Took int `json:"took"`
+	Hits struct {
+		Results []struct {
+			Source map[string]interface{} `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+	Suggest map[string][]struct {
+		Options []struct {
+			Text string `json:"text"`
+		} `json:"options"`
+	} `json:"suggest"`
+}
+
+// Validate checks that the request can be turned into a query Elasticsearch will accept. Its errors are
+// of kind ErrBadRequest.
+func (s SuggestRequest) Validate() error {
+	if strings.TrimSpace(s.Prefix) == "" || s.Index == "" {
+		return invalid(fmt.Errorf("suggestions need a prefix and an index"))
+	}
+	if s.Size < 0 || s.Size > MaxSuggestSize {
+		return invalid(fmt.Errorf("size must be between 1 and %d, got %d", MaxSuggestSize, s.Size))
+	}
+	return nil
+}
+
+func (s SuggestRequest) size() int {
+	if s.Size == 0 {
+		return DefaultSuggestSize
+	}
+	return s.Size
+}
+
+// indices returns the indices to take suggestions from
+func (s SuggestRequest) indices() []string {
+	if s.targets == nil {
+		return []string{s.Index}
+	}
+	return s.targets
+}
+
+func (s SuggestRequest) fields() []string {
+	if len(s.Fields) == 0 {
+		return DefaultSuggestFields
+	}
+	return s.Fields
+}
+
+// Suggest returns completions for the partial search term of the request. Unlike Search, the term is only
+// logged to the QueryLog when the request asks for it.
+func Suggest(elasticClient *elasticsearch.Client, ql *QueryLog, r *http.Request, s SuggestRequest) (*Suggestions, error) {
+	iq := newIndexQuery(r, s.Index, s.Prefix)
+	start := time.Now()
+
+	res, err := suggestQuery(r.Context(), elasticClient, s)
+
+	if s.LogQuery {
+		iq.LatencyMillis = time.Since(start).Milliseconds()
+		if res != nil {
+			n := len(res.Suggestions)
+			iq.Hits = &n
+			iq.Took = &res.Took
+		}
+		ql.Log(s.Index, iq)
+	}
+
+	return res, err
+}
+
+func buildSuggestQuery(s SuggestRequest) map[string]interface{} {
+	if s.CompletionField != "" {
+		return map[string]interface{}{
+			"_source": false,
+			"suggest": map[string]interface{}{
+				"suggest": map[string]interface{}{
+					"prefix": s.Prefix,
+					"completion": map[string]interface{}{
+						"field":           s.CompletionField,
+						"size":            s.size(),
+						"skip_duplicates": true,
+					},
+				},
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"size":    s.size(),
+		"_source": s.fields(),
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":  s.Prefix,
+				"type":   "bool_prefix",
+				"fields": s.fields(),
+			},
+		},
+	}
+}
+
+func suggestQuery(ctx context.Context, es *elasticsearch.Client, s SuggestRequest) (*Suggestions, error) {
+	var (
+		buf bytes.Buffer
+		r   suggestResults
+	)
+
+	if err := json.NewEncoder(&buf).Encode(buildSuggestQuery(s)); err != nil {
+		return nil, err
+	}
+
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(s.indices()...),
+		es.Search.WithBody(&buf),
+		es.Search.WithTrackTotalHits(false),
+		es.Search.WithTimeout(shardTimeout(ctx)),
+	)
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res, "Error getting suggestions")
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, parseError(err)
+	}
+
+	return suggestions(r, s), nil
+}
+
+// suggestions collects the distinct suggestion texts, in the order Elasticsearch ranked them
+func suggestions(r suggestResults, s SuggestRequest) *Suggestions {
+	var texts []string
+	for _, o := range r.Suggest["suggest"] {
+		for _, opt := range o.Options {
+			texts = append(texts, opt.Text)
+		}
+	}
+	for _, h := range r.Hits.Results {
+		for _, f := range s.fields() {
+			if text, ok := sourceValue(h.Source, f).(string); ok && text != "" {
+				texts = append(texts, text)
+				break
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	res := &Suggestions{Took: r.Took, Suggestions: []Suggestion{}}
+	for _, t := range texts {
+		key := strings.ToLower(t)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		sg := Suggestion{Text: t}
+		if s.Highlight {
+			sg.Highlighted = highlightPrefix(t, s.Prefix)
+		}
+		res.Suggestions = append(res.Suggestions, sg)
+	}
+
+	return res
+}
+
+// sourceValue returns the value at a dotted path, such as meta.title, of a document
+func sourceValue(source map[string]interface{}, field string) interface{} {
+	var v interface{} = source
+	for _, k := range strings.Split(field, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// highlightPrefix HTML encodes the text and wraps the first word starting with the prefix in <em> tags
+func highlightPrefix(text string, prefix string) string {
+	prefix = strings.TrimSpace(prefix)
+	wordStart := true
+
+	for i, r := range text {
+		if wordStart && i+len(prefix) <= len(text) && strings.EqualFold(text[i:i+len(prefix)], prefix) {
+			end := i + len(prefix)
+			return html.EscapeString(text[:i]) + "<em>" + html.EscapeString(text[i:end]) + "</em>" + html.EscapeString(text[end:])
+		}
+		wordStart = !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+
+	return html.EscapeString(text)
+}
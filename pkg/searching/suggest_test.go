@@ -0,0 +1,84 @@
+package searching
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildSuggestQuery(t *testing.T) {
+	tests := map[string]struct {
+		req   SuggestRequest
+		query string
+	}{
+		"completion": {
+			req:   SuggestRequest{Prefix: "r2", CompletionField: "suggest"},
+			query: `{"_source":false,"suggest":{"suggest":{"completion":{"field":"suggest","size":5,"skip_duplicates":true},"prefix":"r2"}}}`,
+		},
+		"search as you type": {
+			req:   SuggestRequest{Prefix: "r2", Fields: []string{"name", "name._2gram"}, Size: 3},
+			query: `{"_source":["name","name._2gram"],"query":{"multi_match":{"fields":["name","name._2gram"],"query":"r2","type":"bool_prefix"}},"size":3}`,
+		},
+		"default fields": {
+			req:   SuggestRequest{Prefix: "r2"},
+			query: `{"_source":["meta.title"],"query":{"multi_match":{"fields":["meta.title"],"query":"r2","type":"bool_prefix"}},"size":5}`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := json.Marshal(buildSuggestQuery(tc.req))
+			if err != nil {
+				t.Fatalf("Unexpected error marshalling query: %s", err)
+			}
+			if diff := cmp.Diff(tc.query, string(got)); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	var r suggestResults
+	body := `{"took":2,"hits":{"hits":[` +
+		`{"_source":{"meta":{"title":"R2D2 & friends"}}},` +
+		`{"_source":{"meta":{"title":"r2d2 & friends"}}},` +
+		`{"_source":{"meta":{"title":"Meet R2-D2"}}},` +
+		`{"_source":{"meta":{}}}]}}`
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		t.Fatalf("Unexpected error decoding response: %s", err)
+	}
+
+	got := suggestions(r, SuggestRequest{Prefix: "r2", Highlight: true})
+	want := &Suggestions{Took: 2, Suggestions: []Suggestion{
+		{Text: "R2D2 & friends", Highlighted: "<em>R2</em>D2 &amp; friends"},
+		{Text: "Meet R2-D2", Highlighted: "Meet <em>R2</em>-D2"},
+	}}
+
+	diff := cmp.Diff(want, got)
+	if diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestHighlightPrefix(t *testing.T) {
+	tests := map[string]struct {
+		text   string
+		prefix string
+		want   string
+	}{
+		"start of text":   {text: "Kubernetes docs", prefix: "kub", want: "<em>Kub</em>ernetes docs"},
+		"start of a word": {text: "Running kubernetes", prefix: "kub", want: "Running <em>kub</em>ernetes"},
+		"inside a word":   {text: "mykube", prefix: "kub", want: "mykube"},
+		"several words":   {text: "Star Wars", prefix: "star wa", want: "<em>Star Wa</em>rs"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, highlightPrefix(tc.text, tc.prefix)); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package searching
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The operators of the query syntax, which filter rather than match
+const (
+	siteOperator   = "site"
+	afterOperator  = "after"
+	beforeOperator = "before"
+)
+
+const (
+	// maxSyntaxTerms is the most terms, phrases and operators a search term can have in the query syntax
+	maxSyntaxTerms   = 32
+	syntaxDateLayout = "2006-01-02"
+	syntaxDateFormat = "yyyy-MM-dd"
+)
+
+// ErrQuerySyntax is returned when a search term isn't valid in the query syntax. Its errors are also of
+// kind ErrBadRequest.
+var ErrQuerySyntax = errors.New("invalid query syntax")
+
+// QuerySyntax parses the search terms of advanced searches, such as
+//
+//	title:"release notes" -draft site:docs after:2023-01-01
+//
+// into a bool query, rather than a query_string query clients could write expensive queries with:
+//   - words are matched like any search term, and "quoted phrases" as phrases
+//   - field:word and field:"phrase" match a field, by one of the prefixes of Fields
+//   - -word, -"phrase", -field:word and -site:name exclude the documents they match
+//   - a OR b matches either of the terms around OR, which binds tighter than the other terms
+//   - site:name keeps the documents whose Site field is name
+//   - after:yyyy-mm-dd and before:yyyy-mm-dd keep the documents whose Date field is after or before the date
+type QuerySyntax struct {
+	// Fields maps the prefixes users can type to the fields they match, e.g. title: meta.title
+	Fields map[string]string
+	Site   string
+	Date   string
+}
+
+// SyntaxError is an error at a position of a search term, in bytes from its start
+type SyntaxError struct {
+	Position int    `json:"position"`
+	Token    string `json:"token,omitempty"`
+	Message  string `json:"message"`
+}
+
+// SyntaxErrors are the errors found parsing a search term. They are of kinds ErrQuerySyntax and
+// ErrBadRequest.
+type SyntaxErrors []SyntaxError
+
+func (e SyntaxErrors) Error() string {
+	var msgs []string
+	for _, se := range e {
+		msgs = append(msgs, fmt.Sprintf("%s at %d", se.Message, se.Position))
+	}
+	return fmt.Sprintf("%s: %s", ErrQuerySyntax, strings.Join(msgs, "; "))
+}
+
+// Is reports whether the errors are of the target kind
+func (e SyntaxErrors) Is(target error) bool {
+	return target == ErrQuerySyntax || target == ErrBadRequest
+}
+
+// syntaxTerm is a word or phrase of the query syntax, matched against a field, or the fields searched
+// when field is empty
+type syntaxTerm struct {
+	field  string
+	text   string
+	phrase bool
+	// exact terms match keyword fields as they are
+	exact bool
+}
+
+// parsedQuery is a search term parsed from the query syntax: its free words, searched like any search
+// term, and the clauses built from the rest of it
+type parsedQuery struct {
+	text string
+	// must holds the terms that must match, one of each group
+	must    [][]syntaxTerm
+	mustNot []syntaxTerm
+}
+
+// syntaxToken is a term, phrase, operator or OR of a search term
+type syntaxToken struct {
+	pos    int
+	raw    string
+	neg    bool
+	prefix string
+	text   string
+	phrase bool
+	or     bool
+}
+
+// Parse parses the search term of an advanced search. Its operators are added to the filters of the
+// search, and the rest of the term is matched by the query built from it. Its errors are SyntaxErrors, or
+// of kind ErrBadRequest.
+func (q QuerySyntax) Parse(s SearchRequest) (SearchRequest, error) {
+	if !s.Advanced {
+		return s, nil
+	}
+	if s.profile != nil && s.profile.Template != "" {
+		return s, invalid(fmt.Errorf("search profile %q is a template, which can't be combined with advanced searches", s.Profile))
+	}
+
+	tokens, errs := tokenize(s.SearchTerm)
+	if len(tokens) > maxSyntaxTerms {
+		errs = append(errs, SyntaxError{Position: tokens[maxSyntaxTerms].pos, Token: tokens[maxSyntaxTerms].raw, Message: fmt.Sprintf("search terms can have at most %d terms", maxSyntaxTerms)})
+		tokens = tokens[:maxSyntaxTerms]
+	}
+
+	p := &parsedQuery{}
+	var words []string
+	orNext := false
+	for i, t := range tokens {
+		if t.or {
+			if i == 0 || i == len(tokens)-1 || tokens[i-1].or {
+				errs = append(errs, SyntaxError{Position: t.pos, Token: t.raw, Message: "OR needs a term on each side"})
+			} else if prev := tokens[i-1]; prev.neg || q.isOperator(prev.prefix) {
+				errs = append(errs, SyntaxError{Position: prev.pos, Token: prev.raw, Message: "only search terms can be combined with OR"})
+			} else {
+				orNext = true
+			}
+			continue
+		}
+
+		if q.isOperator(t.prefix) {
+			if orNext {
+				errs = append(errs, SyntaxError{Position: t.pos, Token: t.raw, Message: "only search terms can be combined with OR"})
+				orNext = false
+			}
+			f, term, err := q.operator(t)
+			switch {
+			case err != nil:
+				errs = append(errs, *err)
+			case t.neg:
+				p.mustNot = append(p.mustNot, term)
+			default:
+				s.Filters = append(s.Filters, f)
+			}
+			continue
+		}
+
+		term := syntaxTerm{text: t.text, phrase: t.phrase}
+		if t.prefix != "" {
+			field, ok := q.Fields[t.prefix]
+			if !ok {
+				errs = append(errs, SyntaxError{Position: t.pos, Token: t.raw, Message: q.unknownField(t.prefix)})
+				continue
+			}
+			term.field = field
+		}
+
+		switch {
+		case t.neg && orNext:
+			errs = append(errs, SyntaxError{Position: t.pos, Token: t.raw, Message: "only search terms can be combined with OR"})
+			orNext = false
+		case t.neg:
+			p.mustNot = append(p.mustNot, term)
+		case orNext:
+			// the term before OR is missing when it was invalid
+			if n := len(p.must); n > 0 {
+				p.must[n-1] = append(p.must[n-1], term)
+			}
+			orNext = false
+		default:
+			p.must = append(p.must, []syntaxTerm{term})
+		}
+	}
+	if len(errs) > 0 {
+		return s, errs
+	}
+
+	// words on their own are searched like any search term, so profiles and rules apply to them
+	var must [][]syntaxTerm
+	for _, g := range p.must {
+		if len(g) == 1 && g[0].field == "" && !g[0].phrase {
+			words = append(words, g[0].text)
+			continue
+		}
+		must = append(must, g)
+	}
+	p.text, p.must = strings.Join(words, " "), must
+	s.syntax = p
+	return s, nil
+}
+
+// isOperator reports whether the prefix is an operator rather than a field
+func (q QuerySyntax) isOperator(prefix string) bool {
+	return prefix == siteOperator || prefix == afterOperator || prefix == beforeOperator
+}
+
+// operator returns the filter of the operator, or for a negated operator the term it excludes
+func (q QuerySyntax) operator(t syntaxToken) (Filter, syntaxTerm, *SyntaxError) {
+	fail := func(msg string) (Filter, syntaxTerm, *SyntaxError) {
+		return Filter{}, syntaxTerm{}, &SyntaxError{Position: t.pos, Token: t.raw, Message: msg}
+	}
+
+	if t.prefix == siteOperator {
+		if q.Site == "" {
+			return fail("site: isn't available")
+		}
+		return Filter{Field: q.Site, Term: t.text}, syntaxTerm{field: q.Site, text: t.text, exact: true}, nil
+	}
+
+	if q.Date == "" {
+		return fail(t.prefix + ": isn't available")
+	}
+	if t.neg {
+		return fail(t.prefix + ": can't be negated")
+	}
+	if _, err := time.Parse(syntaxDateLayout, t.text); err != nil {
+		return fail(fmt.Sprintf("%s: needs a date as yyyy-mm-dd, got %q", t.prefix, t.text))
+	}
+	r := &Range{Format: syntaxDateFormat}
+	if t.prefix == afterOperator {
+		r.GT = t.text
+	} else {
+		r.LT = t.text
+	}
+	return Filter{Field: q.Date, Range: r}, syntaxTerm{}, nil
+}
+
+func (q QuerySyntax) unknownField(prefix string) string {
+	var prefixes []string
+	for p := range q.Fields {
+		prefixes = append(prefixes, p)
+	}
+	if len(prefixes) == 0 {
+		return fmt.Sprintf("unknown field %q, fields can't be searched", prefix)
+	}
+	sort.Strings(prefixes)
+	return fmt.Sprintf("unknown field %q, expected one of %s", prefix, strings.Join(prefixes, ", "))
+}
+
+// tokenize splits the search term into its terms, phrases, operators and ORs
+func tokenize(term string) ([]syntaxToken, SyntaxErrors) {
+	var (
+		tokens []syntaxToken
+		errs   SyntaxErrors
+	)
+
+	i := 0
+	for i < len(term) {
+		if term[i] == ' ' || term[i] == '\t' || term[i] == '\n' {
+			i++
+			continue
+		}
+
+		t := syntaxToken{pos: i}
+		if term[i] == '-' {
+			t.neg = true
+			i++
+		}
+
+		// a field or operator prefix is made of letters, so words such as 10:30 aren't prefixes
+		start := i
+		for i < len(term) && isPrefixByte(term[i]) {
+			i++
+		}
+		if i < len(term) && term[i] == ':' && i > start {
+			t.prefix = strings.ToLower(term[start:i])
+			i++
+		} else {
+			i = start
+		}
+
+		if i < len(term) && term[i] == '"' {
+			end := strings.IndexByte(term[i+1:], '"')
+			if end < 0 {
+				errs = append(errs, SyntaxError{Position: i, Token: term[t.pos:], Message: "unterminated quote"})
+				break
+			}
+			t.text, t.phrase = strings.TrimSpace(term[i+1:i+1+end]), true
+			i += end + 2
+		} else {
+			start := i
+			for i < len(term) && term[i] != ' ' && term[i] != '\t' && term[i] != '\n' && term[i] != '"' {
+				i++
+			}
+			t.text = term[start:i]
+		}
+		t.raw = term[t.pos:i]
+
+		switch {
+		case t.raw == "OR":
+			t.or = true
+		case t.text == "" && t.prefix != "":
+			errs = append(errs, SyntaxError{Position: t.pos, Token: t.raw, Message: fmt.Sprintf("%s: needs a value", t.prefix)})
+			continue
+		case t.text == "" && t.phrase:
+			errs = append(errs, SyntaxError{Position: t.pos, Token: t.raw, Message: "empty phrase"})
+			continue
+		case t.text == "":
+			errs = append(errs, SyntaxError{Position: t.pos, Token: t.raw, Message: "nothing to exclude"})
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, errs
+}
+
+func isPrefixByte(b byte) bool {
+	return b == '_' || b == '.' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// clause returns the query clause matching the term
+func (t syntaxTerm) clause(s SearchRequest) map[string]interface{} {
+	switch {
+	case t.exact:
+		return map[string]interface{}{"term": map[string]interface{}{t.field: t.text}}
+	case t.field == "":
+		mm := map[string]interface{}{"query": t.text}
+		if fields := s.fields(); len(fields) > 0 {
+			mm["fields"] = fields
+		}
+		if t.phrase {
+			mm["type"] = "phrase"
+		} else {
+			mm["operator"] = "and"
+		}
+		return map[string]interface{}{"multi_match": mm}
+	case t.phrase:
+		return map[string]interface{}{"match_phrase": map[string]interface{}{t.field: t.text}}
+	default:
+		return map[string]interface{}{"match": map[string]interface{}{t.field: map[string]interface{}{"query": t.text, "operator": "and"}}}
+	}
+}
+
+// syntaxQuery returns the query of an advanced search: the match of its free words, along with the clauses
+// of the rest of its search term. It returns the match of searches that aren't advanced.
+func syntaxQuery(match map[string]interface{}, s SearchRequest) map[string]interface{} {
+	p := s.syntax
+	if p == nil {
+		return match
+	}
+
+	var must []interface{}
+	if s.SearchTerm != "" {
+		must = append(must, match)
+	}
+	for _, g := range p.must {
+		if len(g) == 1 {
+			must = append(must, g[0].clause(s))
+			continue
+		}
+		var should []interface{}
+		for _, t := range g {
+			should = append(should, t.clause(s))
+		}
+		must = append(must, map[string]interface{}{"bool": map[string]interface{}{"should": should, "minimum_should_match": 1}})
+	}
+	if len(must) == 0 {
+		must = append(must, map[string]interface{}{"match_all": map[string]interface{}{}})
+	}
+
+	b := map[string]interface{}{"must": must}
+	if len(p.mustNot) > 0 {
+		var mustNot []interface{}
+		for _, t := range p.mustNot {
+			mustNot = append(mustNot, t.clause(s))
+		}
+		b["must_not"] = mustNot
+	}
+	return map[string]interface{}{"bool": b}
+}
@@ -0,0 +1,201 @@
+package searching
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testSyntax = QuerySyntax{
+	Fields: map[string]string{"title": "meta.title", "body": "source.p"},
+	Site:   "site",
+	Date:   "published",
+}
+
+func TestQuerySyntaxParse(t *testing.T) {
+	title := func(text string) map[string]interface{} {
+		return map[string]interface{}{"match": map[string]interface{}{"meta.title": map[string]interface{}{"query": text, "operator": "and"}}}
+	}
+	word := func(text string) map[string]interface{} {
+		return map[string]interface{}{"multi_match": map[string]interface{}{"query": text, "fields": []string{"name"}, "operator": "and"}}
+	}
+	match := func(text string) map[string]interface{} {
+		return map[string]interface{}{"multi_match": map[string]interface{}{"query": text, "fields": []string{"name"}}}
+	}
+
+	tests := map[string]struct {
+		term     string
+		text     string
+		filters  []Filter
+		expected map[string]interface{}
+	}{
+		"words": {
+			term:     "release notes",
+			text:     "release notes",
+			expected: map[string]interface{}{"bool": map[string]interface{}{"must": []interface{}{match("release notes")}}},
+		},
+		"example": {
+			term: `title:"release notes" -draft site:docs after:2023-01-01`,
+			filters: []Filter{
+				{Field: "site", Term: "docs"},
+				{Field: "published", Range: &Range{GT: "2023-01-01", Format: "yyyy-MM-dd"}},
+			},
+			expected: map[string]interface{}{"bool": map[string]interface{}{
+				"must":     []interface{}{map[string]interface{}{"match_phrase": map[string]interface{}{"meta.title": "release notes"}}},
+				"must_not": []interface{}{word("draft")},
+			}},
+		},
+		"phrase": {
+			term: `kubernetes "rolling update"`,
+			text: "kubernetes",
+			expected: map[string]interface{}{"bool": map[string]interface{}{"must": []interface{}{
+				match("kubernetes"),
+				map[string]interface{}{"multi_match": map[string]interface{}{"query": "rolling update", "fields": []string{"name"}, "type": "phrase"}},
+			}}},
+		},
+		"or": {
+			term: "deploy title:helm OR title:kustomize OR operator",
+			text: "deploy",
+			expected: map[string]interface{}{"bool": map[string]interface{}{"must": []interface{}{
+				match("deploy"),
+				map[string]interface{}{"bool": map[string]interface{}{
+					"should":               []interface{}{title("helm"), title("kustomize"), word("operator")},
+					"minimum_should_match": 1,
+				}},
+			}}},
+		},
+		"lowercase or": {
+			term:     "this or that",
+			text:     "this or that",
+			expected: map[string]interface{}{"bool": map[string]interface{}{"must": []interface{}{match("this or that")}}},
+		},
+		"negated site": {
+			term: "-site:blog before:2020-01-01 Title:go",
+			filters: []Filter{
+				{Field: "published", Range: &Range{LT: "2020-01-01", Format: "yyyy-MM-dd"}},
+			},
+			expected: map[string]interface{}{"bool": map[string]interface{}{
+				"must":     []interface{}{title("go")},
+				"must_not": []interface{}{map[string]interface{}{"term": map[string]interface{}{"site": "blog"}}},
+			}},
+		},
+		"only negations": {
+			term: `-"breaking change"`,
+			expected: map[string]interface{}{"bool": map[string]interface{}{
+				"must":     []interface{}{map[string]interface{}{"match_all": map[string]interface{}{}}},
+				"must_not": []interface{}{map[string]interface{}{"multi_match": map[string]interface{}{"query": "breaking change", "fields": []string{"name"}, "type": "phrase"}}},
+			}},
+		},
+		"not prefixes": {
+			term:     "C-3PO 10:30",
+			text:     "C-3PO 10:30",
+			expected: map[string]interface{}{"bool": map[string]interface{}{"must": []interface{}{match("C-3PO 10:30")}}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := testSyntax.Parse(SearchRequest{SearchTerm: tc.term, Fields: []string{"name"}, Advanced: true})
+			if err != nil {
+				t.Fatalf("Unexpected error parsing: %s", err)
+			}
+			if diff := cmp.Diff(tc.filters, s.Filters); diff != "" {
+				t.Fatalf(diff)
+			}
+			s = rewrite(s, nil)
+			if s.SearchTerm != tc.text {
+				t.Fatalf("free text - expected : %q, received : %q", tc.text, s.SearchTerm)
+			}
+			if diff := cmp.Diff(tc.expected, syntaxQuery(multiMatch(s), s)); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestQuerySyntaxErrors(t *testing.T) {
+	tests := map[string]struct {
+		syntax *QuerySyntax
+		term   string
+		errs   SyntaxErrors
+	}{
+		"unterminated": {
+			term: `title:"release notes`,
+			errs: SyntaxErrors{{Position: 6, Token: `title:"release notes`, Message: "unterminated quote"}},
+		},
+		"unknown field": {
+			term: "author:me",
+			errs: SyntaxErrors{{Position: 0, Token: "author:me", Message: `unknown field "author", expected one of body, title`}},
+		},
+		"no fields": {
+			syntax: &QuerySyntax{},
+			term:   "title:go",
+			errs:   SyntaxErrors{{Position: 0, Token: "title:go", Message: `unknown field "title", fields can't be searched`}},
+		},
+		"dangling or": {
+			term: "go OR",
+			errs: SyntaxErrors{{Position: 3, Token: "OR", Message: "OR needs a term on each side"}},
+		},
+		"negated or": {
+			term: "go OR -rust",
+			errs: SyntaxErrors{{Position: 6, Token: "-rust", Message: "only search terms can be combined with OR"}},
+		},
+		"operator or": {
+			term: "site:docs OR go",
+			errs: SyntaxErrors{{Position: 0, Token: "site:docs", Message: "only search terms can be combined with OR"}},
+		},
+		"several": {
+			term: `after:yesterday - "" title:`,
+			errs: SyntaxErrors{
+				{Position: 16, Token: "-", Message: "nothing to exclude"},
+				{Position: 18, Token: `""`, Message: "empty phrase"},
+				{Position: 21, Token: "title:", Message: "title: needs a value"},
+				{Position: 0, Token: "after:yesterday", Message: `after: needs a date as yyyy-mm-dd, got "yesterday"`},
+			},
+		},
+		"negated date": {
+			term: "-before:2020-01-01",
+			errs: SyntaxErrors{{Position: 0, Token: "-before:2020-01-01", Message: "before: can't be negated"}},
+		},
+		"no site": {
+			syntax: &QuerySyntax{},
+			term:   "site:docs",
+			errs:   SyntaxErrors{{Position: 0, Token: "site:docs", Message: "site: isn't available"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			syntax := testSyntax
+			if tc.syntax != nil {
+				syntax = *tc.syntax
+			}
+			_, err := syntax.Parse(SearchRequest{SearchTerm: tc.term, Advanced: true})
+			if !errors.Is(err, ErrQuerySyntax) || !errors.Is(err, ErrBadRequest) {
+				t.Fatalf("error should be a query syntax error, received : %v", err)
+			}
+			var errs SyntaxErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("error should hold the syntax errors, received : %v", err)
+			}
+			if diff := cmp.Diff(tc.errs, errs); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestQuerySyntaxNotAdvanced(t *testing.T) {
+	s, err := testSyntax.Parse(SearchRequest{SearchTerm: `title:"release notes`})
+	if err != nil || s.syntax != nil {
+		t.Fatalf("search terms should only be parsed for advanced searches, received : %v", err)
+	}
+}
+
+func TestQuerySyntaxTemplate(t *testing.T) {
+	s := SearchRequest{SearchTerm: "go", Profile: "docs", Advanced: true, profile: &Profile{Template: "docs-search"}}
+	if _, err := testSyntax.Parse(s); !errors.Is(err, ErrBadRequest) || errors.Is(err, ErrQuerySyntax) {
+		t.Fatalf("template searches can't be advanced, received : %v", err)
+	}
+}
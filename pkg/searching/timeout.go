@@ -0,0 +1,28 @@
+package searching
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// minShardTimeout is the shortest timeout passed to Elasticsearch, so an almost expired context still
+	// gets a chance at partial results
+	minShardTimeout = 10 * time.Millisecond
+)
+
+// shardTimeout returns the timeout Elasticsearch gives its shards to answer a search, a tenth short of the
+// context's deadline so partial results come back before the request is cancelled. It is 0, meaning no
+// timeout, when the context has no deadline.
+func shardTimeout(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+
+	t := time.Until(deadline) * 9 / 10
+	if t < minShardTimeout {
+		return minShardTimeout
+	}
+	return t
+}
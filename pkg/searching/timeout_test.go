@@ -0,0 +1,25 @@
+package searching
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShardTimeout(t *testing.T) {
+	if got := shardTimeout(context.Background()); got != 0 {
+		t.Fatalf("no deadline - expected : 0, received : %s", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if got := shardTimeout(ctx); got > 900*time.Millisecond || got < 800*time.Millisecond {
+		t.Fatalf("1s deadline - expected : about 900ms, received : %s", got)
+	}
+
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	if got := shardTimeout(expired); got != minShardTimeout {
+		t.Fatalf("expired deadline - expected : %s, received : %s", minShardTimeout, got)
+	}
+}
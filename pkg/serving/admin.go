@@ -0,0 +1,163 @@
+package serving
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
+)
+
+func (s *Server) handleGetPins() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		index := r.URL.Query().Get("i")
+		if index == "" {
+			s.badRequest(w, r, fmt.Errorf("Missing query string parameters"))
+			return
+		}
+
+		if err := s.indexMap(r).CheckLogIndex(index); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		pins, err := s.Searcher.Pins(r.Context(), index)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.ok(w, r, pins)
+	}
+}
+
+func (s *Server) handlePutPin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var p searching.Pin
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			s.badRequest(w, r, err)
+			return
+		}
+		if err := p.Validate(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if err := s.indexMap(r).CheckLogIndex(p.Index); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		pin, err := s.Searcher.Pin(r.Context(), p)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.ok(w, r, pin)
+	}
+}
+
+func (s *Server) handleDeletePin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		index, query := r.URL.Query().Get("i"), r.URL.Query().Get("q")
+		if index == "" || query == "" {
+			s.badRequest(w, r, fmt.Errorf("Missing query string parameters"))
+			return
+		}
+
+		if err := s.indexMap(r).CheckLogIndex(index); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err := s.Searcher.Unpin(r.Context(), index, query); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleGetRules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		index := r.URL.Query().Get("i")
+		if index == "" {
+			s.badRequest(w, r, fmt.Errorf("Missing query string parameters"))
+			return
+		}
+
+		if err := s.indexMap(r).CheckLogIndex(index); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		rules, err := s.Searcher.Rules(r.Context(), index)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.ok(w, r, rules)
+	}
+}
+
+func (s *Server) handlePutRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rule searching.Rule
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			s.badRequest(w, r, err)
+			return
+		}
+		if err := rule.Validate(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if err := rule.ValidateRedirect(s.Config.Rules.RedirectHosts); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if err := s.indexMap(r).CheckLogIndex(rule.Index); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		stored, err := s.Searcher.PutRule(r.Context(), rule)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.ok(w, r, stored)
+	}
+}
+
+func (s *Server) handleDeleteRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		index, name := r.URL.Query().Get("i"), r.URL.Query().Get("name")
+		if index == "" || name == "" {
+			s.badRequest(w, r, fmt.Errorf("Missing query string parameters"))
+			return
+		}
+
+		if err := s.indexMap(r).CheckLogIndex(index); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err := s.Searcher.DeleteRule(r.Context(), index, name); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
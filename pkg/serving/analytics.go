@@ -0,0 +1,133 @@
+package serving
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
+)
+
+const (
+	defaultTopQueriesWindow = 7 * 24 * time.Hour
+	defaultTrendingWindow   = 24 * time.Hour
+)
+
+func (s *Server) handleTopQueries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		req, err := parseAnalyticsRequest(r.URL.Query(), defaultTopQueriesWindow)
+		if err != nil {
+			s.badRequest(w, r, err)
+			return
+		}
+		if err := s.indexMap(r).CheckLogIndex(req.Index); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		top, err := s.Searcher.TopQueries(r.Context(), req)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.ok(w, r, top)
+	}
+}
+
+func (s *Server) handleTrendingQueries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		req, err := parseAnalyticsRequest(r.URL.Query(), defaultTrendingWindow)
+		if err != nil {
+			s.badRequest(w, r, err)
+			return
+		}
+		if err := s.indexMap(r).CheckLogIndex(req.Index); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		trending, err := s.Searcher.TrendingQueries(r.Context(), req)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.ok(w, r, trending)
+	}
+}
+
+func (s *Server) handleClick() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var c searching.Click
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			s.badRequest(w, r, err)
+			return
+		}
+		if err := c.Validate(); err != nil {
+			s.badRequest(w, r, err)
+			return
+		}
+		if err := s.indexMap(r).CheckLogIndex(c.Index); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err := s.Searcher.RecordClick(r.Context(), c); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// parseAnalyticsRequest reads the i, window, to and size query string parameters into an analytics request
+func parseAnalyticsRequest(v url.Values, defaultWindow time.Duration) (req searching.AnalyticsRequest, err error) {
+	req.Index = v.Get("i")
+	req.Window = defaultWindow
+
+	if w := v.Get("window"); w != "" {
+		if req.Window, err = parseWindow(w); err != nil {
+			return req, err
+		}
+	}
+	if to := v.Get("to"); to != "" {
+		if req.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return req, fmt.Errorf("to must be an RFC 3339 date, got %q", to)
+		}
+	}
+	if sz := v.Get("size"); sz != "" {
+		if req.Size, err = strconv.Atoi(sz); err != nil {
+			return req, fmt.Errorf("size must be a number, got %q", sz)
+		}
+	}
+
+	return req, req.Validate()
+}
+
+// parseWindow parses a duration such as 90m or 12h, with d added for days, e.g. 7d
+func parseWindow(w string) (time.Duration, error) {
+	if strings.HasSuffix(w, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(w, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("window must be a duration such as 7d or 12h, got %q", w)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(w)
+	if err != nil {
+		return 0, fmt.Errorf("window must be a duration such as 7d or 12h, got %q", w)
+	}
+	return d, nil
+}
@@ -0,0 +1,51 @@
+package serving
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := map[string]struct {
+		window   string
+		duration time.Duration
+		valid    bool
+	}{
+		"days":      {window: "7d", duration: 7 * 24 * time.Hour, valid: true},
+		"hours":     {window: "12h", duration: 12 * time.Hour, valid: true},
+		"bad days":  {window: "xd", valid: false},
+		"malformed": {window: "week", valid: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := parseWindow(tc.window)
+			if (err == nil) != tc.valid {
+				t.Fatalf("valid - expected : %t, received error : %v", tc.valid, err)
+			}
+			if diff := cmp.Diff(tc.duration, d); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestParseAnalyticsRequest(t *testing.T) {
+	v, _ := url.ParseQuery("i=droids&window=2d&to=2020-01-08T00:00:00Z&size=5")
+
+	req, err := parseAnalyticsRequest(v, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing request: %s", err)
+	}
+
+	if req.Index != "droids" || req.Window != 48*time.Hour || req.Size != 5 || !req.To.Equal(time.Date(2020, 1, 8, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected analytics request : %+v", req)
+	}
+
+	if _, err := parseAnalyticsRequest(url.Values{"i": {"droids"}, "to": {"yesterday"}}, time.Hour); err == nil {
+		t.Fatal("expected an error for a malformed to date")
+	}
+}
@@ -4,8 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/wambozi/elastic-search-api/m/pkg/searching"
+	"github.com/wambozi/elastic-search-api/m/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Response is a concrete representation of the response to the client calling the crawl
@@ -14,63 +20,261 @@ type Response struct {
 	Message string `json:"url"`
 }
 
-type errorResponse struct {
-	Error string `json:"error"`
-}
+const (
+	filterParamPrefix = "filter."
+	boostParamPrefix  = "boost."
+)
 
 func (s *Server) handleCrawl() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var b searching.SearchRequest
-		var results *searching.Results
+		var req searching.SearchRequest
+		var err error
+
+		ctx, span := tracing.Start(r.Context(), "serving.handleCrawl")
+		defer span.End()
+		r = r.WithContext(ctx)
 
 		w.Header().Set("Content-Type", "application/json")
 
 		if r.Method == "POST" {
-			err := json.NewDecoder(r.Body).Decode(&b)
-			if err != nil {
-				s.Log.Error(err)
-				er := errorResponse{Error: err.Error()}
-				ers, _ := json.Marshal(er)
-
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write(ers)
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				// a body that doesn't fit the request shape (e.g. a malformed filter) is the client's mistake
+				s.badRequest(w, r, err)
 				return
 			}
-			results = searching.Search(s.ElasticClient, r, b, s.Log)
 		}
 
 		if r.Method == "GET" {
 			q, ok := r.URL.Query()["qt"]
-			i, ok := r.URL.Query()["i"]
-
-			if !ok || len(q[0]) < 1 || len(i[0]) < 1 {
-				s.Log.Errorf("Bad request: Missing query string parameters")
-				er := errorResponse{Error: "Bad request: Missing query string parameters"}
-				ers, _ := json.Marshal(er)
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write(ers)
+			i := parseIndices(r.URL.Query())
+
+			if !ok || len(q[0]) < 1 || len(i) < 1 {
+				s.badRequest(w, r, fmt.Errorf("Missing query string parameters"))
 				return
 			}
 
-			req := searching.SearchRequest{
+			req = searching.SearchRequest{
 				SearchTerm: q[0],
-				Index:      i[0],
-				Fields:     []string{"meta.description^2", "meta.title", "source.h1", "source.h2", "source.p"},
+				Indices:    i,
+				Profile:    r.URL.Query().Get("profile"),
+			}
+			// template profiles own their query, highlighting included
+			profile := req.Profile
+			if profile == "" {
+				profile = searching.DefaultProfile
+			}
+			if p, _ := s.Profiles.Get(profile); p.Template == "" {
+				highlight := searching.DefaultHighlight
+				req.Highlight = &highlight
+			}
+			if err := parsePagination(r.URL.Query(), &req); err != nil {
+				s.badRequest(w, r, err)
+				return
+			}
+			if req.Filters, err = parseFilters(r.URL.Query()); err != nil {
+				s.badRequest(w, r, err)
+				return
+			}
+			if req.IndicesBoost, err = parseBoosts(r.URL.Query()); err != nil {
+				s.badRequest(w, r, err)
+				return
+			}
+			if req.Sort, err = searching.ParseSort(r.URL.Query().Get("sort"), r.URL.Query().Get("near")); err != nil {
+				s.badRequest(w, r, err)
+				return
+			}
+			req.Source = parseSource(r.URL.Query())
+			req.SpellCheck = r.URL.Query().Get("spellcheck") == "true"
+			req.AutoCorrect = r.URL.Query().Get("autocorrect") == "true"
+			req.Advanced = r.URL.Query().Get("advanced") == "true"
+		}
+
+		if err := req.Validate(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if req, err = s.Profiles.Apply(req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if req, err = s.querySyntax().Parse(req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if req, err = s.sortAllowlist().Check(req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		req = s.tiebreakers().Apply(req)
+		if req, err = s.indexMap(r).Resolve(req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		span.SetAttributes(attribute.String("search.profile", req.Profile), attribute.Bool("search.advanced", req.Advanced))
+		results, err := s.Searcher.Search(r, req)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if results.TimedOut {
+			// some shards didn't answer in time: respond with the partial hits and the shards that did
+			s.Log.Warnf("Search timed out with %d of %d shards successful", results.Shards.Successful, results.Shards.Total)
+			s.respond(w, r, http.StatusGatewayTimeout, results)
+			return
+		}
+		s.ok(w, r, results)
+	}
+}
+
+func (s *Server) ok(w http.ResponseWriter, r *http.Request, v interface{}) {
+	s.respond(w, r, http.StatusOK, v)
+}
+
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	_, span := tracing.Start(r.Context(), "serving.respond")
+	defer span.End()
+
+	response, err := json.Marshal(v)
+	if err != nil {
+		s.problem(w, r, http.StatusInternalServerError, "internal", fmt.Errorf("Failed to marshal %+v: %s", v, err))
+		return
+	}
+	span.SetAttributes(attribute.Int("http.response_content_length", len(response)))
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// parsePagination reads the page, size and cursor query string parameters into the search request
+func parsePagination(v url.Values, req *searching.SearchRequest) (err error) {
+	if p := v.Get("page"); p != "" {
+		if req.Page, err = strconv.Atoi(p); err != nil {
+			return fmt.Errorf("page must be a number, got %q", p)
+		}
+	}
+	if sz := v.Get("size"); sz != "" {
+		if req.Size, err = strconv.Atoi(sz); err != nil {
+			return fmt.Errorf("size must be a number, got %q", sz)
+		}
+	}
+	req.Cursor = v.Get("cursor")
+
+	return nil
+}
+
+// parseFilters reads the repeated `filter.<field>=` query string parameters into search filters
+func parseFilters(v url.Values) ([]searching.Filter, error) {
+	var fields []string
+	for k := range v {
+		if strings.HasPrefix(k, filterParamPrefix) {
+			fields = append(fields, k)
+		}
+	}
+	// sort so the generated query, and any error, is the same for the same URL
+	sort.Strings(fields)
+
+	var filters []searching.Filter
+	for _, k := range fields {
+		f, err := searching.ParseFilter(strings.TrimPrefix(k, filterParamPrefix), v[k])
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
+
+// parseIndices reads the indices to search from the `i` query string parameter, which can be repeated or
+// comma separated
+func parseIndices(v url.Values) []string {
+	var indices []string
+	for _, i := range v["i"] {
+		for _, name := range strings.Split(i, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				indices = append(indices, name)
 			}
-			results = searching.Search(s.ElasticClient, r, req, s.Log)
 		}
+	}
+	return indices
+}
 
-		response, err := json.Marshal(results)
+// parseBoosts reads the `boost.<index>=` query string parameters into index boosts
+func parseBoosts(v url.Values) (map[string]float64, error) {
+	var boosts map[string]float64
+	for k := range v {
+		if !strings.HasPrefix(k, boostParamPrefix) {
+			continue
+		}
+		b, err := strconv.ParseFloat(v.Get(k), 64)
 		if err != nil {
-			es := fmt.Sprintf("Failed to marshal %+v", results)
-			er := errorResponse{Error: es}
-			ers, _ := json.Marshal(er)
+			return nil, fmt.Errorf("%s must be a number, got %q", k, v.Get(k))
+		}
+		if boosts == nil {
+			boosts = map[string]float64{}
+		}
+		boosts[strings.TrimPrefix(k, boostParamPrefix)] = b
+	}
+	return boosts, nil
+}
 
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(ers)
+// parseSource reads the comma separated includes and excludes query string parameters into a _source filter
+func parseSource(v url.Values) *searching.SourceFilter {
+	includes, excludes := v.Get("includes"), v.Get("excludes")
+	if includes == "" && excludes == "" {
+		return nil
+	}
+
+	f := &searching.SourceFilter{}
+	if includes != "" {
+		f.Includes = strings.Split(includes, ",")
+	}
+	if excludes != "" {
+		f.Excludes = strings.Split(excludes, ",")
+	}
+	return f
+}
+
+func (s *Server) handleSuggest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		v := r.URL.Query()
+		opts := s.config().Suggest
+		req := searching.SuggestRequest{
+			Prefix:          v.Get("q"),
+			Index:           v.Get("i"),
+			CompletionField: opts.CompletionField,
+			Fields:          opts.Fields,
+			Size:            opts.Size,
+			Highlight:       v.Get("highlight") == "true",
+			LogQuery:        opts.LogQueries,
+		}
+		if sz := v.Get("size"); sz != "" {
+			size, err := strconv.Atoi(sz)
+			if err != nil {
+				s.badRequest(w, r, fmt.Errorf("size must be a number, got %q", sz))
+				return
+			}
+			req.Size = size
+		}
+		if err := req.Validate(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		req, err := s.indexMap(r).ResolveSuggest(req)
+		if err != nil {
+			s.fail(w, r, err)
 			return
 		}
-		w.WriteHeader(http.StatusAccepted)
-		w.Write(response)
+
+		suggestions, err := s.Searcher.Suggest(r, req)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		s.ok(w, r, suggestions)
 	}
 }
@@ -3,25 +3,34 @@ package serving
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/auth"
 	"github.com/wambozi/elastic-search-api/m/pkg/clients"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
 	"github.com/wambozi/elastic-search-api/m/pkg/searching"
 )
 
 func TestHandleIndex(t *testing.T) {
-	r := httprouter.New()
 	l := logrus.New()
-	cfg := clients.GenerateElasticConfig([]string{ee}, username, password)
-	ec, err := clients.CreateElasticClient(cfg)
-	if err != nil {
-		t.Errorf("Unexpected error creating Elasticsearch client: %s", err)
-	}
+
+	// Elasticsearch with a document in the test index
+	es := estest.NewServer()
+	defer es.Close()
+	es.Index("test", "1", `{"text":"a test document"}`)
+
+	// Elasticsearch answering that the index doesn't exist
+	notFound := estest.NewServer()
+	defer notFound.Close()
 
 	type results struct {
 		Body       string
@@ -34,7 +43,12 @@ func TestHandleIndex(t *testing.T) {
 		body       string
 		log        *logrus.Logger
 	}{
-		"elasticsearch": {server: &Server{ElasticClient: ec, Router: r, Log: l}, statusCode: 202, body: "null"},
+		"index-not-found": {
+			server:     &Server{Searcher: searching.NewElastic(notFound.Client(), nil, l), Router: httprouter.New(), Log: l},
+			statusCode: 404,
+			body:       `{"type":"/problems/index-not-found","title":"Not Found","status":404,"detail":"[404 Not Found] Error searching test: index_not_found_exception: no such index [test]","instance":"/search","requestId":"test-request"}`,
+		},
+		"elasticsearch": {server: &Server{Searcher: searching.NewElastic(es.Client(), nil, l), Router: httprouter.New(), Log: l}, statusCode: 200},
 		// TODO: figure out why this panics...
 		// "app-search":    {server: &Server{AppsearchClient: ac, ElasticClient: ec, Router: r, Log: l}, statusCode: 202, body: `{"status":201,"url":"https://www.example.com","type":"app-search","engine":"test"}`},
 		// "bad-request":   {server: &Server{AppsearchClient: ac, ElasticClient: ec, Router: r, Log: l}, statusCode: 400, body: `{"status":201,"url":"https://www.example.com","type":"test","index":"test"}`},
@@ -53,6 +67,7 @@ func TestHandleIndex(t *testing.T) {
 			if err != nil {
 				t.Fatalf("new request error: %+v", err)
 			}
+			req.Header.Set("X-Request-ID", "test-request")
 			w := httptest.NewRecorder()
 			tc.server.Router.ServeHTTP(w, req)
 
@@ -64,6 +79,10 @@ func TestHandleIndex(t *testing.T) {
 			}
 
 			body := buf.String()
+			if tc.body == "" {
+				// search IDs and timings vary, only check the status
+				body = ""
+			}
 
 			gotRes := results{
 				Body:       body,
@@ -84,3 +103,357 @@ func TestHandleIndex(t *testing.T) {
 	}
 
 }
+
+func newMemoryServer(t *testing.T) (*Server, *searching.Memory) {
+	m := searching.NewMemory()
+	for id, doc := range map[string]string{
+		"1": `{"meta":{"title":"R2-D2","description":"An astromech droid"}}`,
+		"2": `{"meta":{"title":"C-3PO","description":"A protocol droid"}}`,
+	} {
+		if _, errs := m.IndexDocument(clients.Document{Index: "droids", DocumentID: id, Body: strings.NewReader(doc)}); len(errs) > 0 {
+			t.Fatalf("Unexpected error indexing document %s: %s", id, errs[0])
+		}
+	}
+
+	s := NewServer(&conf.Configuration{}, m, nil, httprouter.New(), logrus.New())
+	return s, m
+}
+
+// testKey is the API key of the client authenticate gives every scope
+const testKey = "test-key"
+
+// authenticate has the server authenticate requests, so the analytics and admin routes are open to the
+// ones made with newRequest
+func authenticate(t *testing.T, s *Server) {
+	a, err := auth.New(conf.AuthOptions{
+		APIKeys: []conf.APIKeyOptions{{Client: "test", SHA256: auth.HashKey(testKey)}},
+		Clients: map[string]conf.ClientOptions{
+			"test": {Scopes: []string{auth.ScopeSearch, auth.ScopeAnalytics, auth.ScopeAdmin}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating authenticator: %s", err)
+	}
+	s.Auth = a
+}
+
+// newRequest returns a request made with testKey
+func newRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(auth.APIKeyHeader, testKey)
+	return req
+}
+
+func TestRoutesOffline(t *testing.T) {
+	tests := map[string]struct {
+		method     string
+		url        string
+		body       string
+		err        error
+		statusCode int
+		contains   string
+	}{
+		"get":               {method: "GET", url: "/search?qt=droid&i=droids", statusCode: 200, contains: `"_id":"2"`},
+		"post":              {method: "POST", url: "/search", body: `{"searchTerm":"protocol","index":"droids"}`, statusCode: 200, contains: `"_id":"2"`},
+		"missing params":    {method: "GET", url: "/search?qt=droid", statusCode: 400, contains: `"type":"/problems/bad-request"`},
+		"malformed body":    {method: "POST", url: "/search", body: `{`, statusCode: 400, contains: `"type":"/problems/bad-request"`},
+		"index not found":   {method: "GET", url: "/search?qt=droid&i=jedi", statusCode: 404, contains: `"type":"/problems/index-not-found"`},
+		"unavailable":       {method: "GET", url: "/search?qt=droid&i=droids", err: &searching.Error{Kind: searching.ErrUnavailable, Err: fmt.Errorf("connection refused")}, statusCode: 503, contains: `"type":"/problems/upstream-unavailable"`},
+		"suggest":           {method: "GET", url: "/suggest?q=r2&i=droids", statusCode: 200, contains: `"text":"R2-D2"`},
+		"top queries":       {method: "GET", url: "/analytics/top-queries?i=droids", statusCode: 200, contains: `"queries":[]`},
+		"trending":          {method: "GET", url: "/analytics/trending?i=droids", statusCode: 200, contains: `"queries":[]`},
+		"click unknown":     {method: "POST", url: "/analytics/click", body: `{"searchId":"a","index":"droids","documentId":"1"}`, statusCode: 404, contains: `"type":"/problems/search-not-found"`},
+		"analytics invalid": {method: "GET", url: "/analytics/top-queries?i=droids&size=1000", statusCode: 400, contains: `"type":"/problems/bad-request"`},
+		"sort not allowed":  {method: "GET", url: "/search?qt=droid&i=droids&sort=meta.title", statusCode: 400, contains: `\"meta.title\" can't be sorted on in droids`},
+		"sort by score":     {method: "GET", url: "/search?qt=droid&i=droids&sort=_score:desc", statusCode: 200, contains: `"_id":"2"`},
+		"bad sort order":    {method: "POST", url: "/search", body: `{"searchTerm":"droid","index":"droids","sort":[{"field":"_score","order":"up"}]}`, statusCode: 400, contains: `must be asc or desc`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, m := newMemoryServer(t)
+			authenticate(t, s)
+			m.Err = tc.err
+
+			req := newRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			s.Router.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("status code - expected : %d, received : %d (%s)", tc.statusCode, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tc.contains) {
+				t.Fatalf("body should contain %s, received : %s", tc.contains, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestClickOffline(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	authenticate(t, s)
+
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, newRequest("GET", "/search?qt=droid&i=droids", nil))
+	var res searching.Results
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("could not decode results: %s", err)
+	}
+
+	click := fmt.Sprintf(`{"searchId":%q,"index":%q,"documentId":"1"}`, res.SearchID, res.LogIndex)
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, newRequest("POST", "/analytics/click", strings.NewReader(click)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status code - expected : %d, received : %d (%s)", http.StatusNoContent, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, newRequest("GET", "/analytics/top-queries?i="+res.LogIndex, nil))
+	if !strings.Contains(w.Body.String(), `"clickThroughRate":1`) {
+		t.Fatalf("the click should count, received : %s", w.Body.String())
+	}
+}
+
+func TestClickWildcardOffline(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	authenticate(t, s)
+	s.Config = &conf.Configuration{Indices: conf.IndicesOptions{Allow: []string{"droid*"}}}
+
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, newRequest("GET", "/search?qt=droid&i=droid*", nil))
+	var res searching.Results
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("could not decode results: %s", err)
+	}
+	if res.LogIndex != "droid" {
+		t.Fatalf("log index - expected : droid, received : %q", res.LogIndex)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		statusCode int
+		contains   string
+	}{
+		{name: "click", method: "POST", url: "/analytics/click", body: fmt.Sprintf(`{"searchId":%q,"index":"droid","documentId":"1"}`, res.SearchID), statusCode: 204},
+		{name: "analytics", method: "GET", url: "/analytics/top-queries?i=droid", statusCode: 200, contains: `"clickThroughRate":1`},
+		{name: "other index", method: "POST", url: "/analytics/click", body: fmt.Sprintf(`{"searchId":%q,"index":"ships","documentId":"1"}`, res.SearchID), statusCode: 403, contains: `"type":"/problems/index-not-allowed"`},
+		{name: "pattern", method: "POST", url: "/analytics/click", body: fmt.Sprintf(`{"searchId":%q,"index":"droid*","documentId":"1"}`, res.SearchID), statusCode: 400, contains: `"type":"/problems/bad-request"`},
+		{name: "unlogged", method: "POST", url: "/analytics/click", body: `{"searchId":"unknown","index":"droid","documentId":"1"}`, statusCode: 404, contains: `"type":"/problems/search-not-found"`},
+	}
+
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, newRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+
+		if w.Code != tc.statusCode {
+			t.Fatalf("%s: status code - expected : %d, received : %d (%s)", tc.name, tc.statusCode, w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), tc.contains) {
+			t.Fatalf("%s: body should contain %s, received : %s", tc.name, tc.contains, w.Body.String())
+		}
+	}
+}
+
+func TestIndexMapOffline(t *testing.T) {
+	s, m := newMemoryServer(t)
+	authenticate(t, s)
+	if _, errs := m.IndexDocument(clients.Document{Index: "ships", DocumentID: "1", Body: strings.NewReader(`{"meta":{"title":"Millennium Falcon","description":"A droid-piloted ship"}}`)}); len(errs) > 0 {
+		t.Fatalf("Unexpected error indexing document: %s", errs[0])
+	}
+	s.Config = &conf.Configuration{Indices: conf.IndicesOptions{
+		Aliases: map[string][]string{"fleet": {"sh*"}},
+		Allow:   []string{"droids"},
+	}, Sortable: map[string][]conf.SortableField{
+		"droids": {{Field: "published", Type: "date"}},
+	}}
+
+	tests := map[string]struct {
+		url        string
+		statusCode int
+		contains   string
+	}{
+		"allowed":      {url: "/search?qt=droid&i=droids", statusCode: 200, contains: `"_index":"droids"`},
+		"alias":        {url: "/search?qt=droid&i=fleet", statusCode: 200, contains: `"_index":"ships","_type":"_doc","_id":"1","_score":1,"_source":{"meta":{"title":"Millennium Falcon","description":"A droid-piloted ship"}},"sort":[1,"ships/1"],"searchedAs":"fleet"`},
+		"boosted":      {url: "/search?qt=droid&i=droids,fleet&boost.fleet=3", statusCode: 200, contains: `"hits":[{"_index":"ships","_type":"_doc","_id":"1","_score":3`},
+		"repeated":     {url: "/search?qt=droid&i=droids&i=fleet&boost.droids=3", statusCode: 200, contains: `"hits":[{"_index":"droids","_type":"_doc","_id":"1","_score":3`},
+		"not allowed":  {url: "/search?qt=droid&i=ships", statusCode: 403, contains: `"type":"/problems/index-not-allowed"`},
+		"bad boost":    {url: "/search?qt=droid&i=droids&boost.droids=high", statusCode: 400, contains: `boost.droids must be a number`},
+		"boost unused": {url: "/search?qt=droid&i=droids&boost.jedi=2", statusCode: 400, contains: `boosted index \"jedi\" isn't searched`},
+		"sortable":     {url: "/search?qt=droid&i=droids&sort=published,_score", statusCode: 200, contains: `"_index":"droids"`},
+		"not sortable": {url: "/search?qt=droid&i=droids,fleet&sort=published", statusCode: 400, contains: `\"published\" can't be sorted on in fleet`},
+		"suggest":      {url: "/suggest?q=mill&i=fleet", statusCode: 200, contains: `"text":"Millennium Falcon"`},
+		"analytics":    {url: "/analytics/top-queries?i=ships", statusCode: 403, contains: `"type":"/problems/index-not-allowed"`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.Router.ServeHTTP(w, newRequest("GET", tc.url, nil))
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("status code - expected : %d, received : %d (%s)", tc.statusCode, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tc.contains) {
+				t.Fatalf("body should contain %s, received : %s", tc.contains, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestProfilesOffline(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	profiles, err := searching.NewProfiles(SearchProfiles(&conf.Configuration{Profiles: map[string]conf.ProfileOptions{
+		"titles": {Fields: []string{"meta.title"}, Operator: "and"},
+		"docs":   {Template: "docs-search"},
+	}}))
+	if err != nil {
+		t.Fatalf("Unexpected error creating profiles: %s", err)
+	}
+	s.Profiles = profiles
+
+	tests := map[string]struct {
+		method     string
+		url        string
+		body       string
+		statusCode int
+		contains   string
+	}{
+		"default":                 {method: "GET", url: "/search?qt=protocol&i=droids", statusCode: 200, contains: `"_id":"2"`},
+		"profile":                 {method: "GET", url: "/search?qt=protocol&i=droids&profile=titles", statusCode: 200, contains: `"hits":null`},
+		"profile matches":         {method: "GET", url: "/search?qt=c-3po&i=droids&profile=titles", statusCode: 200, contains: `"_id":"2"`},
+		"post profile":            {method: "POST", url: "/search", body: `{"searchTerm":"protocol","index":"droids","profile":"titles"}`, statusCode: 200, contains: `"hits":null`},
+		"unknown":                 {method: "GET", url: "/search?qt=protocol&i=droids&profile=jedi", statusCode: 400, contains: `unknown search profile \"jedi\", expected one of default, docs, titles`},
+		"template":                {method: "GET", url: "/search?qt=protocol&i=droids&profile=docs", statusCode: 200},
+		"post template highlight": {method: "POST", url: "/search", body: `{"searchTerm":"protocol","index":"droids","profile":"docs","highlight":{}}`, statusCode: 400, contains: `search profile \"docs\" is a template`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.Router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("status code - expected : %d, received : %d (%s)", tc.statusCode, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tc.contains) {
+				t.Fatalf("body should contain %s, received : %s", tc.contains, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPinsOffline(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	authenticate(t, s)
+
+	// each step depends on the ones before it
+	steps := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		statusCode int
+		contains   string
+	}{
+		{name: "pin", method: "PUT", url: "/admin/pins", body: `{"index":"droids","query":"Droid","ids":["2"]}`, statusCode: 200, contains: `"query":"droid"`},
+		{name: "pinned first", method: "GET", url: "/search?qt=droid&i=droids", statusCode: 200, contains: `"hits":[{"_index":"droids","_type":"_doc","_id":"2"`},
+		{name: "pinned flag", method: "GET", url: "/search?qt=droid&i=droids", statusCode: 200, contains: `"pinned":true`},
+		{name: "unmatched pin", method: "PUT", url: "/admin/pins", body: `{"index":"droids","query":"astromech","ids":["2","1"]}`, statusCode: 200, contains: `"ids":["2","1"]`},
+		{name: "unmatched first", method: "GET", url: "/search?qt=astromech&i=droids", statusCode: 200, contains: `"hits":[{"_index":"droids","_type":"_doc","_id":"2"`},
+		{name: "list", method: "GET", url: "/admin/pins?i=droids", statusCode: 200, contains: `{"pins":[{"index":"droids","query":"astromech"`},
+		{name: "invalid", method: "PUT", url: "/admin/pins", body: `{"index":"droids","query":"droid"}`, statusCode: 400, contains: "pins need between 1 and 20 document IDs, got 0"},
+		{name: "unpin", method: "DELETE", url: "/admin/pins?i=droids&q=droid", statusCode: 204},
+		{name: "unpinned", method: "DELETE", url: "/admin/pins?i=droids&q=droid", statusCode: 404, contains: "pin-not-found"},
+		{name: "missing query", method: "DELETE", url: "/admin/pins?i=droids", statusCode: 400, contains: "Missing query string parameters"},
+	}
+
+	for _, tc := range steps {
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, newRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+
+		if w.Code != tc.statusCode {
+			t.Fatalf("%s: status code - expected : %d, received : %d (%s)", tc.name, tc.statusCode, w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), tc.contains) {
+			t.Fatalf("%s: body should contain %s, received : %s", tc.name, tc.contains, w.Body.String())
+		}
+	}
+}
+
+func TestRulesOffline(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	authenticate(t, s)
+
+	// each step depends on the ones before it
+	steps := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		statusCode int
+		contains   string
+	}{
+		{name: "replace", method: "PUT", url: "/admin/rules", body: `{"index":"droids","name":"r2","type":"replace","terms":["r2"],"replacement":"astromech"}`, statusCode: 200, contains: `"replacement":"astromech"`},
+		{name: "synonyms", method: "PUT", url: "/admin/rules", body: `{"index":"droids","name":"robots","type":"synonyms","terms":["robot","droid"]}`, statusCode: 200, contains: `"type":"synonyms"`},
+		{name: "redirect", method: "PUT", url: "/admin/rules", body: `{"index":"droids","name":"shop","type":"redirect","terms":["buy a droid"],"url":"/shop"}`, statusCode: 200, contains: `"url":"/shop"`},
+		{name: "open redirect", method: "PUT", url: "/admin/rules", body: `{"index":"droids","name":"evil","type":"redirect","terms":["free droids"],"url":"https://evil.example"}`, statusCode: 400, contains: `redirect URL must be a path of the site, or on an allowed host, got \"https://evil.example\"`},
+		{name: "scheme relative", method: "PUT", url: "/admin/rules", body: `{"index":"droids","name":"evil","type":"redirect","terms":["free droids"],"url":"//evil.example"}`, statusCode: 400, contains: `redirect URL must be an http(s) URL or a path, got \"//evil.example\"`},
+		{name: "rewritten", method: "GET", url: "/search?qt=r2&i=droids", statusCode: 200, contains: `"rewrittenQuery":"astromech"`},
+		{name: "expanded", method: "GET", url: "/search?qt=protocol+robot&i=droids", statusCode: 200, contains: `"_id":"2"`},
+		{name: "expanded queries", method: "GET", url: "/search?qt=protocol+robot&i=droids", statusCode: 200, contains: `"expandedQueries":["protocol robot","protocol droid"]`},
+		{name: "redirected", method: "GET", url: "/search?qt=buy+a+droid&i=droids", statusCode: 200, contains: `"redirect":"/shop"`},
+		{name: "list", method: "GET", url: "/admin/rules?i=droids", statusCode: 200, contains: `{"rules":[{"index":"droids","name":"r2"`},
+		{name: "invalid", method: "PUT", url: "/admin/rules", body: `{"index":"droids","name":"bad","type":"strip","terms":[]}`, statusCode: 400, contains: "strip rules need between 1 and 50 terms, got 0"},
+		{name: "delete", method: "DELETE", url: "/admin/rules?i=droids&name=shop", statusCode: 204},
+		{name: "deleted", method: "DELETE", url: "/admin/rules?i=droids&name=shop", statusCode: 404, contains: "rule-not-found"},
+		{name: "not redirected", method: "GET", url: "/search?qt=buy+a+droid&i=droids", statusCode: 200, contains: `"hits":null`},
+	}
+
+	for _, tc := range steps {
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, newRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+
+		if w.Code != tc.statusCode {
+			t.Fatalf("%s: status code - expected : %d, received : %d (%s)", tc.name, tc.statusCode, w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), tc.contains) {
+			t.Fatalf("%s: body should contain %s, received : %s", tc.name, tc.contains, w.Body.String())
+		}
+	}
+}
+
+func TestAdvancedSearchOffline(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	s.Config = &conf.Configuration{QuerySyntax: conf.QuerySyntaxOptions{Fields: map[string]string{"title": "meta.title"}, Date: "published"}}
+
+	tests := map[string]struct {
+		method     string
+		url        string
+		body       string
+		statusCode int
+		contains   string
+	}{
+		"advanced":      {method: "GET", url: "/search?qt=protocol+title:c-3po&i=droids&advanced=true", statusCode: 200, contains: `"_id":"2"`},
+		"post advanced": {method: "POST", url: "/search", body: `{"searchTerm":"astromech after:2020-01-01","index":"droids","advanced":true}`, statusCode: 200, contains: `"_id":"1"`},
+		"not advanced":  {method: "GET", url: "/search?qt=title:\"r2&i=droids", statusCode: 200, contains: `"hits":null`},
+		"syntax error":  {method: "GET", url: "/search?qt=title:\"r2&i=droids&advanced=true", statusCode: 400, contains: `"type":"/problems/query-syntax"`},
+		"errors":        {method: "GET", url: "/search?qt=author:me+OR&i=droids&advanced=true", statusCode: 400, contains: `"errors":[{"position":0,"token":"author:me","message":"unknown field \"author\", expected one of title"},{"position":10,"token":"OR","message":"OR needs a term on each side"}]`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.Router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("status code - expected : %d, received : %d (%s)", tc.statusCode, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tc.contains) {
+				t.Fatalf("body should contain %s, received : %s", tc.contains, w.Body.String())
+			}
+		})
+	}
+}
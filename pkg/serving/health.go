@@ -0,0 +1,159 @@
+package serving
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The statuses of the health report and its checks
+const (
+	healthOK      = "ok"
+	healthFailing = "failing"
+)
+
+// health is the report GET /readyz responds with
+type health struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+// healthCheck is the result of checking a dependency, and how long checking it took
+type healthCheck struct {
+	Name          string      `json:"name"`
+	Status        string      `json:"status"`
+	LatencyMillis float64     `json:"latencyMillis"`
+	Detail        interface{} `json:"detail,omitempty"`
+	Error         string      `json:"error,omitempty"`
+}
+
+// check checks a dependency, returning the detail of its state and an error when it fails
+type check struct {
+	name string
+	run  func(ctx context.Context) (interface{}, error)
+}
+
+// handleHealthz responds 200 for as long as the process serves requests, without checking dependencies:
+// a failing Elasticsearch should take the API out of rotation, not get it restarted
+func (s *Server) handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.ok(w, r, health{Status: healthOK, Checks: []healthCheck{}})
+	}
+}
+
+// handleReadyz responds 200 when searches can be served, and 503 when a check fails or the server is
+// shutting down
+func (s *Server) handleReadyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.isShuttingDown() {
+			s.respond(w, r, http.StatusServiceUnavailable, health{
+				Status: healthFailing,
+				Checks: []healthCheck{{Name: "server", Status: healthFailing, Error: "shutting down"}},
+			})
+			return
+		}
+
+		h := s.checkHealth(r.Context(), s.checks())
+		if h.Status != healthOK {
+			var failed []string
+			for _, c := range h.Checks {
+				if c.Status != healthOK {
+					failed = append(failed, c.Name+": "+c.Error)
+				}
+			}
+			s.Log.WithField("requestId", requestID(r)).Warnf("Not ready: %s", strings.Join(failed, ", "))
+			s.respond(w, r, http.StatusServiceUnavailable, h)
+			return
+		}
+		s.ok(w, r, h)
+	}
+}
+
+// checks returns the checks of the dependencies searches need
+func (s *Server) checks() []check {
+	c := s.config().Health
+	checks := []check{{name: "elasticsearch", run: func(ctx context.Context) (interface{}, error) {
+		h, err := s.Searcher.ClusterHealth(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if h.Status == "red" || (h.Status == "yellow" && c.RequireGreen) {
+			return h, fmt.Errorf("cluster health is %s", h.Status)
+		}
+		return h, nil
+	}}}
+
+	if len(c.RequiredIndices) > 0 {
+		checks = append(checks, check{name: "indices", run: func(ctx context.Context) (interface{}, error) {
+			missing, err := s.Searcher.MissingIndices(ctx, c.RequiredIndices)
+			if err != nil {
+				return nil, err
+			}
+			if len(missing) > 0 {
+				return map[string][]string{"missing": missing}, fmt.Errorf("missing %s", strings.Join(missing, ", "))
+			}
+			return map[string][]string{"required": c.RequiredIndices}, nil
+		}})
+	}
+
+	if s.QueryLog != nil {
+		checks = append(checks, check{name: "queryLog", run: func(ctx context.Context) (interface{}, error) {
+			stats := s.QueryLog.Stats()
+			switch {
+			case stats.Closed:
+				return stats, fmt.Errorf("query log is closed")
+			case stats.QueueCapacity > 0 && stats.QueueDepth >= stats.QueueCapacity:
+				return stats, fmt.Errorf("query log queue is full")
+			}
+			return stats, nil
+		}})
+	}
+	return checks
+}
+
+// checkHealth runs the checks concurrently, and reports the API healthy when they all pass
+func (s *Server) checkHealth(ctx context.Context, checks []check) health {
+	h := health{Status: healthOK, Checks: make([]healthCheck, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := c.run(ctx)
+			hc := healthCheck{
+				Name:          c.name,
+				Status:        healthOK,
+				LatencyMillis: float64(time.Since(start).Microseconds()) / 1000,
+				Detail:        detail,
+			}
+			if err != nil {
+				hc.Status, hc.Error = healthFailing, err.Error()
+			}
+			h.Checks[i] = hc
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, c := range h.Checks {
+		if c.Status != healthOK {
+			h.Status = healthFailing
+		}
+	}
+	return h
+}
+
+// shutDown makes GET /readyz fail from now on
+func (s *Server) shutDown() {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+}
+
+// isShuttingDown reports whether the server received a signal to shut down
+func (s *Server) isShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) == 1
+}
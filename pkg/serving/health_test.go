@@ -0,0 +1,106 @@
+package serving
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
+)
+
+func TestHealthOffline(t *testing.T) {
+	tests := map[string]struct {
+		url          string
+		health       conf.HealthOptions
+		err          error
+		shuttingDown bool
+		statusCode   int
+		contains     string
+	}{
+		"healthcheck":      {url: "/healthcheck", statusCode: 200, contains: `{"status":"ok","checks":[]}`},
+		"healthz":          {url: "/healthz", err: fmt.Errorf("connection refused"), statusCode: 200, contains: `{"status":"ok","checks":[]}`},
+		"ready":            {url: "/readyz", statusCode: 200, contains: `"name":"elasticsearch","status":"ok"`},
+		"required indices": {url: "/readyz", health: conf.HealthOptions{RequiredIndices: []string{"droids"}}, statusCode: 200, contains: `"detail":{"required":["droids"]}`},
+		"missing index":    {url: "/readyz", health: conf.HealthOptions{RequiredIndices: []string{"droids", "ships"}}, statusCode: 503, contains: `"detail":{"missing":["ships"]},"error":"missing ships"`},
+		"unavailable":      {url: "/readyz", err: fmt.Errorf("connection refused"), statusCode: 503, contains: `"error":"connection refused"`},
+		"shutting down":    {url: "/readyz", shuttingDown: true, statusCode: 503, contains: `"name":"server","status":"failing","latencyMillis":0,"error":"shutting down"`},
+		"live on shutdown": {url: "/healthz", shuttingDown: true, statusCode: 200, contains: `{"status":"ok"`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, m := newMemoryServer(t)
+			s.Config.Health = tc.health
+			m.Err = tc.err
+			if tc.shuttingDown {
+				s.shutDown()
+			}
+
+			w := httptest.NewRecorder()
+			s.Router.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("status code - expected : %d, received : %d (%s)", tc.statusCode, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tc.contains) {
+				t.Fatalf("body should contain %s, received : %s", tc.contains, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	es := estest.NewServer()
+	defer es.Close()
+	es.CreateIndex("droids")
+	l := logrus.New()
+	ql := searching.NewQueryLog(es.Client(), conf.QueryLogOptions{}, l)
+	c := &conf.Configuration{Health: conf.HealthOptions{RequiredIndices: []string{"droids"}}}
+	s := NewServer(c, searching.NewElastic(es.Client(), ql, l), ql, httprouter.New(), l)
+
+	steps := []struct {
+		name       string
+		step       func()
+		statusCode int
+		checks     map[string]string
+	}{
+		{name: "green", step: func() {}, statusCode: 200, checks: map[string]string{"elasticsearch": "ok", "indices": "ok", "queryLog": "ok"}},
+		{name: "yellow", step: func() { es.SetHealth("yellow") }, statusCode: 200, checks: map[string]string{"elasticsearch": "ok", "indices": "ok", "queryLog": "ok"}},
+		{name: "yellow required green", step: func() { c.Health.RequireGreen = true }, statusCode: 503, checks: map[string]string{"elasticsearch": "failing", "indices": "ok", "queryLog": "ok"}},
+		{name: "red", step: func() { c.Health.RequireGreen = false; es.SetHealth("red") }, statusCode: 503, checks: map[string]string{"elasticsearch": "failing", "indices": "ok", "queryLog": "ok"}},
+		{name: "query log closed", step: func() { es.SetHealth("green"); ql.Close(context.Background()) }, statusCode: 503, checks: map[string]string{"elasticsearch": "ok", "indices": "ok", "queryLog": "failing"}},
+	}
+
+	for _, st := range steps {
+		st.step()
+
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		if w.Code != st.statusCode {
+			t.Fatalf("%s: status code - expected : %d, received : %d (%s)", st.name, st.statusCode, w.Code, w.Body.String())
+		}
+
+		var h health
+		if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+			t.Fatalf("%s: could not decode the health report: %s", st.name, err)
+		}
+		checks := map[string]string{}
+		for _, c := range h.Checks {
+			checks[c.Name] = c.Status
+			if c.LatencyMillis < 0 {
+				t.Fatalf("%s: latency of %s should be measured, received : %v", st.name, c.Name, c.LatencyMillis)
+			}
+		}
+		if diff := cmp.Diff(st.checks, checks); diff != "" {
+			t.Fatalf("%s: %s", st.name, diff)
+		}
+	}
+}
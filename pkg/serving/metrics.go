@@ -0,0 +1,83 @@
+package serving
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/wambozi/elastic-search-api/m/pkg/metrics"
+)
+
+var (
+	httpRequests = promauto.With(metrics.Registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Requests served, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.With(metrics.Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name: "http_request_duration_seconds",
+		Help: "Latency of the requests served, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+	queryLogDepth = promauto.With(metrics.Registry).NewGauge(prometheus.GaugeOpts{
+		Name: "query_log_queue_depth",
+		Help: "Queries waiting to be indexed by the query log.",
+	})
+	queryLogCapacity = promauto.With(metrics.Registry).NewGauge(prometheus.GaugeOpts{
+		Name: "query_log_queue_capacity",
+		Help: "Queries the query log can queue before dropping new ones.",
+	})
+)
+
+// statusClientClosed is the status requests are counted under when the client went away before a
+// response was written, as nginx logs them
+const statusClientClosed = 499
+
+// handleMetrics responds with the metrics in the Prometheus exposition format
+func (s *Server) handleMetrics() http.HandlerFunc {
+	h := metrics.Handler(s.Log)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.QueryLog != nil {
+			stats := s.QueryLog.Stats()
+			queryLogDepth.Set(float64(stats.QueueDepth))
+			queryLogCapacity.Set(float64(stats.QueueCapacity))
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// statusRecorder records the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// withMetrics counts the requests to the route, and records their latency, by method and status code
+func (s *Server) withMetrics(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+
+		if rec.status == 0 {
+			rec.status = statusClientClosed
+		}
+		status := strconv.Itoa(rec.status)
+		httpRequests.WithLabelValues(route, r.Method, status).Inc()
+		httpDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
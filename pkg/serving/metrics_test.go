@@ -0,0 +1,72 @@
+package serving
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
+)
+
+func TestMetricsOffline(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	es := estest.NewServer()
+	defer es.Close()
+	s.QueryLog = searching.NewQueryLog(es.Client(), conf.QueryLogOptions{QueueSize: 50}, logrus.New())
+	defer s.QueryLog.Close(context.Background())
+
+	for _, url := range []string{"/search?qt=droid&i=droids", "/search?qt=droid", "/suggest?q=r2&i=droids", "/healthz"} {
+		s.Router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("metrics should be served as text, received : %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/search",status="200"} `,
+		`http_requests_total{method="GET",route="/search",status="400"} `,
+		`http_requests_total{method="GET",route="/suggest",status="200"} `,
+		`http_requests_total{method="GET",route="/healthz",status="200"} `,
+		`http_request_duration_seconds_bucket{method="GET",route="/search",status="200",le="+Inf"} `,
+		"query_log_queue_depth 0\n",
+		"query_log_queue_capacity 50\n",
+		"# TYPE search_took_seconds histogram\n",
+		"# TYPE go_goroutines gauge\n",
+		"# TYPE process_cpu_seconds_total counter\n",
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Fatalf("metrics should contain %q, received : %s", line, w.Body.String())
+		}
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	s := &Server{Log: logrus.New()}
+
+	tests := map[string]struct {
+		handler http.HandlerFunc
+		status  string
+	}{
+		"implicit ok":   {handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }, status: "200"},
+		"status":        {handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }, status: "418"},
+		"client closed": {handler: func(w http.ResponseWriter, r *http.Request) {}, status: "499"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			before := testutil.ToFloat64(httpRequests.WithLabelValues("/test", "POST", tc.status))
+			s.withMetrics("/test", tc.handler)(httptest.NewRecorder(), httptest.NewRequest("POST", "/test?x=1", nil))
+			if n := testutil.ToFloat64(httpRequests.WithLabelValues("/test", "POST", tc.status)) - before; n != 1 {
+				t.Fatalf("requests counted as %s - expected : 1, received : %v", tc.status, n)
+			}
+		})
+	}
+}
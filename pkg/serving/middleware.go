@@ -2,11 +2,20 @@ package serving
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/pkg/auth"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //Middleware is transparent, and since it's just another handler function, the call to the next handler h(w,r) can be done anywhere in the midst of the middleware function's execution.
@@ -20,6 +29,94 @@ func (s *Server) execDurLog(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+const requestIDHeader = "X-Request-ID"
+
+type contextKey string
+
+const requestIDKey contextKey = "requestID"
+
+// validRequestID matches the request IDs accepted from clients and proxies, anything else is replaced
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// withRequestID identifies the request with the X-Request-ID header it came with, or a new ID, and echoes
+// the ID in the response so errors can be traced back to the logs
+func (s *Server) withRequestID(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		h(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	}
+}
+
+// requestID returns the ID withRequestID gave the request, or "" if it didn't go through it
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// withTracing traces the request to the route through the rest of the middleware and its handler,
+// continuing the trace of the traceparent header it came with, if any
+func (s *Server) withTracing(route string, h http.HandlerFunc) http.HandlerFunc {
+	traced := otelhttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.String("http.route", route), attribute.String("http.request_id", requestID(r)))
+		h(w, r)
+	}), route, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method + " " + route
+	}))
+	return traced.ServeHTTP
+}
+
+// withAuth authenticates the request, and lets it through when its client has the scope. The client is
+// on the request's context for handlers to check the indices it can search. When the server has no
+// authenticator only the search routes are open, and the analytics and admin routes are forbidden.
+func (s *Server) withAuth(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Auth == nil {
+			if scope != auth.ScopeSearch {
+				s.fail(w, r, &auth.Error{Kind: auth.ErrForbidden, Err: fmt.Errorf("the routes requiring the %s scope are closed until auth is configured", scope)})
+				return
+			}
+			h(w, r)
+			return
+		}
+
+		client, err := s.Auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="elastic-search-api"`)
+			s.fail(w, r, err)
+			return
+		}
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("enduser.id", client.ID))
+		if err := client.Authorize(scope); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		h(w, r.WithContext(auth.NewContext(r.Context(), client)))
+	}
+}
+
+// withTimeout cancels the request's context once the timeout configured for the route passes, which
+// stops the Elasticsearch requests made within it
+func (s *Server) withTimeout(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.routeTimeout(route))
+		defer cancel()
+		h(w, r.WithContext(ctx))
+	}
+}
+
 type responseRecorder struct {
 	http.ResponseWriter
 	status int
@@ -40,13 +137,13 @@ func (r *responseRecorder) Write(b []byte) (int, error) {
 func (s *Server) reqResLog(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		s.Log.Infof("Request: %s %s", r.Method, r.RequestURI)
-		s.Log.Infof("Request headers: %+v", r.Header)
+		s.Log.Infof("Request headers: %+v", redactHeaders(r.Header))
 
 		if r.Body != nil {
 			bodyBytes, err := ioutil.ReadAll(r.Body)
 			s.Log.Infof("Request body: %s", string(bodyBytes))
 			if err != nil {
-				s.Log.Errorf("Could not ready request body: %w", err)
+				s.Log.Errorf("Could not ready request body: %s", err)
 			}
 			r.Body.Close()
 			r.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
@@ -60,3 +157,14 @@ func (s *Server) reqResLog(h http.HandlerFunc) http.HandlerFunc {
 
 	}
 }
+
+// redactHeaders returns the headers without the credentials they carry, to be logged
+func redactHeaders(h http.Header) http.Header {
+	redacted := h.Clone()
+	for _, k := range []string{auth.APIKeyHeader, auth.AuthorizationHeader} {
+		if redacted.Get(k) != "" {
+			redacted.Set(k, "[REDACTED]")
+		}
+	}
+	return redacted
+}
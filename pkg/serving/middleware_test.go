@@ -0,0 +1,270 @@
+package serving
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/auth"
+	"github.com/wambozi/elastic-search-api/m/pkg/clients"
+	"github.com/wambozi/elastic-search-api/m/pkg/estest"
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	tests := map[string]struct {
+		config *conf.Configuration
+		route  string
+		want   time.Duration
+	}{
+		"default":       {config: nil, route: "/search", want: defaultSearchTimeout},
+		"search":        {config: &conf.Configuration{Server: conf.ServerConfiguration{SearchTimeoutMillis: 2000}}, route: "/search", want: 2 * time.Second},
+		"route":         {config: &conf.Configuration{Server: conf.ServerConfiguration{SearchTimeoutMillis: 2000, RouteTimeoutsMillis: map[string]int{"/suggest": 500}}}, route: "/suggest", want: 500 * time.Millisecond},
+		"other-route":   {config: &conf.Configuration{Server: conf.ServerConfiguration{RouteTimeoutsMillis: map[string]int{"/suggest": 500}}}, route: "/search", want: defaultSearchTimeout},
+		"zero-is-unset": {config: &conf.Configuration{Server: conf.ServerConfiguration{RouteTimeoutsMillis: map[string]int{"/search": 0}}}, route: "/search", want: defaultSearchTimeout},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &Server{Config: tc.config}
+			if got := s.routeTimeout(tc.route); got != tc.want {
+				t.Fatalf("timeout - expected : %s, received : %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSearchTimeout(t *testing.T) {
+	tests := map[string]struct {
+		es         http.HandlerFunc
+		statusCode int
+	}{
+		// Elasticsearch doesn't answer before the route's deadline
+		"deadline": {
+			es: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+			},
+			statusCode: http.StatusGatewayTimeout,
+		},
+		// Elasticsearch's own timeout fires first and it answers with partial hits
+		"partial": {
+			es: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("timeout") == "" {
+					t.Errorf("search request should carry a timeout: %s", r.URL)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"took":45,"timed_out":true,"_shards":{"total":2,"successful":1,"skipped":0,"failed":0},"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`))
+			},
+			statusCode: http.StatusGatewayTimeout,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := estest.NewServer()
+			defer srv.Close()
+			srv.Handle("", "/test/_search", tc.es)
+
+			c := &conf.Configuration{Server: conf.ServerConfiguration{SearchTimeoutMillis: 100}}
+			l := logrus.New()
+			s := &Server{Config: c, Searcher: searching.NewElastic(srv.Client(), nil, l), Router: httprouter.New(), Log: l}
+			s.routes()
+
+			body, _ := json.Marshal(searching.SearchRequest{Index: "test", SearchTerm: "test"})
+			req := httptest.NewRequest("POST", "/search", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			s.Router.ServeHTTP(w, req)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("status code - expected : %d, received : %d (%s)", tc.statusCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithTracing(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	es := estest.NewServer()
+	defer es.Close()
+	es.Index("droids", "1", `{"meta":{"title":"R2-D2","description":"An astromech droid"}}`)
+	client, err := clients.CreateElasticClient(clients.GenerateElasticConfig([]string{es.URL}, "", ""))
+	if err != nil {
+		t.Fatalf("Unexpected error creating Elasticsearch client: %s", err)
+	}
+	l := logrus.New()
+	s := NewServer(&conf.Configuration{}, searching.NewElastic(client, nil, l), nil, httprouter.New(), l)
+
+	traceID, parentID := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest("GET", "/search?qt=droid&i=droids", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status code - expected : %d, received : %d (%s)", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	ended := map[string]sdktrace.ReadOnlySpan{}
+	for _, sp := range spans.Ended() {
+		if sp.SpanContext().TraceID().String() != traceID {
+			t.Fatalf("spans should continue the trace of the request, received : %+v", sp)
+		}
+		ended[sp.Name()] = sp
+	}
+
+	for _, name := range []string{"GET /search", "serving.handleCrawl", "searching.Search", "elasticsearch search", "queryLog.Log", "serving.respond"} {
+		if _, ok := ended[name]; !ok {
+			t.Fatalf("expected a %s span, received : %+v", name, ended)
+		}
+	}
+	server := ended["GET /search"]
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, a := range server.Attributes() {
+		attrs[a.Key] = a.Value
+	}
+	if server.Parent().SpanID().String() != parentID || attrs["http.status_code"].AsInt64() != 200 || attrs["http.route"].AsString() != "/search" {
+		t.Fatalf("the server span should be the child of the caller's, received : %+v", server)
+	}
+	if ended["serving.handleCrawl"].Parent().SpanID() != server.SpanContext().SpanID() || ended["searching.Search"].Parent().SpanID() != ended["serving.handleCrawl"].SpanContext().SpanID() {
+		t.Fatalf("spans should nest as the calls do, received : %+v", ended)
+	}
+
+	searches := es.RequestsTo("", "/droids/_search")
+	if len(searches) != 1 || !strings.HasPrefix(searches[0].Header.Get("traceparent"), "00-"+traceID+"-") {
+		t.Fatalf("the trace should be passed on to Elasticsearch, received : %+v", searches)
+	}
+}
+
+func TestWithoutAuth(t *testing.T) {
+	s, _ := newMemoryServer(t)
+
+	tests := map[string]struct {
+		method string
+		target string
+		body   string
+		status int
+	}{
+		"search":      {method: "GET", target: "/search?qt=droid&i=droids", status: http.StatusOK},
+		"post search": {method: "POST", target: "/search", body: `{"searchTerm":"droid","index":"droids"}`, status: http.StatusOK},
+		"suggest":     {method: "GET", target: "/suggest?q=r2&i=droids", status: http.StatusOK},
+		"click":       {method: "POST", target: "/analytics/click", body: `{"searchId":"a","index":"droids","documentId":"1"}`, status: http.StatusNotFound},
+		"top queries": {method: "GET", target: "/analytics/top-queries?i=droids", status: http.StatusForbidden},
+		"trending":    {method: "GET", target: "/analytics/trending?i=droids", status: http.StatusForbidden},
+		"list pins":   {method: "GET", target: "/admin/pins?i=droids", status: http.StatusForbidden},
+		"pin":         {method: "PUT", target: "/admin/pins", body: `{"index":"droids","query":"droid","ids":["2"]}`, status: http.StatusForbidden},
+		"unpin":       {method: "DELETE", target: "/admin/pins?i=droids&q=droid", status: http.StatusForbidden},
+		"list rules":  {method: "GET", target: "/admin/rules?i=droids", status: http.StatusForbidden},
+		"put rule":    {method: "PUT", target: "/admin/rules", body: `{"index":"droids","name":"shop","type":"redirect","terms":["buy"],"url":"/shop"}`, status: http.StatusForbidden},
+		"delete rule": {method: "DELETE", target: "/admin/rules?i=droids&name=shop", status: http.StatusForbidden},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.Router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body)))
+
+			if w.Code != tc.status {
+				t.Fatalf("status code - expected : %d, received : %d (%s)", tc.status, w.Code, w.Body.String())
+			}
+			if tc.status != http.StatusForbidden {
+				return
+			}
+			var p problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("Unexpected error decoding problem: %s", err)
+			}
+			if p.Type != "/problems/forbidden" {
+				t.Fatalf("problem type - expected : /problems/forbidden, received : %s", p.Type)
+			}
+		})
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	var logs bytes.Buffer
+	s.Log.Out = &logs
+	a, err := auth.New(conf.AuthOptions{
+		APIKeys: []conf.APIKeyOptions{
+			{Client: "web", SHA256: auth.HashKey("web-key")},
+			{Client: "admin", SHA256: auth.HashKey("admin-key")},
+		},
+		Clients: map[string]conf.ClientOptions{
+			"web":   {Scopes: []string{auth.ScopeSearch}, Indices: []string{"droids"}},
+			"admin": {Scopes: []string{auth.ScopeAdmin}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating authenticator: %s", err)
+	}
+	s.Auth = a
+
+	tests := map[string]struct {
+		target  string
+		key     string
+		status  int
+		problem string
+	}{
+		"no key":          {target: "/search?qt=droid&i=droids", status: http.StatusUnauthorized, problem: "/problems/unauthenticated"},
+		"invalid key":     {target: "/search?qt=droid&i=droids", key: "guess", status: http.StatusUnauthorized, problem: "/problems/unauthenticated"},
+		"search":          {target: "/search?qt=droid&i=droids", key: "web-key", status: http.StatusOK},
+		"suggest":         {target: "/suggest?q=r2&i=droids", key: "web-key", status: http.StatusOK},
+		"other index":     {target: "/search?qt=droid&i=jedi", key: "web-key", status: http.StatusForbidden, problem: "/problems/index-not-allowed"},
+		"other suggest":   {target: "/suggest?q=r2&i=jedi", key: "web-key", status: http.StatusForbidden, problem: "/problems/index-not-allowed"},
+		"no scope":        {target: "/admin/pins?i=droids", key: "web-key", status: http.StatusForbidden, problem: "/problems/forbidden"},
+		"admin":           {target: "/admin/pins?i=droids", key: "admin-key", status: http.StatusOK},
+		"admin no search": {target: "/search?qt=droid&i=droids", key: "admin-key", status: http.StatusForbidden, problem: "/problems/forbidden"},
+		"probe":           {target: "/healthz", status: http.StatusOK},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.target, nil)
+			if tc.key != "" {
+				req.Header.Set("X-API-Key", tc.key)
+			}
+			w := httptest.NewRecorder()
+			s.Router.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("status code - expected : %d, received : %d (%s)", tc.status, w.Code, w.Body.String())
+			}
+			if tc.problem == "" {
+				return
+			}
+			var p problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("Unexpected error decoding problem: %s", err)
+			}
+			if p.Type != tc.problem {
+				t.Fatalf("problem type - expected : %s, received : %s", tc.problem, p.Type)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); (tc.status == http.StatusUnauthorized) != (challenge != "") {
+				t.Fatalf("only unauthenticated requests should be challenged, received : %q", challenge)
+			}
+		})
+	}
+
+	if strings.Contains(logs.String(), "web-key") || strings.Contains(logs.String(), "admin-key") {
+		t.Fatalf("API keys should not be logged")
+	}
+}
@@ -0,0 +1,97 @@
+package serving
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/wambozi/elastic-search-api/m/pkg/auth"
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	// problemTypePrefix is prepended to problem type names to make their type URI, documented in the README
+	problemTypePrefix = "/problems/"
+)
+
+// problem is the RFC 7807 body of every error response
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	// Errors holds the errors of a search term that isn't valid in the query syntax
+	Errors searching.SyntaxErrors `json:"errors,omitempty"`
+}
+
+// problemTypes maps the kinds of auth and searching errors to the type and status of the problem they respond with
+var problemTypes = []struct {
+	kind   error
+	name   string
+	status int
+}{
+	{kind: auth.ErrUnauthenticated, name: "unauthenticated", status: http.StatusUnauthorized},
+	{kind: auth.ErrForbidden, name: "forbidden", status: http.StatusForbidden},
+	{kind: searching.ErrQuerySyntax, name: "query-syntax", status: http.StatusBadRequest},
+	{kind: searching.ErrBadRequest, name: "bad-request", status: http.StatusBadRequest},
+	{kind: searching.ErrIndexNotFound, name: "index-not-found", status: http.StatusNotFound},
+	{kind: searching.ErrIndexNotAllowed, name: "index-not-allowed", status: http.StatusForbidden},
+	{kind: searching.ErrSearchNotFound, name: "search-not-found", status: http.StatusNotFound},
+	{kind: searching.ErrPinNotFound, name: "pin-not-found", status: http.StatusNotFound},
+	{kind: searching.ErrRuleNotFound, name: "rule-not-found", status: http.StatusNotFound},
+	{kind: searching.ErrTimeout, name: "timeout", status: http.StatusGatewayTimeout},
+	{kind: searching.ErrUnavailable, name: "upstream-unavailable", status: http.StatusServiceUnavailable},
+	{kind: searching.ErrParse, name: "parse-failure", status: http.StatusBadGateway},
+}
+
+// problem writes an RFC 7807 problem response. Server errors are logged as errors, client errors as warnings.
+func (s *Server) problem(w http.ResponseWriter, r *http.Request, status int, name string, err error) {
+	p := problem{
+		Type:      problemTypePrefix + name,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    err.Error(),
+		Instance:  r.URL.Path,
+		RequestID: requestID(r),
+	}
+	errors.As(err, &p.Errors)
+	trace.SpanFromContext(r.Context()).RecordError(err)
+
+	l := s.Log.WithField("requestId", p.RequestID)
+	if status >= http.StatusInternalServerError {
+		l.Errorf("%s: %s", p.Title, p.Detail)
+	} else {
+		l.Warnf("%s: %s", p.Title, p.Detail)
+	}
+
+	ps, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	w.Write(ps)
+}
+
+// fail responds to err with the status of its kind, or 500 when it has none. Nothing is written when the
+// client canceled the request, since there's no one left to respond to.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() == context.Canceled {
+		s.Log.WithField("requestId", requestID(r)).Infof("Request canceled: %s", err)
+		return
+	}
+
+	for _, t := range problemTypes {
+		if errors.Is(err, t.kind) {
+			s.problem(w, r, t.status, t.name, err)
+			return
+		}
+	}
+	s.problem(w, r, http.StatusInternalServerError, "internal", err)
+}
+
+func (s *Server) badRequest(w http.ResponseWriter, r *http.Request, err error) {
+	s.problem(w, r, http.StatusBadRequest, "bad-request", err)
+}
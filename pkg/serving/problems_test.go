@@ -0,0 +1,97 @@
+package serving
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
+)
+
+func TestFail(t *testing.T) {
+	tests := map[string]struct {
+		err    error
+		status int
+		typ    string
+	}{
+		"bad-request":      {err: &searching.Error{Kind: searching.ErrBadRequest, Err: errors.New("size must be between 1 and 100")}, status: 400, typ: "/problems/bad-request"},
+		"query-syntax":     {err: searching.SyntaxErrors{{Position: 3, Token: "OR", Message: "OR needs a term on each side"}}, status: 400, typ: "/problems/query-syntax"},
+		"index-not-found":  {err: &searching.Error{Kind: searching.ErrIndexNotFound, Err: errors.New("no such index")}, status: 404, typ: "/problems/index-not-found"},
+		"search-not-found": {err: fmt.Errorf("%w: no search ID=a", searching.ErrSearchNotFound), status: 404, typ: "/problems/search-not-found"},
+		"pin-not-found":    {err: fmt.Errorf("%w: no documents pinned to \"droid\" in droids", searching.ErrPinNotFound), status: 404, typ: "/problems/pin-not-found"},
+		"rule-not-found":   {err: fmt.Errorf("%w: no rule \"shop\" in droids", searching.ErrRuleNotFound), status: 404, typ: "/problems/rule-not-found"},
+		"timeout":          {err: &searching.Error{Kind: searching.ErrTimeout, Err: errors.New("deadline exceeded")}, status: 504, typ: "/problems/timeout"},
+		"unavailable":      {err: &searching.Error{Kind: searching.ErrUnavailable, Err: errors.New("connection refused")}, status: 503, typ: "/problems/upstream-unavailable"},
+		"parse":            {err: &searching.Error{Kind: searching.ErrParse, Err: errors.New("unexpected EOF")}, status: 502, typ: "/problems/parse-failure"},
+		"unknown":          {err: errors.New("oops"), status: 500, typ: "/problems/internal"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &Server{Log: logrus.New()}
+			var p problem
+			h := s.withRequestID(func(w http.ResponseWriter, r *http.Request) { s.fail(w, r, tc.err) })
+
+			req := httptest.NewRequest("GET", "/search?qt=r2d2&i=droids", nil)
+			req.Header.Set("X-Request-ID", "abc-123")
+			w := httptest.NewRecorder()
+			h(w, req)
+
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Fatalf("content type - expected : %s, received : %s", problemContentType, ct)
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("could not decode problem: %s", err)
+			}
+			want := problem{Type: tc.typ, Title: http.StatusText(tc.status), Status: tc.status, Detail: tc.err.Error(), Instance: "/search", RequestID: "abc-123"}
+			errors.As(tc.err, &want.Errors)
+			if diff := cmp.Diff(want, p); diff != "" {
+				t.Fatalf(diff)
+			}
+			if w.Code != tc.status {
+				t.Fatalf("status code - expected : %d, received : %d", tc.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	tests := map[string]struct {
+		header    string
+		generated bool
+	}{
+		"client":    {header: "abc-123"},
+		"missing":   {generated: true},
+		"malformed": {header: "abc 123\n", generated: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &Server{Log: logrus.New()}
+			var seen string
+			h := s.withRequestID(func(w http.ResponseWriter, r *http.Request) { seen = requestID(r) })
+
+			req := httptest.NewRequest("GET", "/search", nil)
+			if tc.header != "" {
+				req.Header.Set("X-Request-ID", tc.header)
+			}
+			w := httptest.NewRecorder()
+			h(w, req)
+
+			if seen != w.Header().Get("X-Request-ID") {
+				t.Fatalf("response header %q should echo the request's ID %q", w.Header().Get("X-Request-ID"), seen)
+			}
+			if tc.generated && (len(seen) != 32 || seen == tc.header) {
+				t.Fatalf("expected a generated ID, received : %q", seen)
+			}
+			if !tc.generated && seen != tc.header {
+				t.Fatalf("ID - expected : %s, received : %s", tc.header, seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package serving
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/auth"
+)
+
+// sweepInterval is how often the memory store drops the buckets of clients that stopped calling
+const sweepInterval = time.Minute
+
+// RateLimiter limits the requests clients make to each route with token buckets. Requests are limited by
+// IP before they are authenticated, then by the client they were authenticated as.
+type RateLimiter struct {
+	routes map[string]conf.RateLimit
+	// ipRoutes holds the limits by IP, those of routes unless configured otherwise
+	ipRoutes map[string]conf.RateLimit
+	proxies  []*net.IPNet
+	store    RateLimitStore
+	// now returns the time buckets are refilled until
+	now func() time.Time
+}
+
+// NewRateLimiter returns the rate limiter of the options, keeping its buckets in the store, or nil when
+// no route is limited
+func NewRateLimiter(opts conf.RateLimitOptions, store RateLimitStore) (*RateLimiter, error) {
+	if len(opts.Routes) == 0 && len(opts.IPRoutes) == 0 {
+		return nil, nil
+	}
+
+	l := &RateLimiter{routes: opts.Routes, ipRoutes: map[string]conf.RateLimit{}, store: store, now: time.Now}
+	for _, routes := range []map[string]conf.RateLimit{opts.Routes, opts.IPRoutes} {
+		for route, limit := range routes {
+			if limit.RequestsPerSecond <= 0 {
+				return nil, fmt.Errorf("rate limit of %s must refill at a positive rate, got %g", route, limit.RequestsPerSecond)
+			}
+			if limit.Burst < 1 {
+				return nil, fmt.Errorf("rate limit of %s must allow a burst of at least 1, got %d", route, limit.Burst)
+			}
+			l.ipRoutes[route] = limit
+		}
+	}
+	for _, p := range opts.TrustedProxies {
+		cidr := p
+		if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+			cidr += "/32"
+		} else if ip != nil {
+			cidr += "/128"
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy must be an IP or CIDR, got %q", p)
+		}
+		l.proxies = append(l.proxies, n)
+	}
+	return l, nil
+}
+
+// clientIP returns the IP of the client sending the request. Requests from trusted proxies are sent by the
+// nearest address of their X-Forwarded-For header that isn't a trusted proxy.
+func (l *RateLimiter) clientIP(r *http.Request) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	if !l.trusted(addr) {
+		return addr
+	}
+
+	var hops []string
+	for _, h := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// the proxies before a garbled address can't be told apart from the client
+			return addr
+		}
+		addr = hop
+		if !l.trusted(addr) {
+			return addr
+		}
+	}
+	return addr
+}
+
+// trusted reports whether the address is of a trusted proxy
+func (l *RateLimiter) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range l.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// withIPRateLimit limits the requests to the route by the IP they come from. It runs before requests are
+// authenticated or logged, so floods of them, with or without valid credentials, cost little.
+func (s *Server) withIPRateLimit(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := s.RateLimiter
+		if l == nil {
+			h(w, r)
+			return
+		}
+		limit, ok := l.ipRoutes[route]
+		if !ok {
+			h(w, r)
+			return
+		}
+		s.rateLimit(w, r, route, limit, "ratelimit:"+route+":ip:"+l.clientIP(r), h)
+	}
+}
+
+// withClientRateLimit limits the requests to the route by the client they were authenticated as, wherever
+// it calls from. Unauthenticated requests are only limited by IP.
+func (s *Server) withClientRateLimit(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, c := s.RateLimiter, auth.FromContext(r.Context())
+		if l == nil || c == nil {
+			h(w, r)
+			return
+		}
+		limit, ok := l.routes[route]
+		if !ok {
+			h(w, r)
+			return
+		}
+		s.rateLimit(w, r, route, limit, "ratelimit:"+route+":client:"+c.ID, h)
+	}
+}
+
+// rateLimit responds 429 Too Many Requests once the bucket of the key ran out of tokens, and tells the
+// client how many it has left otherwise. Requests go through unlimited when the store fails.
+func (s *Server) rateLimit(w http.ResponseWriter, r *http.Request, route string, limit conf.RateLimit, key string, h http.HandlerFunc) {
+	l := s.RateLimiter
+	tokens, allowed, err := l.store.Take(r.Context(), key, limit, l.now())
+	if err != nil {
+		// letting requests through beats failing them all while the store is down
+		s.Log.WithField("requestId", requestID(r)).Warnf("Rate limit of %s not applied: %s", route, err)
+		h(w, r)
+		return
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(float64(limit.Burst)-tokens, limit)))
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(1-tokens, limit)))
+		s.problem(w, r, http.StatusTooManyRequests, "rate-limited", fmt.Errorf("%s allows %d requests at once, refilled at %g per second", route, limit.Burst, limit.RequestsPerSecond))
+		return
+	}
+	h(w, r)
+}
+
+// seconds returns how many seconds refilling the tokens takes, rounded up
+func seconds(tokens float64, l conf.RateLimit) int {
+	if tokens <= 0 {
+		return 0
+	}
+	return int(math.Ceil(tokens / l.RequestsPerSecond))
+}
+
+// refill returns the tokens of a bucket holding tokens at last, refilled until now
+func refill(tokens float64, last, now time.Time, l conf.RateLimit) float64 {
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens += elapsed * l.RequestsPerSecond
+	}
+	return math.Min(tokens, float64(l.Burst))
+}
+
+// RateLimitStore holds the token buckets of the clients
+type RateLimitStore interface {
+	// Take refills the bucket of the key until now, a full one if it is new, and takes a token from it if it
+	// has one. It returns the tokens left, and whether one was taken.
+	Take(ctx context.Context, key string, limit conf.RateLimit, now time.Time) (tokens float64, allowed bool, err error)
+}
+
+// MemoryStore holds token buckets in memory, limiting the requests to a single replica
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  conf.RateLimit
+}
+
+// NewMemoryStore returns an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+// Take takes a token from the bucket of the key
+func (m *MemoryStore) Take(ctx context.Context, key string, limit conf.RateLimit, now time.Time) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens, b.last, b.limit = refill(b.tokens, b.last, now, limit), now, limit
+	if b.tokens < 1 {
+		return b.tokens, false, nil
+	}
+	b.tokens--
+	return b.tokens, true, nil
+}
+
+// sweep drops the buckets that are full again, since they are the same as new ones, at most once per
+// sweepInterval
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.swept) < sweepInterval {
+		return
+	}
+	m.swept = now
+	for k, b := range m.buckets {
+		if refill(b.tokens, b.last, now, b.limit) >= float64(b.limit.Burst) {
+			delete(m.buckets, k)
+		}
+	}
+}
+
+// takeScript takes a token from the bucket of KEYS[1], a hash of its tokens and when they were counted,
+// refilled at ARGV[1] tokens per second up to ARGV[2], until the time of the Redis server in milliseconds,
+// so replicas whose clocks drift apart refill the buckets alike. Its effects are replicated rather than the
+// script, which Redis before 5 requires of scripts reading the time before they write. The bucket expires
+// once it would be full again. It returns 1 when a token was taken, and the tokens left as a string, since
+// Lua numbers are truncated to integers.
+var takeScript = redis.NewScript(`
+redis.replicate_commands()
+local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens, ts = tonumber(bucket[1]), tonumber(bucket[2])
+if tokens == nil or ts == nil then
+  tokens, ts = burst, now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore holds token buckets in Redis, so the replicas of the API share them
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore returns a store keeping the buckets with the client
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// NewRedisClient returns the client of the Redis options
+func NewRedisClient(opts conf.RedisOptions) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port)),
+		Password: opts.Password,
+		DB:       opts.Database,
+	})
+}
+
+// Take takes a token from the bucket of the key, in a script so replicas can't take the same token. The
+// bucket is refilled until the time of the Redis server rather than now, which is that of the replica.
+func (s *RedisStore) Take(ctx context.Context, key string, limit conf.RateLimit, now time.Time) (float64, bool, error) {
+	res, err := takeScript.Run(s.client.WithContext(ctx), []string{key}, limit.RequestsPerSecond, limit.Burst).Result()
+	if err != nil {
+		return 0, false, fmt.Errorf("Error taking a token from Redis: %w", err)
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return 0, false, fmt.Errorf("Error taking a token from Redis: unexpected result %v", res)
+	}
+	allowed, _ := values[0].(int64)
+	left, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("Error taking a token from Redis: unexpected tokens %q", left)
+	}
+	return tokens, allowed == 1, nil
+}
@@ -0,0 +1,239 @@
+package serving
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/auth"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := map[string]struct {
+		opts conf.RateLimitOptions
+		err  string
+	}{
+		"off":         {opts: conf.RateLimitOptions{TrustedProxies: []string{"10.0.0.1"}}},
+		"limited":     {opts: conf.RateLimitOptions{Routes: map[string]conf.RateLimit{"/search": {RequestsPerSecond: 5, Burst: 10}}, TrustedProxies: []string{"10.0.0.0/8", "10.1.0.1", "::1"}}},
+		"no rate":     {opts: conf.RateLimitOptions{Routes: map[string]conf.RateLimit{"/search": {Burst: 10}}}, err: "rate limit of /search must refill at a positive rate, got 0"},
+		"no burst":    {opts: conf.RateLimitOptions{Routes: map[string]conf.RateLimit{"/search": {RequestsPerSecond: 5}}}, err: "rate limit of /search must allow a burst of at least 1, got 0"},
+		"ip limited":  {opts: conf.RateLimitOptions{IPRoutes: map[string]conf.RateLimit{"/search": {RequestsPerSecond: 50, Burst: 100}}}},
+		"no ip rate":  {opts: conf.RateLimitOptions{IPRoutes: map[string]conf.RateLimit{"/search": {Burst: 10}}}, err: "rate limit of /search must refill at a positive rate, got 0"},
+		"bad proxies": {opts: conf.RateLimitOptions{Routes: map[string]conf.RateLimit{"/search": {RequestsPerSecond: 5, Burst: 10}}, TrustedProxies: []string{"proxy.local"}}, err: `trusted proxy must be an IP or CIDR, got "proxy.local"`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			l, err := NewRateLimiter(tc.opts, NewMemoryStore())
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+			if name == "off" && l != nil {
+				t.Fatalf("no limited route should leave requests unlimited, received : %+v", l)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	l, err := NewRateLimiter(conf.RateLimitOptions{
+		Routes:         map[string]conf.RateLimit{"/search": {RequestsPerSecond: 1, Burst: 1}},
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"},
+	}, NewMemoryStore())
+	if err != nil {
+		t.Fatalf("Unexpected error creating rate limiter: %s", err)
+	}
+
+	tests := map[string]struct {
+		remote    string
+		forwarded []string
+		ip        string
+	}{
+		"direct":          {remote: "203.0.113.7:5123", ip: "203.0.113.7"},
+		"spoofed":         {remote: "203.0.113.7:5123", forwarded: []string{"198.51.100.1"}, ip: "203.0.113.7"},
+		"proxied":         {remote: "10.0.0.2:5123", forwarded: []string{"198.51.100.1"}, ip: "198.51.100.1"},
+		"proxy chain":     {remote: "10.0.0.2:5123", forwarded: []string{"6.6.6.6, 198.51.100.1, 192.168.1.1"}, ip: "198.51.100.1"},
+		"headers":         {remote: "10.0.0.2:5123", forwarded: []string{"6.6.6.6, 198.51.100.1", "10.0.0.3"}, ip: "198.51.100.1"},
+		"all trusted":     {remote: "10.0.0.2:5123", forwarded: []string{"10.0.0.4, 10.0.0.3"}, ip: "10.0.0.4"},
+		"garbled":         {remote: "10.0.0.2:5123", forwarded: []string{"198.51.100.1, unknown"}, ip: "10.0.0.2"},
+		"proxy no header": {remote: "10.0.0.2:5123", ip: "10.0.0.2"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/search", nil)
+			r.RemoteAddr = tc.remote
+			for _, f := range tc.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if diff := cmp.Diff(tc.ip, l.clientIP(r)); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	m := NewMemoryStore()
+	limit := conf.RateLimit{RequestsPerSecond: 2, Burst: 3}
+	start := time.Now()
+
+	type take struct {
+		key     string
+		after   time.Duration
+		tokens  float64
+		allowed bool
+	}
+	steps := []take{
+		{key: "a", tokens: 2, allowed: true},
+		{key: "a", tokens: 1, allowed: true},
+		{key: "a", tokens: 0, allowed: true},
+		{key: "a", tokens: 0, allowed: false},
+		{key: "b", tokens: 2, allowed: true},
+		{key: "a", after: 250 * time.Millisecond, tokens: 0.5, allowed: false},
+		{key: "a", after: 500 * time.Millisecond, tokens: 0, allowed: true},
+		{key: "a", after: time.Hour, tokens: 2, allowed: true},
+	}
+
+	for i, s := range steps {
+		tokens, allowed, err := m.Take(context.Background(), s.key, limit, start.Add(s.after))
+		if err != nil {
+			t.Fatalf("step %d: Unexpected error taking a token: %s", i, err)
+		}
+		if tokens != s.tokens || allowed != s.allowed {
+			t.Fatalf("step %d: expected : %v tokens, allowed %t, received : %v tokens, allowed %t", i, s.tokens, s.allowed, tokens, allowed)
+		}
+	}
+
+	// b was full again long before the last take, so it was swept
+	if _, ok := m.buckets["b"]; ok || len(m.buckets) != 1 {
+		t.Fatalf("full buckets should be swept, received : %+v", m.buckets)
+	}
+}
+
+// failingStore fails every take, as a Redis store does when Redis is down
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit conf.RateLimit, now time.Time) (float64, bool, error) {
+	return 0, false, errors.New("connection refused")
+}
+
+func TestWithRateLimit(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	l, err := NewRateLimiter(conf.RateLimitOptions{
+		Routes: map[string]conf.RateLimit{"/search": {RequestsPerSecond: 0.5, Burst: 2}},
+	}, NewMemoryStore())
+	if err != nil {
+		t.Fatalf("Unexpected error creating rate limiter: %s", err)
+	}
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	s.RateLimiter = l
+
+	type request struct {
+		target  string
+		remote  string
+		key     string
+		status  int
+		headers map[string]string
+	}
+	steps := []request{
+		{target: "/search?qt=droid&i=droids", remote: "203.0.113.7:1", status: http.StatusOK, headers: map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "1", "RateLimit-Reset": "2", "Retry-After": ""}},
+		{target: "/search?qt=droid&i=droids", remote: "203.0.113.7:2", status: http.StatusOK, headers: map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "4"}},
+		{target: "/search?qt=droid&i=droids", remote: "203.0.113.7:3", status: http.StatusTooManyRequests, headers: map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "4", "Retry-After": "2"}},
+		// other IPs and routes have buckets of their own
+		{target: "/search?qt=droid&i=droids", remote: "198.51.100.1:1", status: http.StatusOK, headers: map[string]string{"RateLimit-Remaining": "1"}},
+		{target: "/suggest?q=r2&i=droids", remote: "203.0.113.7:4", status: http.StatusOK, headers: map[string]string{"RateLimit-Limit": ""}},
+	}
+
+	run := func(i int, step request) {
+		req := httptest.NewRequest("GET", step.target, nil)
+		req.RemoteAddr = step.remote
+		if step.key != "" {
+			req.Header.Set("X-API-Key", step.key)
+		}
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, req)
+
+		if w.Code != step.status {
+			t.Fatalf("step %d: status code - expected : %d, received : %d (%s)", i, step.status, w.Code, w.Body.String())
+		}
+		for k, v := range step.headers {
+			if diff := cmp.Diff(v, w.Header().Get(k)); diff != "" {
+				t.Fatalf("step %d: %s: %s", i, k, diff)
+			}
+		}
+		if step.status == http.StatusTooManyRequests && w.Header().Get("Content-Type") != problemContentType {
+			t.Fatalf("step %d: rate limited requests should get a problem, received : %s", i, w.Body.String())
+		}
+	}
+	for i, step := range steps {
+		run(i, step)
+	}
+
+	// authenticated clients are limited by client, wherever they call from
+	s.Auth, err = auth.New(conf.AuthOptions{
+		APIKeys: []conf.APIKeyOptions{{Client: "web", SHA256: auth.HashKey("web-key")}},
+		Clients: map[string]conf.ClientOptions{"web": {Scopes: []string{auth.ScopeSearch}}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating authenticator: %s", err)
+	}
+	steps = []request{
+		{target: "/search?qt=droid&i=droids", remote: "192.0.2.10:1", key: "web-key", status: http.StatusOK},
+		{target: "/search?qt=droid&i=droids", remote: "192.0.2.11:1", key: "web-key", status: http.StatusOK},
+		{target: "/search?qt=droid&i=droids", remote: "192.0.2.1:1", key: "web-key", status: http.StatusTooManyRequests},
+		// IPs are limited before their requests are authenticated
+		{target: "/search?qt=droid&i=droids", remote: "203.0.113.7:5", key: "web-key", status: http.StatusTooManyRequests},
+		{target: "/search?qt=droid&i=droids", remote: "203.0.113.7:6", key: "wrong-key", status: http.StatusTooManyRequests},
+		{target: "/search?qt=droid&i=droids", remote: "198.51.100.1:2", key: "wrong-key", status: http.StatusUnauthorized},
+	}
+	for i, step := range steps {
+		run(i, step)
+	}
+
+	// tokens are refilled over time
+	now = now.Add(2 * time.Second)
+	run(0, request{target: "/search?qt=droid&i=droids", remote: "192.0.2.1:1", key: "web-key", status: http.StatusOK})
+
+	// requests aren't failed for want of a store
+	l.store = failingStore{}
+	run(0, request{target: "/search?qt=droid&i=droids", remote: "192.0.2.1:1", key: "web-key", status: http.StatusOK, headers: map[string]string{"RateLimit-Limit": ""}})
+}
+
+func TestIPRateLimits(t *testing.T) {
+	s, _ := newMemoryServer(t)
+	l, err := NewRateLimiter(conf.RateLimitOptions{
+		Routes:   map[string]conf.RateLimit{"/search": {RequestsPerSecond: 0.5, Burst: 1}},
+		IPRoutes: map[string]conf.RateLimit{"/search": {RequestsPerSecond: 0.5, Burst: 3}},
+	}, NewMemoryStore())
+	if err != nil {
+		t.Fatalf("Unexpected error creating rate limiter: %s", err)
+	}
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	s.RateLimiter = l
+
+	// unauthenticated requests are only limited by IP
+	for i, status := range []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/search?qt=droid&i=droids", nil)
+		req.RemoteAddr = "203.0.113.7:1"
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Fatalf("request %d: status code - expected : %d, received : %d (%s)", i, status, w.Code, w.Body.String())
+		}
+		if diff := cmp.Diff("3", w.Header().Get("RateLimit-Limit")); diff != "" {
+			t.Fatalf("request %d: %s", i, diff)
+		}
+	}
+}
@@ -10,10 +10,11 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"github.com/wambozi/elastic-search-api/m/conf"
+	"github.com/wambozi/elastic-search-api/m/pkg/auth"
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
 )
 
 //Persist saves data in a datastore
@@ -26,6 +27,9 @@ func (st *Elasticsearch) String() string {
 	return st.Cluster
 }
 
+// defaultSearchTimeout bounds routes querying Elasticsearch when no timeout is configured
+const defaultSearchTimeout = 10 * time.Second
+
 //Elasticsearch defines datastore
 type Elasticsearch struct {
 	Cluster string
@@ -33,14 +37,26 @@ type Elasticsearch struct {
 
 //Server defines storage and a router
 type Server struct {
-	ElasticClient *elasticsearch.Client
-	Router        *httprouter.Router
-	Log           *logrus.Logger
+	Config   *conf.Configuration
+	Searcher searching.Searcher
+	// QueryLog is drained when the server shuts down, unless it is nil
+	QueryLog *searching.QueryLog
+	// Profiles are the search profiles searches can name, only the default one when nil
+	Profiles *searching.Profiles
+	// Auth authenticates the requests to the routes requiring a scope, which are open when it is nil
+	Auth *auth.Authenticator
+	// RateLimiter limits the requests of each client to the routes with a limit, none when nil
+	RateLimiter *RateLimiter
+	Router      *httprouter.Router
+	Log         *logrus.Logger
+
+	// shuttingDown is set once the server received a signal to shut down, see shutDown
+	shuttingDown int32
 }
 
-//NewServer sets up storage, router and routes
-func NewServer(c *conf.Configuration, ec *elasticsearch.Client, r *httprouter.Router, log *logrus.Logger) *Server {
-	server := &Server{ElasticClient: ec, Router: r, Log: log}
+//NewServer sets up storage, router and routes. The query log is the one the searcher logs to, if any.
+func NewServer(c *conf.Configuration, sr searching.Searcher, ql *searching.QueryLog, r *httprouter.Router, log *logrus.Logger) *Server {
+	server := &Server{Config: c, Searcher: sr, QueryLog: ql, Router: r, Log: log}
 	server.routes()
 	return server
 }
@@ -75,11 +91,27 @@ func (s *Server) shutdownOnSignal(serv *http.Server, wg *sync.WaitGroup, once *s
 	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, os.Interrupt)
 	sig := <-signals
 	s.Log.Infof("Received signal : %v. Server shutting down.", sig)
+
+	// fail readiness first, and keep serving while load balancers take the server out of rotation
+	s.shutDown()
+	if ms := s.config().Server.ShutdownDelayMillis; ms > 0 {
+		s.Log.Infof("Draining for %d milliseconds before shutting down", ms)
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	}
+
 	ctxShutDown, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 
 	defer func(cnc context.CancelFunc, wgp *sync.WaitGroup, onceP *sync.Once, errsP chan<- error, logP *logrus.Logger) {
 		//extra cleanup can be done here (e.g. closing database connection)
-		logP.Infof("Extra cleanup - closing the following connection : %+v", s.ElasticClient)
+		logP.Infof("Extra cleanup - closing the following connection : %+v", s.Searcher)
+
+		// the HTTP server is shut down, so no more queries can be logged: drain the ones still queued
+		if s.QueryLog != nil {
+			if err := s.QueryLog.Close(ctxShutDown); err != nil {
+				errsP <- fmt.Errorf("Query log error: %w", err)
+			}
+			logP.Infof("Query log drained : %+v", s.QueryLog.Stats())
+		}
 
 		cnc()
 
@@ -97,6 +129,82 @@ func (s *Server) shutdownOnSignal(serv *http.Server, wg *sync.WaitGroup, once *s
 	}
 }
 
+// config returns the configuration of the server, or an empty one for servers built without it
+func (s *Server) config() *conf.Configuration {
+	if s.Config == nil {
+		return &conf.Configuration{}
+	}
+	return s.Config
+}
+
+// indexMap returns the indices the client of the request can search, and the names it searches them by
+func (s *Server) indexMap(r *http.Request) searching.IndexMap {
+	c := s.config().Indices
+	m := searching.IndexMap{Aliases: c.Aliases, Allow: c.Allow}
+	if client := auth.FromContext(r.Context()); client != nil {
+		m.Client = client.Indices
+	}
+	return m
+}
+
+// sortAllowlist returns the fields that can be sorted on
+func (s *Server) sortAllowlist() searching.SortAllowlist {
+	a := searching.SortAllowlist{}
+	for index, fields := range s.config().Sortable {
+		for _, f := range fields {
+			a[index] = append(a[index], searching.SortableField{Field: f.Field, Type: f.Type})
+		}
+	}
+	return a
+}
+
+// tiebreakers returns the fields that break ties between hits
+func (s *Server) tiebreakers() searching.Tiebreakers {
+	return searching.Tiebreakers(s.config().Tiebreakers)
+}
+
+// querySyntax returns the query syntax of advanced searches
+func (s *Server) querySyntax() searching.QuerySyntax {
+	c := s.config().QuerySyntax
+	return searching.QuerySyntax{Fields: c.Fields, Site: c.Site, Date: c.Date}
+}
+
+// SearchProfiles returns the search profiles of the configuration
+func SearchProfiles(c *conf.Configuration) map[string]searching.Profile {
+	profiles := map[string]searching.Profile{}
+	for name, p := range c.Profiles {
+		profile := searching.Profile{
+			Fields:             p.Fields,
+			Type:               p.Type,
+			Fuzziness:          p.Fuzziness,
+			Operator:           p.Operator,
+			MinimumShouldMatch: p.MinimumShouldMatch,
+			BoostMode:          p.BoostMode,
+			Template:           p.Template,
+		}
+		if d := p.Decay; d != nil {
+			profile.Decay = &searching.Decay{Function: d.Function, Field: d.Field, Origin: d.Origin, Scale: d.Scale, Offset: d.Offset, DecayValue: d.DecayValue}
+		}
+		if pop := p.Popularity; pop != nil {
+			profile.Popularity = &searching.Popularity{Field: pop.Field, Factor: pop.Factor, Modifier: pop.Modifier, Missing: pop.Missing}
+		}
+		profiles[name] = profile
+	}
+	return profiles
+}
+
+// routeTimeout returns the timeout configured for the route, falling back on the search timeout
+func (s *Server) routeTimeout(route string) time.Duration {
+	c := s.config().Server
+	if ms, ok := c.RouteTimeoutsMillis[route]; ok && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	if c.SearchTimeoutMillis > 0 {
+		return time.Duration(c.SearchTimeoutMillis) * time.Millisecond
+	}
+	return defaultSearchTimeout
+}
+
 func closeChannel(once *sync.Once, channel chan<- error) {
 	once.Do(
 		func() {
@@ -105,6 +213,27 @@ func closeChannel(once *sync.Once, channel chan<- error) {
 }
 
 func (s *Server) routes() {
-	s.Router.HandlerFunc("POST", "/search", s.execDurLog(s.reqResLog(s.handleCrawl())))
-	s.Router.HandlerFunc("GET", "/search", s.execDurLog(s.reqResLog(s.handleCrawl())))
+	// probes and scrapes are requested every few seconds, so skip logging and tracing them
+	s.Router.HandlerFunc("GET", "/healthcheck", s.withRequestID(s.withMetrics("/healthcheck", s.handleHealthz())))
+	s.Router.HandlerFunc("GET", "/healthz", s.withRequestID(s.withMetrics("/healthz", s.handleHealthz())))
+	s.Router.HandlerFunc("GET", "/readyz", s.withRequestID(s.withMetrics("/readyz", s.withTimeout("/readyz", s.handleReadyz()))))
+	s.Router.HandlerFunc("GET", "/metrics", s.withRequestID(s.handleMetrics()))
+	s.handle("POST", "/search", auth.ScopeSearch, s.handleCrawl())
+	s.handle("GET", "/search", auth.ScopeSearch, s.handleCrawl())
+	// suggestions are requested on every keystroke, so skip logging their request and response bodies
+	s.Router.HandlerFunc("GET", "/suggest", s.withRequestID(s.withTracing("/suggest", s.withMetrics("/suggest", s.withIPRateLimit("/suggest", s.execDurLog(s.withAuth(auth.ScopeSearch, s.withClientRateLimit("/suggest", s.withTimeout("/suggest", s.handleSuggest())))))))))
+	s.handle("GET", "/analytics/top-queries", auth.ScopeAnalytics, s.handleTopQueries())
+	s.handle("GET", "/analytics/trending", auth.ScopeAnalytics, s.handleTrendingQueries())
+	s.handle("POST", "/analytics/click", auth.ScopeSearch, s.handleClick())
+	s.handle("GET", "/admin/pins", auth.ScopeAdmin, s.handleGetPins())
+	s.handle("PUT", "/admin/pins", auth.ScopeAdmin, s.handlePutPin())
+	s.handle("DELETE", "/admin/pins", auth.ScopeAdmin, s.handleDeletePin())
+	s.handle("GET", "/admin/rules", auth.ScopeAdmin, s.handleGetRules())
+	s.handle("PUT", "/admin/rules", auth.ScopeAdmin, s.handlePutRule())
+	s.handle("DELETE", "/admin/rules", auth.ScopeAdmin, s.handleDeleteRule())
+}
+
+// handle registers h for the route, requiring the scope, behind the middleware routes share
+func (s *Server) handle(method, path, scope string, h http.HandlerFunc) {
+	s.Router.HandlerFunc(method, path, s.withRequestID(s.withTracing(path, s.withMetrics(path, s.withIPRateLimit(path, s.execDurLog(s.reqResLog(s.withAuth(scope, s.withClientRateLimit(path, s.withTimeout(path, h))))))))))
 }
@@ -11,13 +11,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"github.com/wambozi/elastic-search-api/m/conf"
-	"github.com/wambozi/elastic-search-api/m/pkg/clients"
-)
-
-var (
-	ee       = "http://localhost:9200"
-	username = "elastic"
-	password = "changeme"
+	"github.com/wambozi/elastic-search-api/m/pkg/searching"
 )
 
 func TestCloseChannel(t *testing.T) {
@@ -56,12 +50,7 @@ func TestNewServer(t *testing.T) {
 	c := conf.Configuration{Server: conf.ServerConfiguration{Port: 8080, ReadHeaderTimeoutMillis: 3000}}
 	r := httprouter.New()
 	l := logrus.New()
-	cfg := clients.GenerateElasticConfig([]string{ee}, username, password)
-	ec, err := clients.CreateElasticClient(cfg)
-	if err != nil {
-		t.Errorf("Unexpected error creating Elasticsearch client: %s", err)
-	}
-	actual := NewServer(&c, ec, r, l)
+	actual := NewServer(&c, searching.NewMemory(), nil, r, l)
 
 	if actual.Router == nil {
 		t.Fatalf("router should not be nil")
@@ -74,12 +63,7 @@ func TestNewHttpServer(t *testing.T) {
 	c := conf.Configuration{Server: conf.ServerConfiguration{Port: 8080, ReadHeaderTimeoutMillis: 3000}}
 	r := httprouter.New()
 	l := logrus.New()
-	cfg := clients.GenerateElasticConfig([]string{ee}, username, password)
-	ec, err := clients.CreateElasticClient(cfg)
-	if err != nil {
-		t.Errorf("Unexpected error creating Elasticsearch client: %s", err)
-	}
-	s := NewServer(&c, ec, r, l)
+	s := NewServer(&c, searching.NewMemory(), nil, r, l)
 
 	httpServer := s.NewHTTPServer(&c)
 	if httpServer == nil {
@@ -114,12 +98,7 @@ func TestShutdownOnSignal(t *testing.T) {
 	c := conf.Configuration{Server: conf.ServerConfiguration{Port: 8080, ReadHeaderTimeoutMillis: 3000}}
 	r := httprouter.New()
 	l := logrus.New()
-	cfg := clients.GenerateElasticConfig([]string{ee}, username, password)
-	ec, err := clients.CreateElasticClient(cfg)
-	if err != nil {
-		t.Errorf("Unexpected error creating Elasticsearch client: %s", err)
-	}
-	server := NewServer(&c, ec, r, l)
+	server := NewServer(&c, searching.NewMemory(), nil, r, l)
 
 	httpServer := server.NewHTTPServer(&c)
 
@@ -156,12 +135,7 @@ func TestBegin(t *testing.T) {
 	c := conf.Configuration{Server: conf.ServerConfiguration{Port: 8080, ReadHeaderTimeoutMillis: 3000}}
 	r := httprouter.New()
 	l := logrus.New()
-	cfg := clients.GenerateElasticConfig([]string{ee}, username, password)
-	ec, err := clients.CreateElasticClient(cfg)
-	if err != nil {
-		t.Errorf("Unexpected error creating Elasticsearch client: %s", err)
-	}
-	server := NewServer(&c, ec, r, l)
+	server := NewServer(&c, searching.NewMemory(), nil, r, l)
 	httpServer := server.NewHTTPServer(&c)
 
 	wg.Add(1)
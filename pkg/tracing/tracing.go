@@ -0,0 +1,109 @@
+// Package tracing sets up OpenTelemetry to trace the work done for a request. Traces continue across
+// services through the W3C traceparent header, which otelhttp reads on the routes and writes on the requests
+// made to Elasticsearch. The API's own work is traced with spans started from the request's context:
+//
+//	ctx, span := tracing.Start(r.Context(), "searching.Search")
+//	defer span.End()
+//
+// Spans are recorded only when an exporter is set up, for the traces the sampler keeps. Unrecorded spans
+// still carry the trace on to the services called.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultServiceName = "elastic-search-api"
+	// instrumentationName names the tracer of the API's own spans
+	instrumentationName = "github.com/wambozi/elastic-search-api/m"
+)
+
+// Setup sets the tracer provider spans are started with from the options, and the W3C trace context
+// propagator. It returns the provider, to be shut down when the API stops so the spans still queued are
+// sent, or nil when tracing is off.
+func Setup(opts conf.TracingOptions, logger *logrus.Logger) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Errorf("Error tracing: %s", err)
+	}))
+
+	sampler, err := Sampler(opts.SampleRatio)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := newExporter(opts)
+	if err != nil || exporter == nil {
+		return nil, err
+	}
+
+	service := opts.ServiceName
+	if service == "" {
+		service = defaultServiceName
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider, nil
+}
+
+// Sampler returns the sampler keeping the ratio of the traces started by the API, all of them when it is
+// nil. Traces continued from a caller are kept when the caller sampled them.
+func Sampler(ratio *float64) (sdktrace.Sampler, error) {
+	if ratio == nil {
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	}
+	if *ratio < 0 || *ratio > 1 {
+		return nil, fmt.Errorf("trace sample ratio must be between 0 and 1, got %g", *ratio)
+	}
+	return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*ratio)), nil
+}
+
+// newExporter returns the exporter of the options, or nil when there is none
+func newExporter(opts conf.TracingOptions) (sdktrace.SpanExporter, error) {
+	switch opts.Exporter {
+	case "":
+		return nil, nil
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		if opts.Endpoint == "" {
+			return nil, fmt.Errorf("the otlp trace exporter needs an endpoint")
+		}
+		return otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(opts.Endpoint), otlptracehttp.WithHeaders(opts.Headers))
+	default:
+		return nil, fmt.Errorf("trace exporter must be stdout or otlp, got %q", opts.Exporter)
+	}
+}
+
+// Start starts a span, internal unless the options say otherwise, as the child of the span of the context
+// if it has one. It returns the context to start the span's children with. The span must be ended.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// Fail marks the span failed with err, unless it is nil
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
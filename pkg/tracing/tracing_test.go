@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	"github.com/wambozi/elastic-search-api/m/conf"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	half, tooMuch := 0.5, 1.5
+
+	tests := map[string]struct {
+		opts     conf.TracingOptions
+		provider bool
+		err      string
+	}{
+		"off":          {opts: conf.TracingOptions{}},
+		"stdout":       {opts: conf.TracingOptions{Exporter: "stdout", SampleRatio: &half}, provider: true},
+		"otlp":         {opts: conf.TracingOptions{Exporter: "otlp", Endpoint: "http://localhost:4318/v1/traces"}, provider: true},
+		"no endpoint":  {opts: conf.TracingOptions{Exporter: "otlp"}, err: "the otlp trace exporter needs an endpoint"},
+		"unknown":      {opts: conf.TracingOptions{Exporter: "zipkin"}, err: `trace exporter must be stdout or otlp, got "zipkin"`},
+		"sample ratio": {opts: conf.TracingOptions{Exporter: "stdout", SampleRatio: &tooMuch}, err: "trace sample ratio must be between 0 and 1, got 1.5"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider, err := Setup(tc.opts, logrus.New())
+			msg := ""
+			if err != nil {
+				msg = err.Error()
+			}
+			if diff := cmp.Diff(tc.err, msg); diff != "" {
+				t.Fatalf(diff)
+			}
+			if (provider != nil) != tc.provider {
+				t.Fatalf("provider - expected : %t, received : %+v", tc.provider, provider)
+			}
+			if provider != nil {
+				provider.Shutdown(context.Background())
+			}
+		})
+	}
+}
+
+func TestSampler(t *testing.T) {
+	none, all := 0.0, 1.0
+	parent := func(sampled bool) context.Context {
+		sc := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+			SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+			Remote:  true,
+		})
+		if sampled {
+			sc = sc.WithTraceFlags(trace.FlagsSampled)
+		}
+		return trace.ContextWithRemoteSpanContext(context.Background(), sc)
+	}
+
+	tests := map[string]struct {
+		ratio   *float64
+		ctx     context.Context
+		sampled bool
+	}{
+		"default":            {ctx: context.Background(), sampled: true},
+		"all":                {ratio: &all, ctx: context.Background(), sampled: true},
+		"none":               {ratio: &none, ctx: context.Background()},
+		"sampled parent":     {ratio: &none, ctx: parent(true), sampled: true},
+		"unsampled parent":   {ratio: &all, ctx: parent(false)},
+		"default, unsampled": {ctx: parent(false)},
+		"default, sampled":   {ctx: parent(true), sampled: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			sampler, err := Sampler(tc.ratio)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler))
+			defer provider.Shutdown(context.Background())
+
+			_, span := provider.Tracer("test").Start(tc.ctx, "test")
+			defer span.End()
+			if got := span.SpanContext().IsSampled(); got != tc.sampled {
+				t.Fatalf("sampled - expected : %t, received : %t", tc.sampled, got)
+			}
+		})
+	}
+}
+
+func TestFail(t *testing.T) {
+	tests := map[string]struct {
+		err    error
+		status string
+	}{
+		"error":    {err: context.DeadlineExceeded, status: "Error"},
+		"no error": {status: "Unset"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider := sdktrace.NewTracerProvider()
+			defer provider.Shutdown(context.Background())
+
+			_, span := provider.Tracer("test").Start(context.Background(), "test")
+			Fail(span, tc.err)
+			span.End()
+			if diff := cmp.Diff(tc.status, span.(sdktrace.ReadOnlySpan).Status().Code.String()); diff != "" {
+				t.Fatalf(diff)
+			}
+		})
+	}
+}